@@ -0,0 +1,61 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"flag"
+	"time"
+)
+
+var (
+	StoreType = flag.String("audit.store_type", "json",
+		"queue audit log storage backend type")
+	StoreFilename = flag.String("audit.store_filename", "audit.json",
+		"filename in which to store queue audit logs (relative to config_dir)")
+	Retention = flag.Duration("audit.retention", 30*24*time.Hour,
+		"how long to keep queue audit log entries")
+	MaxEntries = flag.Int("audit.max_entries", 1000,
+		"maximum number of queue audit log entries kept per guild")
+)
+
+// Entry records a single change to a queue.
+type Entry struct {
+	At       time.Time `json:"at"`
+	Queue    string    `json:"queue"`
+	Action   string    `json:"action"`
+	ActorId  string    `json:"actor_id"`
+	Actor    string    `json:"actor"`
+	TargetId string    `json:"target_id,omitempty"`
+	Target   string    `json:"target,omitempty"`
+	Position int       `json:"position,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+}
+
+type Log interface {
+	// Append the entry to the guild's log
+	Append(guild_id string, entry Entry) error
+
+	// Iterate over every guild's entries, oldest first
+	Iterate(func(guild_id string, entry Entry))
+
+	// Return (at most) the +n+ most recent of the guild's entries, oldest
+	// first
+	Recent(guild_id string, n int) ([]Entry, error)
+}
+
+// Expired reports whether the entry is older than the retention period.
+func (e *Entry) Expired(now time.Time) bool {
+	return *Retention > 0 && e.At.Add(*Retention).Before(now)
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package json
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/spacemonkeygo/spacelog"
+
+	"xmtp.net/xmtpbot/audit"
+	"xmtp.net/xmtpbot/audit/memory"
+)
+
+const (
+	dirPerms  = 0700
+	filePerms = 0600
+)
+
+var (
+	logger = spacelog.GetLogger()
+)
+
+type record struct {
+	GuildId string      `json:"guild_id"`
+	Entry   audit.Entry `json:"entry"`
+}
+
+type log struct {
+	filename string
+	mem      audit.Log
+	mtx      sync.Mutex
+}
+
+func New(filename string) audit.Log {
+	l := log{
+		filename: filename,
+		mem:      memory.New(),
+	}
+	if err := l.load(); err != nil {
+		logger.Warnf("falling back to memory audit log: %v", err)
+		return memory.New()
+	}
+	logger.Infof("audit log initialized at: %q", filename)
+
+	return &l
+}
+
+func (l *log) Append(guild_id string, entry audit.Entry) (err error) {
+	l.mtx.Lock()
+	err = l.mem.Append(guild_id, entry)
+	l.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return l.flush()
+}
+
+func (l *log) Iterate(fn func(guild_id string, entry audit.Entry)) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.mem.Iterate(fn)
+}
+
+func (l *log) Recent(guild_id string, n int) ([]audit.Entry, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.mem.Recent(guild_id, n)
+}
+
+func (l *log) flush() (err error) {
+	var records []record
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.mem.Iterate(func(guild_id string, entry audit.Entry) {
+		records = append(records, record{
+			GuildId: guild_id,
+			Entry:   entry,
+		})
+	})
+
+	bytes, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(l.filename, bytes, filePerms)
+}
+
+func (l *log) load() (err error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	bytes, err := ioutil.ReadFile(l.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return os.MkdirAll(filepath.Dir(l.filename), dirPerms)
+		}
+		return err
+	}
+	if len(bytes) == 0 {
+		return nil
+	}
+
+	var records []record
+	err = json.Unmarshal(bytes, &records)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		err = l.mem.Append(record.GuildId, record.Entry)
+		if err != nil {
+			logger.Errore(err)
+			continue
+		}
+	}
+	logger.Debugf("loaded %d audit log entries from JSON store",
+		len(records))
+
+	return nil
+}
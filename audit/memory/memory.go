@@ -0,0 +1,98 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/spacelog"
+
+	"xmtp.net/xmtpbot/audit"
+)
+
+var (
+	logger = spacelog.GetLogger()
+)
+
+type log struct {
+	entries map[string][]audit.Entry
+	mtx     sync.Mutex
+}
+
+func New() audit.Log {
+	l := &log{
+		entries: make(map[string][]audit.Entry),
+	}
+	logger.Info("audit log initialized")
+
+	return l
+}
+
+func (l *log) Append(guild_id string, entry audit.Entry) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+	l.entries[guild_id] = prune(append(l.entries[guild_id], entry),
+		time.Now())
+
+	return nil
+}
+
+func (l *log) Iterate(fn func(guild_id string, entry audit.Entry)) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	for guild_id, entries := range l.entries {
+		for _, entry := range entries {
+			fn(guild_id, entry)
+		}
+	}
+}
+
+func (l *log) Recent(guild_id string, n int) ([]audit.Entry, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	entries := prune(l.entries[guild_id], time.Now())
+	l.entries[guild_id] = entries
+	if n >= 0 && len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+
+	recent := make([]audit.Entry, len(entries))
+	copy(recent, entries)
+
+	return recent, nil
+}
+
+// prune drops entries that are expired, or in excess of audit.MaxEntries.
+func prune(entries []audit.Entry, now time.Time) []audit.Entry {
+	first := 0
+	for first < len(entries) && entries[first].Expired(now) {
+		first++
+	}
+	if *audit.MaxEntries > 0 && len(entries)-first > *audit.MaxEntries {
+		first = len(entries) - *audit.MaxEntries
+	}
+	if first == 0 {
+		return entries
+	}
+
+	return append([]audit.Entry(nil), entries[first:]...)
+}
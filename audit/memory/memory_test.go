@@ -0,0 +1,70 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"xmtp.net/xmtpbot/audit"
+	"xmtp.net/xmtpbot/test"
+)
+
+func TestRecent(t *testing.T) {
+	test := test.New(t)
+	l := New()
+
+	test.AssertNil(l.Append("guild", audit.Entry{Action: "enqueue"}))
+	test.AssertNil(l.Append("guild", audit.Entry{Action: "dequeue"}))
+	test.AssertNil(l.Append("other", audit.Entry{Action: "clear"}))
+
+	entries, err := l.Recent("guild", 1)
+	test.AssertNil(err)
+	test.AssertEqual(len(entries), 1)
+	test.AssertEqual(entries[0].Action, "dequeue")
+	test.Assert(!entries[0].At.IsZero(), "At should default to now")
+
+	entries, err = l.Recent("guild", -1)
+	test.AssertNil(err)
+	test.AssertEqual(len(entries), 2)
+}
+
+func TestRetention(t *testing.T) {
+	test := test.New(t)
+	l := New()
+
+	defer func(max int, retention time.Duration) {
+		*audit.MaxEntries = max
+		*audit.Retention = retention
+	}(*audit.MaxEntries, *audit.Retention)
+	*audit.MaxEntries = 2
+	*audit.Retention = time.Hour
+
+	test.AssertNil(l.Append("guild", audit.Entry{
+		Action: "enqueue",
+		At:     time.Now().Add(-2 * time.Hour),
+	}))
+	entries, err := l.Recent("guild", 10)
+	test.AssertNil(err)
+	test.AssertEqual(len(entries), 0)
+
+	test.AssertNil(l.Append("guild", audit.Entry{Action: "enqueue"}))
+	test.AssertNil(l.Append("guild", audit.Entry{Action: "take"}))
+	test.AssertNil(l.Append("guild", audit.Entry{Action: "clear"}))
+	entries, err = l.Recent("guild", 10)
+	test.AssertNil(err)
+	test.AssertEqual(len(entries), 2)
+	test.AssertEqual(entries[0].Action, "take")
+}
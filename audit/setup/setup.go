@@ -0,0 +1,34 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package setup
+
+import (
+	"strings"
+
+	"xmtp.net/xmtpbot/audit"
+	"xmtp.net/xmtpbot/audit/json"
+	"xmtp.net/xmtpbot/audit/memory"
+)
+
+func NewLog(filename string) audit.Log {
+	switch strings.ToLower(*audit.StoreType) {
+	case "json":
+		return json.New(filename)
+	case "memory":
+	default:
+	}
+
+	return memory.New()
+}
@@ -0,0 +1,427 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ewollesen/discordgo"
+	"github.com/gorilla/mux"
+	"xmtp.net/xmtpbot/queue"
+	"xmtp.net/xmtpbot/util"
+)
+
+const (
+	bucketAPITokens = "discord.api_tokens"
+
+	apiUserId  = "api"
+	maxAPIBody = 64 * 1024
+)
+
+type apiEnqueueRequest struct {
+	UserId    string `json:"user_id"`
+	Username  string `json:"username"`
+	BattleTag string `json:"battle_tag"`
+	Position  int    `json:"position"`
+}
+
+type apiTakeRequest struct {
+	N       int    `json:"n"`
+	Details string `json:"details"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func (b *bot) receiveAPIRouter(router *mux.Router) {
+	router.HandleFunc("/api/queues/{guild_id}",
+		b.apiHandler(b.apiList)).Methods("GET")
+	router.HandleFunc("/api/queues/{guild_id}/entries",
+		b.apiHandler(b.apiEnqueue)).Methods("POST")
+	router.HandleFunc("/api/queues/{guild_id}/entries/{user_id}",
+		b.apiHandler(b.apiRemove)).Methods("DELETE")
+	router.HandleFunc("/api/queues/{guild_id}/take",
+		b.apiHandler(b.apiTake)).Methods("POST")
+	router.HandleFunc("/api/queues/{guild_id}/clear",
+		b.apiHandler(b.apiClear)).Methods("POST")
+}
+
+// apiHandler authenticates the request with the guild's API token, and
+// looks up the queue named by the queue query parameter (the default queue
+// if it's absent) before calling fn.
+func (b *bot) apiHandler(fn func(w http.ResponseWriter, req *http.Request,
+	q *scrimQueue)) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		guild_id := mux.Vars(req)["guild_id"]
+		if !b.apiAuthorized(guild_id, req) {
+			writeAPIError(w, http.StatusUnauthorized, "invalid API token")
+			return
+		}
+
+		name := strings.ToLower(req.URL.Query().Get("queue"))
+		if name != "" && !b.queue_registry.Exists(guild_id, name) {
+			writeAPIError(w, http.StatusNotFound,
+				fmt.Sprintf("there is no %s queue", name))
+			return
+		}
+
+		fn(w, req, b.lookupNamedQueue(guild_id, name))
+	}
+}
+
+func (b *bot) apiList(w http.ResponseWriter, req *http.Request,
+	q *scrimQueue) {
+
+	dq, err := b.describeQueue(q, time.Now())
+	if err != nil {
+		logger.Errore(err)
+		writeAPIError(w, http.StatusInternalServerError,
+			"failed to list queue")
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, dq)
+}
+
+func (b *bot) apiEnqueue(w http.ResponseWriter, req *http.Request,
+	q *scrimQueue) {
+
+	var body apiEnqueueRequest
+	if !readAPIRequest(w, req, &body) {
+		return
+	}
+	if body.UserId == "" {
+		writeAPIError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+	if !q.gameIdType().Valid(body.BattleTag) {
+		writeAPIError(w, http.StatusBadRequest,
+			fmt.Sprintf("battle_tag %q appears to be invalid",
+				body.BattleTag))
+		return
+	}
+	if body.Position < 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid position")
+		return
+	}
+
+	live_session := b.liveSession()
+	a := &author{
+		User: &discordgo.User{
+			ID:       body.UserId,
+			Username: body.Username,
+		},
+		GuildId: q.guild_id,
+		session: live_session,
+	}
+	a.SetBattleTag(body.BattleTag)
+	b.prepareQueued(q, a)
+
+	if pos := q.waitlistPosition(a.Key()); pos > -1 {
+		writeAPIError(w, http.StatusConflict, fmt.Sprintf(
+			"user is already waitlisted in position %d", pos))
+		return
+	}
+	if open, msg := b.queueOpen(q); !open {
+		writeAPIError(w, http.StatusForbidden, msg)
+		return
+	}
+
+	var err error
+	if body.Position > 0 {
+		err = q.Insert(body.Position, a)
+	} else {
+		err = q.Enqueue(a)
+	}
+	if err != nil {
+		if queue.AlreadyQueuedError.Contains(err) {
+			writeAPIError(w, http.StatusConflict, fmt.Sprintf(
+				"user is already queued in position %d",
+				q.Position(a.Key())))
+			return
+		}
+		logger.Errore(err)
+		writeAPIError(w, http.StatusInternalServerError,
+			"failed to enqueue")
+		return
+	}
+	action, waitlisted := "add", false
+	pos := q.Position(a.Key())
+	if waitlist_pos := q.waitlistPosition(a.Key()); waitlist_pos > -1 {
+		action, waitlisted, pos = "waitlist", true, waitlist_pos
+	}
+	b.recordQueueEvent(q, action, b.apiActor(q), a, pos, "(api)")
+	b.queueChanged(live_session, q)
+
+	entry := b.describeEntry(q.guild_id, a, pos, time.Now())
+	entry.Waitlisted = waitlisted
+	writeAPIResponse(w, http.StatusCreated, entry)
+}
+
+func (b *bot) apiRemove(w http.ResponseWriter, req *http.Request,
+	q *scrimQueue) {
+
+	key := (&author{
+		User:    &discordgo.User{ID: mux.Vars(req)["user_id"]},
+		GuildId: q.guild_id,
+	}).Key()
+
+	pos := q.Position(key)
+	queueable, err := q.Remove(key)
+	if err != nil {
+		if queue.NotFoundError.Contains(err) {
+			writeAPIError(w, http.StatusNotFound, "user isn't queued")
+			return
+		}
+		logger.Errore(err)
+		writeAPIError(w, http.StatusInternalServerError,
+			"failed to remove")
+		return
+	}
+	a := queueable.(Author)
+	live_session := b.liveSession()
+	b.recordQueueEvent(q, "remove", b.apiActor(q), a, pos, "(api)")
+	b.leftParty(live_session, q, a)
+	b.queueChanged(live_session, q)
+
+	writeAPIResponse(w, http.StatusOK,
+		b.describeEntry(q.guild_id, a, pos, time.Now()))
+}
+
+func (b *bot) apiTake(w http.ResponseWriter, req *http.Request,
+	q *scrimQueue) {
+
+	body := apiTakeRequest{N: defaultNumTaken}
+	if !readAPIRequest(w, req, &body) {
+		return
+	}
+	if body.N < 1 {
+		writeAPIError(w, http.StatusBadRequest, "invalid n")
+		return
+	}
+
+	taken, err := b.takeEntries(q, body.N)
+	if err != nil {
+		logger.Errore(err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to take")
+		return
+	}
+
+	now := time.Now()
+	entries := []dashboardEntry{}
+	for idx, queueable := range taken {
+		a := queueable.(Author)
+		b.recordQueueEvent(q, "take", b.apiActor(q), a, idx+1, "(api)")
+		entries = append(entries, b.describeEntry(q.guild_id, a, idx+1,
+			now))
+	}
+	if err = b.resetSkips(q, taken); err != nil {
+		logger.Errore(err)
+	}
+	live_session := b.liveSession()
+	if live_session != nil {
+		b.notifyTaken(live_session, q, taken, body.Details)
+		authors := []Author{}
+		for _, queueable := range taken {
+			authors = append(authors, queueable.(Author))
+		}
+		b.openLobby(live_session, q, nil, authors, body.Details, "")
+	}
+	b.queueChanged(live_session, q)
+
+	writeAPIResponse(w, http.StatusOK, entries)
+}
+
+func (b *bot) apiClear(w http.ResponseWriter, req *http.Request,
+	q *scrimQueue) {
+
+	size := q.Size()
+	err := q.Clear()
+	if err != nil {
+		logger.Errore(err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to clear")
+		return
+	}
+	b.recordQueueEvent(q, "clear", b.apiActor(q), nil, 0,
+		fmt.Sprintf("(%d entries, api)", size))
+	b.queueChanged(b.liveSession(), q)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiActor is the Author to which changes made through the API are
+// attributed.
+func (b *bot) apiActor(q *scrimQueue) Author {
+	return &author{
+		User: &discordgo.User{
+			ID:       apiUserId,
+			Username: "API",
+		},
+		GuildId: q.guild_id,
+	}
+}
+
+func (b *bot) apiAuthorized(guild_id string, req *http.Request) bool {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+
+	return b.tokenAuthorized(guild_id, strings.TrimPrefix(header, "Bearer "))
+}
+
+// dashboardAuthorized accepts the guild's API token in the token query
+// parameter too, as browsers can't set headers on the dashboard's requests.
+func (b *bot) dashboardAuthorized(guild_id string, req *http.Request) bool {
+	if token := req.URL.Query().Get("token"); token != "" {
+		return b.tokenAuthorized(guild_id, token)
+	}
+
+	return b.apiAuthorized(guild_id, req)
+}
+
+func (b *bot) tokenAuthorized(guild_id, token string) bool {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return false
+	}
+
+	stored, err := b.store.Get(b.apiTokenStoreKey(guild_id))
+	if err != nil {
+		logger.Errore(err)
+		return false
+	}
+	if stored == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(stored),
+		[]byte(hashAPIToken(token))) == 1
+}
+
+// queueToken issues (or revokes) the guild's API token. The token is sent in
+// a DM, and only its hash is stored.
+func (b *bot) queueToken(cmd Command) string {
+	ok, err := cmd.Author().PermittedTo(discordgo.PermissionManageServer)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	guild_id, err := cmd.Session().GuildIdFromChannelId(
+		cmd.Message().ChannelID)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error looking up guild: %s", err)
+	}
+
+	switch strings.TrimSpace(cmd.Args()) {
+	case "":
+	case "revoke":
+		err = b.store.Set(b.apiTokenStoreKey(guild_id), "")
+		if err != nil {
+			logger.Errore(err)
+			return fmt.Sprintf("Error revoking the API token: %s", err)
+		}
+		return "Revoked the API token."
+	default:
+		return "Try `!queue token` or `!queue token revoke`."
+	}
+
+	token, err := util.RandomState(32)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error generating an API token: %s", err)
+	}
+	err = b.store.Set(b.apiTokenStoreKey(guild_id), hashAPIToken(token))
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error saving the API token: %s", err)
+	}
+
+	err = sendDM(cmd.Session(), cmd.Author().UserId(), fmt.Sprintf(
+		"The queue API token for guild %s is `%s`. Send it in an "+
+			"`Authorization: Bearer <token>` header to %s://%s/discord/"+
+			"api/queues/%s. Any previous token no longer works.",
+		guild_id, token, *protocol, *hostname, guild_id))
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error sending the API token: %s", err)
+	}
+
+	return "Sent you a new API token in a DM. Any previous token no longer " +
+		"works."
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func (b *bot) apiTokenStoreKey(guild_id string) string {
+	return fmt.Sprintf("%s.%s", bucketAPITokens, guild_id)
+}
+
+// readAPIRequest decodes the JSON request body, if any, into v. Returns
+// false if an error was written instead.
+func readAPIRequest(w http.ResponseWriter, req *http.Request,
+	v interface{}) bool {
+
+	if req.ContentLength == 0 {
+		return true
+	}
+
+	err := json.NewDecoder(http.MaxBytesReader(w, req.Body,
+		maxAPIBody)).Decode(v)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest,
+			fmt.Sprintf("failed to parse request: %s", err))
+		return false
+	}
+
+	return true
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeAPIResponse(w, status, apiError{Error: msg})
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, v interface{}) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		logger.Errore(err)
+		http.Error(w, "failed to encode response",
+			http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
@@ -0,0 +1,186 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+var tokenRe = regexp.MustCompile("`([^`]+)`")
+
+func TestQueueToken(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+
+	test.AssertEqual(bot.queueToken(newTestCommand("token", "", session,
+		msg)), "Permission denied.")
+	session.allowAll()
+	test.AssertEqual(bot.queueToken(newTestCommand("token", "", session,
+		msg)), "Sent you a new API token in a DM. Any previous token no "+
+		"longer works.")
+	test.AssertEqual(len(session.dms[testUserId]), 1)
+	token := tokenRe.FindStringSubmatch(session.dms[testUserId][0])[1]
+
+	req := httptest.NewRequest("GET", "/api/queues/"+testGuildId, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	test.Assert(bot.apiAuthorized(testGuildId, req))
+	test.Assert(!bot.apiAuthorized("other", req))
+
+	test.AssertEqual(bot.queueToken(newTestCommand("token", "revoke",
+		session, msg)), "Revoked the API token.")
+	test.Assert(!bot.apiAuthorized(testGuildId, req))
+}
+
+func TestAPI(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	session.allowAll()
+	bot.live_session = session
+	bot.queueToken(newTestCommand("token", "", session,
+		newTestMessage(testUserId, testChannelId)))
+	token := tokenRe.FindStringSubmatch(session.dms[testUserId][0])[1]
+
+	router := mux.NewRouter()
+	test.AssertNil(bot.ReceiveRouter(router))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	base := server.URL + "/api/queues/" + testGuildId
+
+	do := func(method, url, token, body string) (int, string) {
+		var reader io.Reader
+		if body != "" {
+			reader = strings.NewReader(body)
+		}
+		req, err := http.NewRequest(method, url, reader)
+		test.AssertNil(err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		test.AssertNil(err)
+		defer resp.Body.Close()
+		bytes, err := ioutil.ReadAll(resp.Body)
+		test.AssertNil(err)
+		return resp.StatusCode, string(bytes)
+	}
+
+	status, _ := do("GET", base, "wrong", "")
+	test.AssertEqual(status, http.StatusUnauthorized)
+	status, _ = do("GET", base+"?queue=nope", token, "")
+	test.AssertEqual(status, http.StatusNotFound)
+
+	status, _ = do("POST", base+"/entries", token,
+		`{"user_id": "`+testUserId+`", "battle_tag": "`+testBTag+`"}`)
+	test.AssertEqual(status, http.StatusCreated)
+	status, _ = do("POST", base+"/entries", token,
+		`{"user_id": "`+testUserId2+`", "battle_tag": "`+testBTag2+
+			`", "position": 1}`)
+	test.AssertEqual(status, http.StatusCreated)
+	status, _ = do("POST", base+"/entries", token,
+		`{"user_id": "`+testUserId2+`", "battle_tag": "`+testBTag2+`"}`)
+	test.AssertEqual(status, http.StatusConflict)
+	status, _ = do("POST", base+"/entries", token,
+		`{"user_id": "`+testUserId3+`", "battle_tag": "bogus"}`)
+	test.AssertEqual(status, http.StatusBadRequest)
+
+	status, body := do("GET", base, token, "")
+	test.AssertEqual(status, http.StatusOK)
+	dq := &dashboardQueue{}
+	test.AssertNil(json.Unmarshal([]byte(body), dq))
+	test.AssertEqual(len(dq.Entries), 2)
+	test.AssertEqual(dq.Entries[0].BattleTag, testBTag2)
+
+	status, _ = do("DELETE", base+"/entries/"+testUserId2, token, "")
+	test.AssertEqual(status, http.StatusOK)
+	status, _ = do("DELETE", base+"/entries/"+testUserId2, token, "")
+	test.AssertEqual(status, http.StatusNotFound)
+
+	status, body = do("POST", base+"/take", token,
+		`{"n": 1, "details": "lobby foo"}`)
+	test.AssertEqual(status, http.StatusOK)
+	entries := []dashboardEntry{}
+	test.AssertNil(json.Unmarshal([]byte(body), &entries))
+	test.AssertEqual(len(entries), 1)
+	test.AssertEqual(entries[0].BattleTag, testBTag)
+	test.AssertContainsString(session.dms[testUserId], "You were taken "+
+		"from the scrimmages queue! Lobby details: lobby foo")
+
+	do("POST", base+"/entries", token,
+		`{"user_id": "`+testUserId3+`", "battle_tag": "`+testBTag3+`"}`)
+	status, _ = do("POST", base+"/clear", token, "")
+	test.AssertEqual(status, http.StatusNoContent)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	test.AssertEqual(q.Size(), 0)
+
+	// closed queues can't be joined through the API either
+	bot.queueSetOpen(q, newTestCommand("close", "", session,
+		newTestMessage(testUserId, testChannelId)), false)
+	status, _ = do("POST", base+"/entries", token,
+		`{"user_id": "`+testUserId3+`", "battle_tag": "`+testBTag3+`"}`)
+	test.AssertEqual(status, http.StatusForbidden)
+	test.AssertEqual(q.Size(), 0)
+
+	history := server.URL + "/queues/" + testGuildId + "/history"
+	status, _ = do("GET", history, "wrong", "")
+	test.AssertEqual(status, http.StatusUnauthorized)
+	status, body = do("GET", history, token, "")
+	test.AssertEqual(status, http.StatusOK)
+	test.Assert(strings.Contains(body, testUserId), body)
+}
+
+func TestAPIRemovePartyMember(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	session.allowAll()
+	bot.live_session = session
+	bot.queueToken(newTestCommand("token", "", session, msg))
+	token := tokenRe.FindStringSubmatch(session.dms[testUserId][0])[1]
+
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", testBTag+
+		" with <@!"+testUserId2+">", session, msg)))
+	test.AssertNil(bot.party(newTestCommand("party", "accept "+testBTag2,
+		session, newTestMessage(testUserId2, testChannelId))))
+
+	router := mux.NewRouter()
+	test.AssertNil(bot.ReceiveRouter(router))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	req, err := http.NewRequest("DELETE", server.URL+"/api/queues/"+
+		testGuildId+"/entries/"+testUserId2, nil)
+	test.AssertNil(err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	test.AssertNil(err)
+	resp.Body.Close()
+	test.AssertEqual(resp.StatusCode, http.StatusOK)
+
+	// the party of one left behind is dissolved
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	queueables, err := q.List()
+	test.AssertNil(err)
+	test.AssertEqual(len(queueables), 1)
+	test.AssertEqual(queueables[0].(Author).PartyId(), "")
+	entries, err := bot.audit.Recent(testGuildId, 10)
+	test.AssertNil(err)
+	test.AssertEqual(entries[len(entries)-1].Action, "remove")
+}
@@ -17,6 +17,7 @@ package discord
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/ewollesen/discordgo"
 	"xmtp.net/xmtpbot/queue"
@@ -24,16 +25,21 @@ import (
 )
 
 type author struct {
-	BattleTag_ string            `json:"battle_tag"`
-	ChannelId  string            `json:"channel_id"`
-	GuildId    string            `json:"guild_id"`
-	Member_    *discordgo.Member `json:"member"`
-	session    Session
-	User       *discordgo.User `json:"user"`
+	BattleTag_  string            `json:"battle_tag"`
+	ChannelId   string            `json:"channel_id"`
+	EnqueuedAt_ time.Time         `json:"enqueued_at,omitempty"`
+	GuildId     string            `json:"guild_id"`
+	Member_     *discordgo.Member `json:"member"`
+	PartyId_    string            `json:"party_id,omitempty"`
+	Roles_      []string          `json:"roles,omitempty"`
+	Skips_      int               `json:"skips,omitempty"`
+	session     Session
+	User        *discordgo.User `json:"user"`
+	cache       *guildCache
 }
 
 var _ Author = (*author)(nil)
-var _ queue.Queueable = (*author)(nil)
+var _ queue.Prioritized = (*author)(nil)
 
 func newAuthor(discord_author *discordgo.User, session Session,
 	channel_id string) *author {
@@ -55,6 +61,10 @@ func (a *author) BattleTag() (string, error) {
 	return a.BattleTag_, nil
 }
 
+func (a *author) EnqueuedAt() time.Time {
+	return a.EnqueuedAt_
+}
+
 func (a *author) Key() string {
 	guild_id, err := a.guildId()
 	if err != nil {
@@ -78,6 +88,10 @@ func (a *author) Nick() string {
 	return member.Nick
 }
 
+func (a *author) PartyId() string {
+	return a.PartyId_
+}
+
 func (a *author) PermittedTo(perm int) (bool, error) {
 	perms, err := a.session.UserChannelPermissions(a.User.ID, a.ChannelId)
 	if err != nil {
@@ -88,12 +102,46 @@ func (a *author) PermittedTo(perm int) (bool, error) {
 
 }
 
+// Roles returns the roles named in the author's nickname, or those recorded
+// when they were queued.
+func (a *author) Roles() []string {
+	if a.Roles_ != nil {
+		return a.Roles_
+	}
+
+	return extractRoles(a.Nick()).names()
+}
+
 func (a *author) SetBattleTag(btag string) error {
 	a.BattleTag_ = btag
 
 	return nil
 }
 
+func (a *author) SetEnqueuedAt(at time.Time) {
+	a.EnqueuedAt_ = at
+}
+
+func (a *author) SetPartyId(party_id string) {
+	a.PartyId_ = party_id
+}
+
+func (a *author) SetRoles(roles []string) {
+	a.Roles_ = roles
+}
+
+func (a *author) SetSkips(skips int) {
+	a.Skips_ = skips
+}
+
+func (a *author) Skips() int {
+	return a.Skips_
+}
+
+func (a *author) UserId() string {
+	return a.User.ID
+}
+
 func (a *author) guildId() (string, error) {
 	if a.GuildId != "" {
 		return a.GuildId, nil
@@ -109,26 +157,34 @@ func (a *author) guildId() (string, error) {
 }
 
 func (a *author) member() (*discordgo.Member, error) {
-	if a.Member_ != nil {
-		return a.Member_, nil
+	guild_id := a.GuildId
+	if a.session != nil {
+		var err error
+		guild_id, err = a.guildId()
+		if err != nil {
+			return nil, err
+		}
 	}
-
-	guild_id, err := a.guildId()
-	if err != nil {
-		return nil, err
+	// unmarshaled authors have no session, but may have been cached
+	if a.cache != nil {
+		if member, ok := a.cache.Member(guild_id, a.User.ID); ok {
+			return member, nil
+		}
 	}
-
-	member, err := a.session.Member(guild_id, a.User.ID)
-	if err != nil {
-		return nil, err
+	if a.session != nil {
+		return a.session.Member(guild_id, a.User.ID)
+	}
+	if a.Member_ != nil {
+		// the original records hold the member as it was when queued
+		return a.Member_, nil
 	}
 
-	a.Member_ = member
-
-	return member, nil
+	return nil, DiscordError.New("no session for member lookup")
 }
 
-func AuthorMarshaler(data []byte) (queue.Queueable, error) {
+// unmarshalAuthorV1 reads the original records, which hold the entire
+// author, including its discordgo Member and User.
+func unmarshalAuthorV1(data []byte) (queue.Queueable, error) {
 	a := &author{}
 	err := json.Unmarshal(data, a)
 	if err != nil {
@@ -32,6 +32,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/spacemonkeygo/errors"
 	"github.com/spacemonkeygo/spacelog"
+	"xmtp.net/xmtpbot/audit"
 	"xmtp.net/xmtpbot/dice"
 	"xmtp.net/xmtpbot/dur"
 	"xmtp.net/xmtpbot/fortune"
@@ -42,6 +43,7 @@ import (
 	"xmtp.net/xmtpbot/queue"
 	"xmtp.net/xmtpbot/remind"
 	"xmtp.net/xmtpbot/seen"
+	"xmtp.net/xmtpbot/store"
 	"xmtp.net/xmtpbot/twitch"
 	"xmtp.net/xmtpbot/urls"
 	"xmtp.net/xmtpbot/util"
@@ -65,12 +67,7 @@ var (
 		"Protocol for discord oauth redirects")
 	game = flag.String("discord.game", "!help", "Game being played")
 
-	roleRe     = regexp.MustCompile(`[\[\(]([^\]\)]+)[\]\)]`)
-	roleTankRe = regexp.MustCompile("(?i:\\b(tank|d\\.?va|rein(hardt)?|(roadhog|road|hog)|wins(ton)?|zarya)\\b)")
-	roleDPSRe  = regexp.MustCompile("(?i:\\b(dps|dam(age)?)\\b)")
-	// Intentionally not including symmetra, cuz I'm a dick
-	roleSupportRe = regexp.MustCompile("(?i:\\b(supp(ort)?|heal(er|s|z)?|ana|lucio|mercy|zen(yatta)?)\\b)")
-	roleFlexRe    = regexp.MustCompile("(?i:\\b(any|fill|flex)\\b)")
+	roleRe = regexp.MustCompile(`[\[\(]([^\]\)]+)[\]\)]`)
 
 	logger = spacelog.GetLogger()
 
@@ -93,6 +90,42 @@ type bot struct {
 	last_activity               time.Time
 	commands_handled            uint64
 	queues                      queue.Manager
+	queue_registry              *queueRegistry
+	audit                       audit.Log
+	store                       store.Simple
+	cache                       *guildCache
+	notify_prefs_mtx            sync.Mutex
+	notified_mtx                sync.Mutex
+	notified                    map[string]bool
+	priority_mtx                sync.Mutex
+	priority_queues             map[string]queue.PriorityQueue
+	waitlist_mtx                sync.Mutex
+	waitlist_queues             map[string]*cachedWaitlist
+	skips_mtx                   sync.Mutex
+	players_mtx                 sync.Mutex
+	dashboard_mtx               sync.Mutex
+	live_session_mtx            sync.Mutex
+	live_session                Session
+	schedule_mtx                sync.Mutex
+	scheduler_stop              chan struct{}
+	dashboard_subscribers       map[string]map[chan struct{}]bool
+	ready_check_window          time.Duration
+	ready_checks_mtx            sync.Mutex
+	ready_checks                map[string]*readyCheck
+	party_mtx                   sync.Mutex
+	party_invites               map[string]*partyInvite
+	status_mtx                  sync.Mutex
+	status_pending              map[string]bool
+	status_update_delay         time.Duration
+	queue_reactions             bool
+	declared_roles_mtx          sync.Mutex
+	declared_roles              map[string]map[string]bool
+	lobby_channels              bool
+	lobby_lifetime              time.Duration
+	lobby_expiry                string
+	watch_queues                bool
+	watches_mtx                 sync.Mutex
+	watches                     map[string]queue.Subscription
 	user_enqueue_rate_limit_mtx sync.Mutex
 	user_last_enqueued          map[string]time.Time
 }
@@ -100,21 +133,50 @@ type bot struct {
 func New(urls_store urls.Store, seen_store seen.Store, mildred mildred.Conn,
 	remind remind.Remind, twitch twitch.Twitch,
 	http_server http_server.Server, http_status http_status.Status,
-	queues queue.Manager) *bot {
+	queues queue.Manager, discord_store store.Simple,
+	audit_log audit.Log) *bot {
 
 	b := &bot{
-		seen:               seen_store,
-		urls:               urls_store,
-		mildred:            mildred,
-		remind:             remind,
-		twitch_client:      twitch,
-		http_server:        http_server,
-		commands:           make(map[string]CommandHandler),
-		oauth_states:       make(map[string]string),
-		last_activity:      time.Now(),
-		queues:             queues,
+		seen:                seen_store,
+		urls:                urls_store,
+		mildred:             mildred,
+		remind:              remind,
+		twitch_client:       twitch,
+		http_server:         http_server,
+		commands:            make(map[string]CommandHandler),
+		oauth_states:        make(map[string]string),
+		last_activity:       time.Now(),
+		queues:              queues,
+		queue_registry:      newQueueRegistry(discord_store),
+		store:               discord_store,
+		audit:               audit_log,
+		cache:               newGuildCache(*cacheTTL),
+		notified:            make(map[string]bool),
+		priority_queues:     make(map[string]queue.PriorityQueue),
+		waitlist_queues:     make(map[string]*cachedWaitlist),
+		ready_check_window:  *readyCheckWindow,
+		ready_checks:        make(map[string]*readyCheck),
+		party_invites:       make(map[string]*partyInvite),
+		status_pending:      make(map[string]bool),
+		status_update_delay: *statusUpdateDelay,
+		queue_reactions:     *queueReactions,
+		declared_roles:      make(map[string]map[string]bool),
+		lobby_channels:      *lobbyChannels,
+		lobby_lifetime:      *lobbyLifetime,
+		lobby_expiry:        strings.ToLower(*lobbyExpiry),
+		watch_queues:        true,
+		watches:             make(map[string]queue.Subscription),
+		dashboard_subscribers: make(
+			map[string]map[chan struct{}]bool),
 		user_last_enqueued: make(map[string]time.Time),
 	}
+	if *heroesFile != "" {
+		err := loadHeroTable(*heroesFile)
+		if err != nil {
+			logger.Errorf("failed to load hero table from %q, using the "+
+				"built-in one: %v", *heroesFile, err)
+		}
+	}
 
 	b.RegisterCommand("commands", simpleCommand(b.listCommands,
 		"list available commands"))
@@ -155,12 +217,14 @@ func New(urls_store urls.Store, seen_store seen.Store, mildred mildred.Conn,
 	// 	"search for a previously posted URL"))
 	if queues != nil {
 		b.RegisterCommand("dequeue", &commandHandler{
-			help:    "Type: `!dequeue` to leave the scrimmages queue",
+			help: "Type: `!dequeue` to leave the scrimmages queue, " +
+				"or `!dequeue ranked` to leave the ranked queue",
 			handler: b.dequeue,
 		})
 		b.RegisterCommand("enqueue", &commandHandler{
 			help: "Type: `!enqueue MyBattleTag#1234` to enter " +
-				"the scrimmages queue",
+				"the scrimmages queue, or `!enqueue ranked " +
+				"MyBattleTag#1234` to enter the ranked queue",
 			handler: b.enqueue,
 		})
 		b.RegisterCommand("queue", &commandHandler{
@@ -168,6 +232,30 @@ func New(urls_store urls.Store, seen_store seen.Store, mildred mildred.Conn,
 				"more info",
 			handler: b.queue,
 		})
+		b.RegisterCommand("sr", &commandHandler{
+			help: "record your skill rating for each role. Run " +
+				"\"!sr help\" for more info",
+			handler: b.sr,
+		})
+		b.RegisterCommand("roles", &commandHandler{
+			help: "declare the roles you play. Run \"!roles help\" " +
+				"for more info",
+			handler: b.roles,
+		})
+		b.RegisterCommand("btag", &commandHandler{
+			help: "register your BattleTag. Run \"!btag help\" for " +
+				"more info",
+			handler: b.btag,
+		})
+		b.RegisterCommand("ready", &commandHandler{
+			help:    "confirm you're ready after being taken from a queue",
+			handler: b.ready,
+		})
+		b.RegisterCommand("party", &commandHandler{
+			help: "queue together with friends. Run \"!party help\" " +
+				"for more info",
+			handler: b.party,
+		})
 	}
 	http_status.Register("discord", b.Status)
 
@@ -200,6 +288,11 @@ func (b *bot) Run(shutdown chan bool, wg *sync.WaitGroup) (err error) {
 		}
 	}
 
+	if b.queues != nil {
+		b.scheduler_stop = make(chan struct{})
+		go b.runScheduler(b.scheduler_stop)
+	}
+
 	go func() {
 		<-shutdown
 		logger.Infof("shutting down")
@@ -220,6 +313,7 @@ func (b *bot) logIn() (session *discordgo.Session, err error) {
 	if err != nil {
 		return nil, DiscordError.Wrap(err)
 	}
+	b.setLiveSession(session)
 
 	err = session.Open()
 	if err != nil {
@@ -229,6 +323,24 @@ func (b *bot) logIn() (session *discordgo.Session, err error) {
 	return session, nil
 }
 
+// setLiveSession records the session used to reach Discord outside of any
+// handler, eg from HTTP requests.
+func (b *bot) setLiveSession(s *discordgo.Session) {
+	b.live_session_mtx.Lock()
+	defer b.live_session_mtx.Unlock()
+
+	b.live_session = &session{Session: s, cache: b.cache}
+}
+
+// liveSession returns the session recorded by setLiveSession, or nil if
+// there's none yet.
+func (b *bot) liveSession() Session {
+	b.live_session_mtx.Lock()
+	defer b.live_session_mtx.Unlock()
+
+	return b.live_session
+}
+
 func getTokenBare() (discord_token string) {
 	defer func() {
 		if discord_token == "" {
@@ -250,8 +362,12 @@ func getToken() (discord_token string) {
 }
 
 func (b *bot) logOut(session *discordgo.Session) {
+	if b.scheduler_stop != nil {
+		close(b.scheduler_stop)
+	}
 	logger.Errore(session.Close())
 	logger.Errore(b.removeHandlers())
+	b.unwatchQueues()
 	logger.Info("offline")
 }
 
@@ -270,6 +386,16 @@ func (b *bot) addHandlers(session *discordgo.Session) (
 		session.AddHandler(b.messageHandler))
 	b.handler_callbacks = append(b.handler_callbacks,
 		session.AddHandler(b.presenceHandler))
+	b.handler_callbacks = append(b.handler_callbacks,
+		session.AddHandler(b.reactionHandler))
+	for _, handler := range []interface{}{b.memberAddHandler,
+		b.memberUpdateHandler, b.memberRemoveHandler,
+		b.channelCreateHandler, b.channelUpdateHandler,
+		b.channelDeleteHandler, b.guildCreateHandler,
+		b.guildDeleteHandler} {
+		b.handler_callbacks = append(b.handler_callbacks,
+			session.AddHandler(handler))
+	}
 
 	return nil
 }
@@ -355,7 +481,7 @@ func (b *bot) messageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
 		b.handleCommand(&command{
 			name:    args[0][1:],
 			args:    new_args,
-			session: &session{Session: s},
+			session: &session{Session: s, cache: b.cache},
 			message: m.Message,
 		})
 	}
@@ -657,6 +783,11 @@ func (b *bot) listCommands(cmd string) string {
 
 func (b *bot) ReceiveRouter(router *mux.Router) (err error) {
 	router.HandleFunc("/oauth/redirect", b.oauthRedirect)
+	router.HandleFunc("/queues/{guild_id}/history", b.handleQueueHistory)
+	router.HandleFunc("/queues/{guild_id}/dashboard",
+		b.handleQueueDashboard)
+	router.HandleFunc("/queues/{guild_id}/events", b.handleQueueEvents)
+	b.receiveAPIRouter(router)
 	router.HandleFunc("/", b.handleHTTP)
 	return nil
 }
@@ -840,10 +971,26 @@ func (b *bot) activity() {
 
 type session struct {
 	*discordgo.Session
+	cache *guildCache
 }
 
+// Member returns the guild member, from the cache if possible, otherwise
+// from the session's state, falling back to Discord's API.
 func (s *session) Member(guild_id, user_id string) (*discordgo.Member, error) {
-	return s.State.Member(guild_id, user_id)
+	if member, ok := s.cache.Member(guild_id, user_id); ok {
+		return member, nil
+	}
+
+	member, err := s.State.Member(guild_id, user_id)
+	if err != nil {
+		member, err = s.Session.GuildMember(guild_id, user_id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.cache.SetMember(guild_id, member)
+
+	return member, nil
 }
 
 func (s *session) UserChannelPermissions(user_id string, channel_id string) (
@@ -856,17 +1003,54 @@ func (s *session) ChannelMessageSend(channel_id, msg string) (
 	return s.Session.ChannelMessageSend(channel_id, msg)
 }
 
+func (s *session) UserChannelCreate(recipient_id string) (
+	*discordgo.Channel, error) {
+	return s.Session.UserChannelCreate(recipient_id)
+}
+
+func (s *session) MessageReactionAdd(channel_id, message_id,
+	emoji string) error {
+
+	_, err := s.Request("PUT", discordgo.EndpointChannelMessage(channel_id,
+		message_id)+"/reactions/"+url.QueryEscape(emoji)+"/@me", nil)
+
+	return err
+}
+
 func (s *session) GuildIdFromChannelId(channel_id string) (guild_id string,
 	err error) {
 
-	ch, err := s.Session.Channel(channel_id)
+	if guild_id, ok := s.cache.GuildId(channel_id); ok {
+		return guild_id, nil
+	}
+
+	ch, err := s.State.Channel(channel_id)
 	if err != nil {
-		return "", err
+		ch, err = s.Session.Channel(channel_id)
+		if err != nil {
+			return "", err
+		}
 	}
+	s.cache.SetGuildId(channel_id, ch.GuildID)
 
 	return ch.GuildID, nil
 }
 
+// VoiceStates returns the voice states of the guild's members who are in a
+// voice channel, as tracked by the session's state.
+func (s *session) VoiceStates(guild_id string) ([]*discordgo.VoiceState,
+	error) {
+
+	guild, err := s.State.Guild(guild_id)
+	if err != nil {
+		return nil, err
+	}
+	s.State.RLock()
+	defer s.State.RUnlock()
+
+	return append([]*discordgo.VoiceState{}, guild.VoiceStates...), nil
+}
+
 type roles struct {
 	DPS     bool `json:"dps"`
 	Support bool `json:"support"`
@@ -877,22 +1061,52 @@ func (r *roles) hasRole() bool {
 	return r.Tank || r.DPS || r.Support
 }
 
+func (r *roles) names() []string {
+	names := []string{}
+	if r.Tank {
+		names = append(names, roleTank)
+	}
+	if r.Support {
+		names = append(names, roleSupport)
+	}
+	if r.DPS {
+		names = append(names, roleDPS)
+	}
+
+	return names
+}
+
+// extractRoles returns the roles named in brackets in the nickname, according
+// to the hero table, defaulting to DPS.
 func extractRoles(nick string) *roles {
+	roles := matchRoles(nick)
+	if !roles.hasRole() {
+		logger.Infof("defaulting role for %q to DPS", nick)
+		roles.DPS = true
+	}
+
+	return roles
+}
+
+// matchRoles returns the roles named in brackets in the nickname, according
+// to the hero table.
+func matchRoles(nick string) *roles {
 	matches := roleRe.FindAllStringSubmatch(nick, -1)
 	roles := &roles{}
+	table := heroTable()
 
 	for _, match := range matches {
 		for _, role := range match {
-			if roleTankRe.MatchString(role) {
+			if re := table[roleTank]; re != nil && re.MatchString(role) {
 				roles.Tank = true
 			}
-			if roleDPSRe.MatchString(role) {
+			if re := table[roleDPS]; re != nil && re.MatchString(role) {
 				roles.DPS = true
 			}
-			if roleSupportRe.MatchString(role) {
+			if re := table[roleSupport]; re != nil && re.MatchString(role) {
 				roles.Support = true
 			}
-			if roleFlexRe.MatchString(role) {
+			if re := table[roleFlex]; re != nil && re.MatchString(role) {
 				roles.DPS = true
 				roles.Support = true
 				roles.Tank = true
@@ -900,10 +1114,5 @@ func extractRoles(nick string) *roles {
 		}
 	}
 
-	if !roles.hasRole() {
-		logger.Infof("defaulting role for %q to DPS", nick)
-		roles.DPS = true
-	}
-
 	return roles
 }
@@ -0,0 +1,185 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"xmtp.net/xmtpbot/util"
+)
+
+// btag manages the registry of BattleTags. Registered BattleTags are keyed by
+// Discord user id, and so are shared by every guild.
+func (b *bot) btag(cmd Command) (err error) {
+	pieces := strings.SplitN(strings.TrimSpace(cmd.Args()), " ", 2)
+	args := ""
+	if len(pieces) > 1 {
+		args = strings.TrimSpace(pieces[1])
+	}
+
+	switch pieces[0] {
+	case "", "show":
+		return cmd.Reply(b.btagShow(cmd, args))
+	case "set", "register":
+		return cmd.Reply(b.btagSet(cmd, args))
+	case "clear", "unset":
+		return cmd.Reply(b.btagClear(cmd))
+	case "who", "whois", "lookup":
+		return cmd.Reply(b.btagWho(cmd, args))
+	case "help":
+		return cmd.Reply(btagHelp())
+	}
+
+	return cmd.Reply("Unhandled btag command: %q", cmd.Args())
+}
+
+func btagHelp() string {
+	return "Registers your BattleTag, which is used whenever you queue " +
+		"without naming one.\n`!btag` -- display your BattleTag\n" +
+		"`!btag show @user` -- display a user's BattleTag\n`!btag set " +
+		"MyBattleTag#1234` -- register your BattleTag\n`!btag clear` -- " +
+		"forget your BattleTag\n`!btag who <BattleTag>` -- display who " +
+		"registered a BattleTag, with or without its number (server " +
+		"managers only)"
+}
+
+func (b *bot) btagShow(cmd Command, args string) string {
+	user_id := cmd.Message().Author.ID
+	name := "You have"
+	if args != "" {
+		user := mentionedUser(cmd.Message(), args)
+		if user == nil {
+			return fmt.Sprintf("%q doesn't mention a user.", args)
+		}
+		user_id = user.ID
+		name = fmt.Sprintf("<@!%s> has", user_id)
+	}
+
+	btag, err := b.registeredBattleTag(user_id)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error loading BattleTag: %s", err)
+	}
+	if btag == "" {
+		return fmt.Sprintf("%s no registered BattleTag. Try `!btag set "+
+			"example#1234`.", name)
+	}
+
+	return fmt.Sprintf("%s registered the BattleTag %s.", name, btag)
+}
+
+func (b *bot) btagSet(cmd Command, args string) string {
+	if args == "" {
+		return "Try `!btag set example#1234`."
+	}
+	if !util.ValidBattleTag(args) {
+		return fmt.Sprintf("BattleTag %q appears to be invalid.", args)
+	}
+
+	err := b.store.Set(b.battleTagStoreKey(cmd.Message().Author.ID), args)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error saving BattleTag: %s", err)
+	}
+
+	return fmt.Sprintf("Registered your BattleTag, %s.", args)
+}
+
+func (b *bot) btagClear(cmd Command) string {
+	err := b.store.Set(b.battleTagStoreKey(cmd.Message().Author.ID), "")
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error clearing BattleTag: %s", err)
+	}
+
+	return "Forgot your BattleTag."
+}
+
+// btagWho lists the users who've registered the BattleTag. Without its
+// number, every BattleTag of that name matches.
+func (b *bot) btagWho(cmd Command, args string) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+	if args == "" {
+		return "Try `!btag who example#1234`."
+	}
+
+	prefix := bucketBattleTags + "."
+	matches := []string{}
+	b.store.Iterate(func(key, value string) {
+		if !strings.HasPrefix(key, prefix) || value == "" {
+			return
+		}
+		if !battleTagMatches(value, args) {
+			return
+		}
+		matches = append(matches, fmt.Sprintf("%s (<@!%s>)", value,
+			strings.TrimPrefix(key, prefix)))
+	})
+	if len(matches) == 0 {
+		return fmt.Sprintf("No one has registered the BattleTag %s.", args)
+	}
+	sort.Strings(matches)
+
+	return fmt.Sprintf("Registered BattleTags matching %s: %s.", args,
+		strings.Join(matches, ", "))
+}
+
+func battleTagMatches(btag, query string) bool {
+	if strings.Contains(query, "#") {
+		return strings.EqualFold(btag, query)
+	}
+	name := strings.SplitN(btag, "#", 2)[0]
+
+	return strings.EqualFold(name, query)
+}
+
+// defaultBattleTag returns the BattleTag the author registered, falling back
+// to the one in their nickname, or "" if there's neither.
+func (b *bot) defaultBattleTag(a Author) string {
+	btag, err := b.registeredBattleTag(a.UserId())
+	if err != nil {
+		logger.Errore(err)
+	}
+	if btag != "" {
+		return btag
+	}
+
+	btag, err = a.BattleTag()
+	if err != nil {
+		return ""
+	}
+
+	return btag
+}
+
+// registeredBattleTag returns the BattleTag registered by the user, or "" if
+// they haven't registered one.
+func (b *bot) registeredBattleTag(user_id string) (string, error) {
+	return b.store.Get(b.battleTagStoreKey(user_id))
+}
+
+func (b *bot) battleTagStoreKey(user_id string) string {
+	return fmt.Sprintf("%s.%s", bucketBattleTags, user_id)
+}
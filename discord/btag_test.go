@@ -0,0 +1,95 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"testing"
+
+	"github.com/ewollesen/discordgo"
+)
+
+func TestBtag(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	msg2 := newTestMessage(testUserId2, testChannelId)
+
+	test.AssertNil(bot.btag(newTestCommand("btag", "", session, msg)))
+	test.AssertNil(bot.btag(newTestCommand("btag", "set foo", session, msg)))
+	test.AssertNil(bot.btag(newTestCommand("btag", "set "+testBTag, session,
+		msg)))
+	test.AssertNil(bot.btag(newTestCommand("btag", "", session, msg)))
+	test.AssertNil(bot.btag(newTestCommand("btag", "set example#9999",
+		session, msg2)))
+	test.AssertNil(bot.btag(newTestCommand("btag", "show <@"+testUserId2+
+		">", session, msg)))
+	test.AssertNil(bot.btag(newTestCommand("btag", "who example", session,
+		msg)))
+	session.allowAll()
+	test.AssertNil(bot.btag(newTestCommand("btag", "who EXAMPLE", session,
+		msg)))
+	test.AssertNil(bot.btag(newTestCommand("btag", "who "+testBTag, session,
+		msg)))
+	test.AssertNil(bot.btag(newTestCommand("btag", "clear", session, msg2)))
+	test.AssertNil(bot.btag(newTestCommand("btag", "who example#9999",
+		session, msg)))
+
+	expected := []string{
+		"You have no registered BattleTag. Try `!btag set example#1234`.",
+		"BattleTag \"foo\" appears to be invalid.",
+		"Registered your BattleTag, " + testBTag + ".",
+		"You have registered the BattleTag " + testBTag + ".",
+		"Registered your BattleTag, example#9999.",
+		"<@!" + testUserId2 + "> has registered the BattleTag example#9999.",
+		"Permission denied.",
+		"Registered BattleTags matching EXAMPLE: " + testBTag + " (<@!" +
+			testUserId + ">), example#9999 (<@!" + testUserId2 + ">).",
+		"Registered BattleTags matching " + testBTag + ": " + testBTag +
+			" (<@!" + testUserId + ">).",
+		"Forgot your BattleTag.",
+		"No one has registered the BattleTag example#9999.",
+	}
+	test.AssertEqual(len(session.replies), len(expected))
+	for idx, reply := range session.replies {
+		test.AssertEqual(reply, expected[idx])
+	}
+}
+
+func TestEnqueueRegisteredBtag(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+
+	test.AssertNil(bot.btag(newTestCommand("btag", "set "+testBTag, session,
+		msg)))
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", "", session, msg)))
+	test.AssertContainsString(session.replies, "Successfully added "+
+		testBTag+" to the scrimmages queue in position 1.")
+	test.AssertEqual(bot.queueList(q, newTestCommand("list", "", session,
+		msg)), "The scrimmages queue contains 1 BattleTags: "+testBTag+".")
+
+	mod_msg := newTestMessage(testModId, testChannelId)
+	mod_msg.Mentions = []*discordgo.User{{ID: testUserId2}}
+	session.allowAll()
+	test.AssertEqual(bot.queueAdd(q, newTestCommand("add", "<@"+
+		testUserId2+">", session, mod_msg)), "<@!"+testUserId2+"> hasn't "+
+		"registered a BattleTag. Try `!queue add @user example#1234 "+
+		"[position]`.")
+	test.AssertNil(bot.btag(newTestCommand("btag", "set "+testBTag2,
+		session, newTestMessage(testUserId2, testChannelId))))
+	test.AssertEqual(bot.queueAdd(q, newTestCommand("add", "<@"+
+		testUserId2+"> 1", session, mod_msg)), "Added "+testBTag2+" to "+
+		"the scrimmages queue in position 1.")
+}
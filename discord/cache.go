@@ -0,0 +1,273 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"flag"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ewollesen/discordgo"
+	"xmtp.net/xmtpbot/queue"
+)
+
+const defaultCacheTTL = 10 * time.Minute
+
+var (
+	cacheTTL = flag.Duration("discord.cache_ttl", defaultCacheTTL,
+		"How long the guilds of channels, and guild members, are cached "+
+			"between lookups; 0 disables caching")
+)
+
+// guildCache remembers the guild of each channel, and guild member records,
+// so that they needn't be looked up with each command and queue entry.
+// Entries expire after the cache's TTL, and are updated or forgotten as
+// Discord reports changes to them.
+type guildCache struct {
+	mtx      sync.Mutex
+	ttl      time.Duration
+	now      func() time.Time
+	channels map[string]cachedGuildId
+	members  map[string]cachedMember
+}
+
+type cachedGuildId struct {
+	guild_id string
+	expires  time.Time
+}
+
+type cachedMember struct {
+	member  *discordgo.Member
+	expires time.Time
+}
+
+func newGuildCache(ttl time.Duration) *guildCache {
+	return &guildCache{
+		ttl:      ttl,
+		now:      time.Now,
+		channels: make(map[string]cachedGuildId),
+		members:  make(map[string]cachedMember),
+	}
+}
+
+// GuildId returns the cached guild of the channel, if it hasn't expired.
+func (c *guildCache) GuildId(channel_id string) (string, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	cached, ok := c.channels[channel_id]
+	if !ok {
+		return "", false
+	}
+	if !c.now().Before(cached.expires) {
+		delete(c.channels, channel_id)
+		return "", false
+	}
+
+	return cached.guild_id, true
+}
+
+func (c *guildCache) SetGuildId(channel_id, guild_id string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.ttl <= 0 || guild_id == "" {
+		return
+	}
+	c.channels[channel_id] = cachedGuildId{
+		guild_id: guild_id,
+		expires:  c.now().Add(c.ttl),
+	}
+}
+
+func (c *guildCache) ForgetChannel(channel_id string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	delete(c.channels, channel_id)
+}
+
+// Member returns the cached member record, if it hasn't expired.
+func (c *guildCache) Member(guild_id, user_id string) (
+	*discordgo.Member, bool) {
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	key := memberCacheKey(guild_id, user_id)
+	cached, ok := c.members[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(cached.expires) {
+		delete(c.members, key)
+		return nil, false
+	}
+
+	return cached.member, true
+}
+
+func (c *guildCache) SetMember(guild_id string, member *discordgo.Member) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.ttl <= 0 || member == nil || member.User == nil {
+		return
+	}
+	c.members[memberCacheKey(guild_id, member.User.ID)] = cachedMember{
+		member:  member,
+		expires: c.now().Add(c.ttl),
+	}
+}
+
+func (c *guildCache) ForgetMember(guild_id, user_id string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	delete(c.members, memberCacheKey(guild_id, user_id))
+}
+
+// ForgetGuild forgets the guild's channels and members.
+func (c *guildCache) ForgetGuild(guild_id string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for channel_id, cached := range c.channels {
+		if cached.guild_id == guild_id {
+			delete(c.channels, channel_id)
+		}
+	}
+	prefix := guild_id + "-"
+	for key := range c.members {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.members, key)
+		}
+	}
+}
+
+func memberCacheKey(guild_id, user_id string) string {
+	return guild_id + "-" + user_id
+}
+
+func (b *bot) memberAddHandler(s *discordgo.Session,
+	e *discordgo.GuildMemberAdd) {
+
+	b.cache.SetMember(e.GuildID, e.Member)
+}
+
+func (b *bot) memberUpdateHandler(s *discordgo.Session,
+	e *discordgo.GuildMemberUpdate) {
+
+	// updates may be partial, so the next lookup refetches the member
+	if e.User != nil {
+		b.cache.ForgetMember(e.GuildID, e.User.ID)
+	}
+}
+
+func (b *bot) memberRemoveHandler(s *discordgo.Session,
+	e *discordgo.GuildMemberRemove) {
+
+	if e.User != nil {
+		b.cache.ForgetMember(e.GuildID, e.User.ID)
+	}
+}
+
+func (b *bot) channelCreateHandler(s *discordgo.Session,
+	e *discordgo.ChannelCreate) {
+
+	b.cache.SetGuildId(e.ID, e.GuildID)
+}
+
+func (b *bot) channelUpdateHandler(s *discordgo.Session,
+	e *discordgo.ChannelUpdate) {
+
+	b.cache.ForgetChannel(e.ID)
+	b.cache.SetGuildId(e.ID, e.GuildID)
+}
+
+func (b *bot) channelDeleteHandler(s *discordgo.Session,
+	e *discordgo.ChannelDelete) {
+
+	b.cache.ForgetChannel(e.ID)
+}
+
+func (b *bot) guildDeleteHandler(s *discordgo.Session,
+	e *discordgo.GuildDelete) {
+
+	b.cache.ForgetGuild(e.ID)
+	b.unwatchGuild(e.ID)
+}
+
+// sessionQueue hands the bot's live session and guild cache to the authors
+// read from a queue, so that those unmarshaled from storage, which have
+// neither, can look up their members. Authors are copied rather than changed
+// in place, as in-memory queues return the entries they hold. Wrappers of the
+// same queue are equal.
+type sessionQueue struct {
+	queue.Queue
+	b *bot
+}
+
+func (b *bot) sessionLookup(key string) queue.Queue {
+	return sessionQueue{Queue: b.queues.Lookup(key), b: b}
+}
+
+func (q sessionQueue) Dequeue(n int) ([]queue.Queueable, error) {
+	queueables, err := q.Queue.Dequeue(n)
+
+	return q.attachAll(queueables), err
+}
+
+func (q sessionQueue) List() ([]queue.Queueable, error) {
+	queueables, err := q.Queue.List()
+
+	return q.attachAll(queueables), err
+}
+
+func (q sessionQueue) Remove(key string) (queue.Queueable, error) {
+	queueable, err := q.Queue.Remove(key)
+
+	return q.attach(queueable), err
+}
+
+func (q sessionQueue) attachAll(
+	queueables []queue.Queueable) []queue.Queueable {
+
+	if queueables == nil {
+		return nil
+	}
+	attached := make([]queue.Queueable, 0, len(queueables))
+	for _, queueable := range queueables {
+		attached = append(attached, q.attach(queueable))
+	}
+
+	return attached
+}
+
+func (q sessionQueue) attach(queueable queue.Queueable) queue.Queueable {
+	a, ok := queueable.(*author)
+	if !ok || a == nil {
+		return queueable
+	}
+
+	attached := *a
+	if attached.session == nil {
+		attached.session = q.b.liveSession()
+	}
+	attached.cache = q.b.cache
+
+	return &attached
+}
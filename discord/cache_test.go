@@ -0,0 +1,110 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ewollesen/discordgo"
+	"xmtp.net/xmtpbot/test"
+)
+
+func TestGuildCache(t *testing.T) {
+	test := test.New(t)
+	now := time.Unix(1000, 0)
+	c := newGuildCache(time.Minute)
+	c.now = func() time.Time { return now }
+	member := &discordgo.Member{
+		Nick: "foobar",
+		User: &discordgo.User{ID: testUserId},
+	}
+
+	c.SetGuildId(testChannelId, testGuildId)
+	c.SetMember(testGuildId, member)
+	guild_id, ok := c.GuildId(testChannelId)
+	test.Assert(ok)
+	test.AssertEqual(guild_id, testGuildId)
+	cached, ok := c.Member(testGuildId, testUserId)
+	test.Assert(ok)
+	test.AssertEqual(cached.Nick, "foobar")
+
+	now = now.Add(time.Minute)
+	_, ok = c.GuildId(testChannelId)
+	test.Assert(!ok)
+	_, ok = c.Member(testGuildId, testUserId)
+	test.Assert(!ok)
+
+	c.SetGuildId(testChannelId, testGuildId)
+	c.SetMember(testGuildId, member)
+	c.ForgetMember(testGuildId, testUserId)
+	_, ok = c.Member(testGuildId, testUserId)
+	test.Assert(!ok)
+	c.SetMember(testGuildId, member)
+	c.ForgetGuild(testGuildId)
+	_, ok = c.GuildId(testChannelId)
+	test.Assert(!ok)
+	_, ok = c.Member(testGuildId, testUserId)
+	test.Assert(!ok)
+
+	c = newGuildCache(0)
+	c.SetGuildId(testChannelId, testGuildId)
+	_, ok = c.GuildId(testChannelId)
+	test.Assert(!ok)
+}
+
+func TestQueuedAuthorMember(t *testing.T) {
+	test := test.New(t)
+	b := newBot()
+	q := b.sessionLookup(testGuildId)
+	user := &discordgo.User{ID: "cached-user", Username: "cached"}
+	// authors unmarshaled from queues have no session
+	stored := &author{GuildId: testGuildId, User: user}
+	test.AssertNil(q.Enqueue(stored))
+
+	queueables, err := q.List()
+	test.AssertNil(err)
+	a := queueables[0].(*author)
+	test.AssertEqual(a.Nick(), "cached")
+
+	b.cache.SetMember(testGuildId, &discordgo.Member{
+		Nick: "cached [tank]",
+		User: user,
+	})
+	test.AssertEqual(a.Nick(), "cached [tank]")
+
+	b.memberUpdateHandler(nil, &discordgo.GuildMemberUpdate{
+		Member: &discordgo.Member{GuildID: testGuildId, User: user},
+	})
+	test.AssertEqual(a.Nick(), "cached")
+	b.cache.SetMember(testGuildId, &discordgo.Member{
+		Nick: "cached [support]",
+		User: user,
+	})
+	test.AssertEqual(a.Nick(), "cached [support]")
+
+	// when connected, members missing from the cache are looked up
+	b.cache.ForgetMember(testGuildId, user.ID)
+	session := newMockSession()
+	session.appendMemberNicks("looked up [dps]")
+	b.live_session = session
+	queueables, err = q.List()
+	test.AssertNil(err)
+	test.AssertEqual(queueables[0].(Author).Nick(), "looked up [dps]")
+
+	// the queue's own entries are left alone
+	test.Assert(stored.session == nil)
+	test.Assert(stored.cache == nil)
+}
@@ -14,15 +14,28 @@
 
 package discord
 
-import "github.com/ewollesen/discordgo"
+import (
+	"time"
+
+	"github.com/ewollesen/discordgo"
+)
 
 type Author interface {
 	BattleTag() (string, error) // do I belong here?
+	EnqueuedAt() time.Time
 	Key() string
 	Mention() string
 	Nick() string
+	PartyId() string
 	PermittedTo(perm int) (bool, error)
+	Roles() []string
 	SetBattleTag(btag string) error // do I belong here?
+	SetEnqueuedAt(at time.Time)
+	SetPartyId(party_id string)
+	SetRoles(roles []string)
+	SetSkips(skips int)
+	Skips() int
+	UserId() string
 }
 
 type Command interface {
@@ -35,9 +48,23 @@ type Command interface {
 }
 
 type Session interface {
+	ChannelDelete(channel_id string) (*discordgo.Channel, error)
+	ChannelMessageEdit(channel_id, message_id, msg string) (
+		*discordgo.Message, error)
+	ChannelMessagePin(channel_id, message_id string) error
 	ChannelMessageSend(channel_id, msg string) (*discordgo.Message, error)
+	ChannelMessageUnpin(channel_id, message_id string) error
+	ChannelPermissionSet(channel_id, target_id, target_type string, allow,
+		deny int) error
+	GuildChannelCreate(guild_id, name, ctype string) (*discordgo.Channel,
+		error)
+	GuildChannels(guild_id string) ([]*discordgo.Channel, error)
 	GuildIdFromChannelId(channel_id string) (string, error)
+	GuildMemberMove(guild_id, user_id, channel_id string) error
 	Member(guild_id, user_id string) (*discordgo.Member, error)
+	MessageReactionAdd(channel_id, message_id, emoji string) error
+	UserChannelCreate(recipient_id string) (*discordgo.Channel, error)
 	UserChannelPermissions(user_id string, channel_id string) (perms int,
 		err error)
+	VoiceStates(guild_id string) ([]*discordgo.VoiceState, error)
 }
@@ -0,0 +1,421 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"xmtp.net/xmtpbot/util"
+)
+
+const (
+	dashboardKeepAlive = 30 * time.Second
+)
+
+type dashboard struct {
+	GuildId   string           `json:"guild_id"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	Queues    []dashboardQueue `json:"queues"`
+}
+
+type dashboardQueue struct {
+	Name     string           `json:"name"`
+	Title    string           `json:"title"`
+	Mode     string           `json:"mode"`
+	Capacity int              `json:"capacity,omitempty"`
+	Entries  []dashboardEntry `json:"entries"`
+	Waitlist []dashboardEntry `json:"waitlist"`
+}
+
+type dashboardEntry struct {
+	Position    int        `json:"position"`
+	BattleTag   string     `json:"battle_tag"`
+	Nick        string     `json:"nick"`
+	Roles       []string   `json:"roles"`
+	EnqueuedAt  *time.Time `json:"enqueued_at,omitempty"`
+	WaitSeconds int64      `json:"wait_seconds"`
+	Waitlisted  bool       `json:"waitlisted,omitempty"`
+	Party       string     `json:"party,omitempty"`
+}
+
+// dashboardSnapshot describes every queue of the guild, as of now. Queues
+// are only read, never created, migrated or watched.
+func (b *bot) dashboardSnapshot(guild_id string) (*dashboard, error) {
+	names, err := b.queue_registry.Names(guild_id)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := b.storedQueueKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	d := &dashboard{
+		GuildId:   guild_id,
+		UpdatedAt: now,
+		Queues:    []dashboardQueue{},
+	}
+	for _, name := range append([]string{""}, names...) {
+		dq, err := b.describeQueue(b.peekNamedQueue(guild_id, name, stored),
+			now)
+		if err != nil {
+			return nil, err
+		}
+		d.Queues = append(d.Queues, *dq)
+	}
+
+	return d, nil
+}
+
+func (b *bot) describeQueue(q *scrimQueue, now time.Time) (*dashboardQueue,
+	error) {
+
+	queueables, err := q.List()
+	if err != nil {
+		return nil, err
+	}
+
+	dq := &dashboardQueue{
+		Name:     q.name,
+		Title:    util.Capitalize(q.Title()),
+		Mode:     "fifo",
+		Entries:  []dashboardEntry{},
+		Waitlist: []dashboardEntry{},
+	}
+	if _, ok := q.priority(); ok {
+		dq.Mode = "priority"
+	}
+	for idx, queueable := range queueables {
+		dq.Entries = append(dq.Entries,
+			b.describeEntry(q.guild_id, queueable.(Author), idx+1, now))
+	}
+	if q.waitlist == nil {
+		return dq, nil
+	}
+
+	dq.Capacity = q.waitlist.Capacity()
+	waiting, err := q.waitlist.Waitlist().List()
+	if err != nil {
+		return nil, err
+	}
+	for idx, queueable := range waiting {
+		entry := b.describeEntry(q.guild_id, queueable.(Author), idx+1, now)
+		entry.Waitlisted = true
+		dq.Waitlist = append(dq.Waitlist, entry)
+	}
+
+	return dq, nil
+}
+
+func (b *bot) describeEntry(guild_id string, a Author, pos int,
+	now time.Time) dashboardEntry {
+
+	entry := dashboardEntry{
+		Position:  pos,
+		BattleTag: displayName(a),
+		Nick:      a.Nick(),
+		Roles:     b.authorRoles(guild_id, a),
+		Party:     a.PartyId(),
+	}
+	if at := a.EnqueuedAt(); !at.IsZero() {
+		entry.EnqueuedAt = &at
+		entry.WaitSeconds = int64(now.Sub(at) / time.Second)
+	}
+
+	return entry
+}
+
+// authorRoles returns the roles the author has ratings for, or failing that,
+// the roles named in their nickname.
+func (b *bot) authorRoles(guild_id string, a Author) []string {
+	roles := []string{}
+	p, err := b.loadPlayer(guild_id, a.UserId())
+	if err != nil {
+		logger.Errore(err)
+	} else if len(p.Ratings) > 0 {
+		for role := range p.Ratings {
+			roles = append(roles, role)
+		}
+		sort.Strings(roles)
+		return roles
+	}
+
+	return a.Roles()
+}
+
+func (b *bot) queueDashboard(cmd Command) string {
+	guild_id, err := cmd.Session().GuildIdFromChannelId(
+		cmd.Message().ChannelID)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error looking up guild: %s", err)
+	}
+
+	return fmt.Sprintf("Watch the queues live at %s://%s/discord/queues/"+
+		"%s/dashboard?token=<token>, with the guild's API token from "+
+		"`!queue token`.", *protocol, *hostname, guild_id)
+}
+
+// notifyDashboards wakes each dashboard watching the guild's queues.
+func (b *bot) notifyDashboards(guild_id string) {
+	b.dashboard_mtx.Lock()
+	defer b.dashboard_mtx.Unlock()
+
+	for ch := range b.dashboard_subscribers[guild_id] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// a wakeup is already pending
+		}
+	}
+}
+
+func (b *bot) subscribeDashboard(guild_id string) chan struct{} {
+	b.dashboard_mtx.Lock()
+	defer b.dashboard_mtx.Unlock()
+
+	ch := make(chan struct{}, 1)
+	if b.dashboard_subscribers[guild_id] == nil {
+		b.dashboard_subscribers[guild_id] = make(map[chan struct{}]bool)
+	}
+	b.dashboard_subscribers[guild_id][ch] = true
+
+	return ch
+}
+
+func (b *bot) unsubscribeDashboard(guild_id string, ch chan struct{}) {
+	b.dashboard_mtx.Lock()
+	defer b.dashboard_mtx.Unlock()
+
+	delete(b.dashboard_subscribers[guild_id], ch)
+	if len(b.dashboard_subscribers[guild_id]) == 0 {
+		delete(b.dashboard_subscribers, guild_id)
+	}
+}
+
+func (b *bot) handleQueueDashboard(w http.ResponseWriter,
+	req *http.Request) {
+
+	guild_id := mux.Vars(req)["guild_id"]
+	if !b.dashboardAuthorized(guild_id, req) {
+		http.Error(w, "invalid API token", http.StatusUnauthorized)
+		return
+	}
+
+	t, err := template.New("dashboard").Parse(dashboardTpl)
+	if err != nil {
+		logger.Errore(err)
+		http.Error(w, "failed to parse dashboard template",
+			http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		GuildId string
+		Token   string
+	}{
+		GuildId: guild_id,
+		Token:   req.URL.Query().Get("token"),
+	}
+	buf := bytes.NewBufferString("")
+	err = t.Execute(buf, data)
+	if err != nil {
+		logger.Errore(err)
+		http.Error(w, "failed to render dashboard",
+			http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// handleQueueEvents streams a snapshot of the guild's queues as server-sent
+// events, once upon connecting, and again whenever they change.
+func (b *bot) handleQueueEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	guild_id := mux.Vars(req)["guild_id"]
+	if !b.dashboardAuthorized(guild_id, req) {
+		http.Error(w, "invalid API token", http.StatusUnauthorized)
+		return
+	}
+	ch := b.subscribeDashboard(guild_id)
+	defer b.unsubscribeDashboard(guild_id, ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	keep_alive := time.NewTicker(dashboardKeepAlive)
+	defer keep_alive.Stop()
+
+	for {
+		d, err := b.dashboardSnapshot(guild_id)
+		if err != nil {
+			logger.Errore(err)
+			return
+		}
+		bytes, err := json.Marshal(d)
+		if err != nil {
+			logger.Errore(err)
+			return
+		}
+		_, err = fmt.Fprintf(w, "event: queues\ndata: %s\n\n", bytes)
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+
+	wait:
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-keep_alive.C:
+				_, err = fmt.Fprint(w, ": keep-alive\n\n")
+				if err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-ch:
+				break wait
+			}
+		}
+	}
+}
+
+const dashboardTpl = `<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<title>xMTP bot queues</title>
+		<style>
+			body { font-family: sans-serif; background: #222; color: #eee; }
+			table { border-collapse: collapse; margin-bottom: 2em; }
+			th, td { padding: 0.3em 1em; text-align: left; }
+			tr:nth-child(even) { background: #333; }
+			.mode { font-size: 60%; color: #aaa; }
+			#status { color: #aaa; }
+		</style>
+	</head>
+	<body>
+		<div id="queues"></div>
+		<p id="status">Connecting...</p>
+		<script>
+		(function() {
+			var guild_id = {{.GuildId}};
+			var token = {{.Token}};
+			var latest = null;
+
+			function wait(entry) {
+				if (!entry.enqueued_at) {
+					return "";
+				}
+				var secs = Math.max(0, Math.floor(
+					(Date.now() - Date.parse(entry.enqueued_at)) / 1000));
+				var mins = Math.floor(secs / 60);
+				secs = secs % 60;
+				return mins + "m " + (secs < 10 ? "0" : "") + secs + "s";
+			}
+
+			function cell(row, text) {
+				var td = document.createElement("td");
+				td.textContent = text;
+				row.appendChild(td);
+			}
+
+			function table(entries) {
+				var t = document.createElement("table");
+				var head = document.createElement("tr");
+				["#", "BattleTag", "Nick", "Roles", "Waiting"].forEach(
+					function(title) {
+						var th = document.createElement("th");
+						th.textContent = title;
+						head.appendChild(th);
+					});
+				t.appendChild(head);
+				entries.forEach(function(entry) {
+					var row = document.createElement("tr");
+					cell(row, entry.position);
+					cell(row, entry.battle_tag);
+					cell(row, entry.nick);
+					cell(row, entry.roles.join(", "));
+					cell(row, wait(entry));
+					t.appendChild(row);
+				});
+				return t;
+			}
+
+			function render() {
+				var container = document.getElementById("queues");
+				container.innerHTML = "";
+				latest.queues.forEach(function(q) {
+					var h = document.createElement("h2");
+					var size = q.entries.length;
+					if (q.capacity) {
+						size += "/" + q.capacity;
+					}
+					h.textContent = q.title + " (" + size + ") ";
+					var mode = document.createElement("span");
+					mode.className = "mode";
+					mode.textContent = q.mode;
+					h.appendChild(mode);
+					container.appendChild(h);
+					container.appendChild(table(q.entries));
+
+					if (q.waitlist.length > 0) {
+						var w = document.createElement("h3");
+						w.textContent = "Waitlist (" + q.waitlist.length + ")";
+						container.appendChild(w);
+						container.appendChild(table(q.waitlist));
+					}
+				});
+			}
+
+			var source = new EventSource(
+				"events?token=" + encodeURIComponent(token));
+			source.addEventListener("queues", function(e) {
+				latest = JSON.parse(e.data);
+				document.getElementById("status").textContent =
+					"Guild " + guild_id + ", updated " +
+					new Date(latest.updated_at).toLocaleTimeString();
+				render();
+			});
+			source.onerror = function() {
+				document.getElementById("status").textContent =
+					"Disconnected, reconnecting...";
+			};
+			setInterval(function() {
+				if (latest) {
+					render();
+				}
+			}, 1000);
+		})();
+		</script>
+	</body>
+</html>`
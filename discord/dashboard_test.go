@@ -0,0 +1,129 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"xmtp.net/xmtpbot/queue"
+)
+
+func TestDashboardSnapshot(t *testing.T) {
+	test, bot, _ := newQueueTest(t)
+	test.AssertNil(bot.queue_registry.Create(testGuildId, "ranked"))
+	test.AssertNil(bot.updatePlayer(testGuildId, testUserId2,
+		func(p *player) {
+			p.Ratings[roleSupport] = 2600
+		}))
+
+	q := bot.lookupNamedQueue(testGuildId, "ranked")
+	a := newTestAuthor(testUserId2, testBTag2)
+	bot.prepareQueued(q, a)
+	test.AssertNil(q.Enqueue(a))
+
+	d, err := bot.dashboardSnapshot(testGuildId)
+	test.AssertNil(err)
+	test.AssertEqual(len(d.Queues), 2)
+	test.AssertEqual(d.Queues[0].Title, "Scrimmages queue")
+	test.AssertEqual(len(d.Queues[0].Entries), 0)
+	test.AssertEqual(d.Queues[1].Name, "ranked")
+	test.AssertEqual(d.Queues[1].Mode, "fifo")
+	test.AssertEqual(len(d.Queues[1].Entries), 1)
+	entry := d.Queues[1].Entries[0]
+	test.AssertEqual(entry.Position, 1)
+	test.AssertEqual(entry.BattleTag, testBTag2)
+	test.AssertEqual(strings.Join(entry.Roles, ","), roleSupport)
+	test.Assert(entry.EnqueuedAt != nil)
+
+	// snapshots of other guilds' queues don't create them
+	d, err = bot.dashboardSnapshot("other-guild")
+	test.AssertNil(err)
+	test.AssertEqual(len(d.Queues), 1)
+	test.AssertEqual(len(d.Queues[0].Entries), 0)
+	keys, err := bot.queues.(queue.Lister).Keys()
+	test.AssertNil(err)
+	for _, key := range keys {
+		test.Assert(!strings.HasPrefix(key, "other-guild"), key)
+	}
+}
+
+func TestDashboardEvents(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	session.allowAll()
+	bot.queueToken(newTestCommand("token", "", session,
+		newTestMessage(testUserId, testChannelId)))
+	token := tokenRe.FindStringSubmatch(session.dms[testUserId][0])[1]
+
+	router := mux.NewRouter()
+	test.AssertNil(bot.ReceiveRouter(router))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	events := server.URL + "/queues/" + testGuildId + "/events"
+	for _, url := range []string{events, events + "?token=wrong",
+		server.URL + "/queues/" + testGuildId + "/dashboard"} {
+
+		resp, err := http.Get(url)
+		test.AssertNil(err)
+		resp.Body.Close()
+		test.AssertEqual(resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequest("GET", events+"?token="+token, nil)
+	test.AssertNil(err)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	test.AssertNil(err)
+	defer resp.Body.Close()
+	test.AssertEqual(resp.Header.Get("Content-Type"), "text/event-stream")
+
+	reader := bufio.NewReader(resp.Body)
+	d := readDashboardEvent(test, reader)
+	test.AssertEqual(len(d.Queues[0].Entries), 0)
+
+	test.AssertNil(q.Enqueue(newTestAuthor(testUserId, testBTag)))
+	bot.queueChanged(session, q)
+	d = readDashboardEvent(test, reader)
+	test.AssertEqual(len(d.Queues[0].Entries), 1)
+	test.AssertEqual(d.Queues[0].Entries[0].BattleTag, testBTag)
+}
+
+func readDashboardEvent(test *queueTest, reader *bufio.Reader) *dashboard {
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			test.Fatalf("unexpected end of event stream")
+		}
+		test.AssertNil(err)
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		d := &dashboard{}
+		test.AssertNil(json.Unmarshal(
+			[]byte(strings.TrimPrefix(line, "data: ")), d))
+		return d
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"fmt"
+	"strings"
+
+	"xmtp.net/xmtpbot/util"
+)
+
+// gameIdType returns the type of game id that entries of the queue must
+// have.
+func (q *scrimQueue) gameIdType() *util.GameIdType {
+	if q.id_type == nil {
+		return util.BattleTagType
+	}
+
+	return q.id_type
+}
+
+func (b *bot) queueIdType(q *scrimQueue, cmd Command) string {
+	name := strings.ToLower(strings.TrimSpace(cmd.Args()))
+	if name == "" {
+		t := q.gameIdType()
+		return fmt.Sprintf("The %s requires %ss, eg %s.", q.Title(),
+			t.Description, t.Example)
+	}
+
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	t := util.LookupGameIdType(name)
+	if t == nil {
+		return fmt.Sprintf("Unknown game id type %q. Types are %s.", name,
+			strings.Join(util.GameIdTypes(), ", "))
+	}
+	err = b.queue_registry.SetGameIdType(q.guild_id, q.name, t.Name)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error setting the game id type of the %s: %s",
+			q.Title(), err)
+	}
+
+	msg := fmt.Sprintf("The %s now requires %ss, eg %s.", q.Title(),
+		t.Description, t.Example)
+	if q.Size() > 0 {
+		msg += " Users already queued keep the ids they were queued with."
+	}
+
+	return msg
+}
+
+// gameId returns the game id in args, or if there's none, the author's
+// default id, so long as it's valid for the queue. Otherwise, it returns a
+// message explaining what's wrong, using usage to suggest a command.
+func (b *bot) gameId(q *scrimQueue, a Author, args,
+	usage string) (id, msg string) {
+
+	t := q.gameIdType()
+	args = strings.TrimSpace(args)
+	if t.Spaces {
+		id = args
+	} else {
+		id = strings.Split(args, " ")[0]
+	}
+
+	if id == "" {
+		id = b.defaultGameId(q, a)
+		if id == "" {
+			return "", fmt.Sprintf("No %s specified. Try `%s %s`.",
+				t.Description, usage, t.Example)
+		}
+	}
+	if !t.Valid(id) {
+		return "", fmt.Sprintf("%s %q appears to be invalid.",
+			t.Description, id)
+	}
+
+	return id, ""
+}
+
+// defaultGameId returns the id used when the author doesn't give one. For
+// BattleTags, that's the one they registered, if any; otherwise it's the
+// first one in their nickname.
+func (b *bot) defaultGameId(q *scrimQueue, a Author) string {
+	t := q.gameIdType()
+	if t == util.BattleTagType {
+		return b.defaultBattleTag(a)
+	}
+
+	return t.Parse(a.Nick())
+}
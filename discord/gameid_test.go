@@ -0,0 +1,71 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"testing"
+
+	"github.com/ewollesen/discordgo"
+)
+
+func TestQueueIdType(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+
+	test.AssertEqual(bot.queueIdType(q, newTestCommand("idtype", "",
+		session, msg)), "The scrimmages queue requires BattleTags, eg "+
+		"example#1234.")
+	test.AssertEqual(bot.queueIdType(q, newTestCommand("idtype", "xbox",
+		session, msg)), "Permission denied.")
+	session.allowAll()
+	test.AssertEqual(bot.queueIdType(q, newTestCommand("idtype", "origin",
+		session, msg)), "Unknown game id type \"origin\". Types are "+
+		"battletag, psn, riot, steam, xbox.")
+	test.AssertEqual(bot.queueIdType(q, newTestCommand("idtype", "xbox",
+		session, msg)), "The scrimmages queue now requires Xbox "+
+		"gamertags, eg Example Tag.")
+
+	q, err = bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", "9lives", session,
+		msg)))
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", "Major  Nelson",
+		session, msg)))
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", "Major Nelson",
+		session, msg)))
+	test.AssertEqual(session.replies[0], "Xbox gamertag \"9lives\" appears "+
+		"to be invalid.")
+	test.AssertEqual(session.replies[1], "Xbox gamertag \"Major  Nelson\" "+
+		"appears to be invalid.")
+	test.AssertEqual(session.replies[2], "Successfully added Major Nelson "+
+		"to the scrimmages queue in position 1.")
+
+	mod_msg := newTestMessage(testModId, testChannelId)
+	mod_msg.Mentions = []*discordgo.User{{ID: testUserId2}}
+	test.AssertEqual(bot.queueAdd(q, newTestCommand("add", "<@"+
+		testUserId2+"> Some Gamer 1", session, mod_msg)), "Added Some "+
+		"Gamer to the scrimmages queue in position 1.")
+	test.AssertEqual(q.Position(newTestAuthor(testUserId, "").Key()), 2)
+
+	test.AssertEqual(bot.queueIdType(q, newTestCommand("idtype",
+		"battletag", session, msg)), "The scrimmages queue now requires "+
+		"BattleTags, eg example#1234. Users already queued keep the ids "+
+		"they were queued with.")
+	q, err = bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	test.Assert(q.id_type == nil)
+}
@@ -0,0 +1,183 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"xmtp.net/xmtpbot/audit"
+)
+
+const (
+	defaultHistoryLength = 10
+	maxHistoryLength     = 50
+)
+
+// recordQueueEvent appends an entry to the guild's audit log. The target may
+// be nil for actions that don't apply to a single user.
+func (b *bot) recordQueueEvent(q *scrimQueue, action string, actor Author,
+	target Author, pos int, detail string) {
+
+	if b.audit == nil {
+		return
+	}
+
+	entry := audit.Entry{
+		At:       time.Now(),
+		Queue:    q.name,
+		Action:   action,
+		ActorId:  actor.UserId(),
+		Actor:    actor.Nick(),
+		Position: pos,
+		Detail:   detail,
+	}
+	if target != nil {
+		entry.TargetId = target.UserId()
+		entry.Target = displayName(target)
+	}
+
+	err := b.audit.Append(q.guild_id, entry)
+	if err != nil {
+		logger.Errore(err)
+	}
+}
+
+func (b *bot) queueHistory(q *scrimQueue, cmd Command) string {
+	if b.audit == nil {
+		return "Queue history isn't being recorded."
+	}
+
+	n := defaultHistoryLength
+	arg := strings.TrimSpace(cmd.Args())
+	if arg != "" {
+		var err error
+		n, err = strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return fmt.Sprintf("Invalid history argument %q.", arg)
+		}
+		if n > maxHistoryLength {
+			n = maxHistoryLength
+		}
+	}
+
+	entries, err := b.queueEntries(q.guild_id, q.name, n)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error retrieving the %s history: %s", q.Title(),
+			err)
+	}
+	if len(entries) == 0 {
+		return fmt.Sprintf("The %s has no history.", q.Title())
+	}
+
+	lines := []string{fmt.Sprintf("The last %d changes to the %s:",
+		len(entries), q.Title())}
+	for _, entry := range entries {
+		lines = append(lines, formatAuditEntry(entry))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// queueEntries returns (at most) the n most recent entries for the named
+// queue of the guild.
+func (b *bot) queueEntries(guild_id, name string, n int) (
+	[]audit.Entry, error) {
+
+	all, err := b.audit.Recent(guild_id, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []audit.Entry{}
+	for _, entry := range all {
+		if entry.Queue == name {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+
+	return entries, nil
+}
+
+func formatAuditEntry(entry audit.Entry) string {
+	line := fmt.Sprintf("`%s` %s: %s", entry.At.UTC().Format(
+		"2006-01-02 15:04:05 MST"), entry.Actor, entry.Action)
+	if entry.Target != "" {
+		line += " " + entry.Target
+	}
+	if entry.Position > 0 {
+		line += fmt.Sprintf(" (position %d)", entry.Position)
+	}
+	if entry.Detail != "" {
+		line += fmt.Sprintf(" %s", entry.Detail)
+	}
+
+	return line
+}
+
+// handleQueueHistory serves the guild's recent queue changes. As they
+// identify the users involved, the guild's API token is required.
+func (b *bot) handleQueueHistory(w http.ResponseWriter, req *http.Request) {
+	guild_id := mux.Vars(req)["guild_id"]
+	if !b.apiAuthorized(guild_id, req) {
+		http.Error(w, "invalid API token", http.StatusUnauthorized)
+		return
+	}
+	if b.audit == nil {
+		http.Error(w, "queue history isn't being recorded",
+			http.StatusNotFound)
+		return
+	}
+
+	values := req.URL.Query()
+	n := maxHistoryLength
+	if values.Get("n") != "" {
+		var err error
+		n, err = strconv.Atoi(values.Get("n"))
+		if err != nil || n < 1 {
+			http.Error(w, "failed to parse n query parameter",
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := b.queueEntries(guild_id, values.Get("queue"), n)
+	if err != nil {
+		logger.Errore(err)
+		http.Error(w, "failed to retrieve queue history",
+			http.StatusInternalServerError)
+		return
+	}
+
+	bytes, err := json.Marshal(entries)
+	if err != nil {
+		logger.Errore(err)
+		http.Error(w, "failed to encode queue history",
+			http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
@@ -0,0 +1,214 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ewollesen/discordgo"
+)
+
+const (
+	bucketLobbies = "discord.lobbies"
+
+	lobbyExpiryArchive = "archive"
+	lobbyExpiryDelete  = "delete"
+
+	permissionsLobby = discordgo.PermissionReadMessages |
+		discordgo.PermissionSendMessages |
+		discordgo.PermissionReadMessageHistory
+)
+
+var (
+	lobbyChannels = flag.Bool("discord.lobby_channels", false,
+		"create a private text channel for each group taken from a queue")
+	lobbyLifetime = flag.Duration("discord.lobby_lifetime", 3*time.Hour,
+		"time after which a taken group's private channel expires")
+	lobbyExpiry = flag.String("discord.lobby_expiry", lobbyExpiryDelete,
+		"what becomes of a taken group's private channel once it expires: "+
+			"\"delete\" it, or \"archive\" it, leaving it read-only")
+)
+
+// lobby is a private text channel created for a group taken from a queue.
+type lobby struct {
+	ChannelId string    `json:"channel_id"`
+	GuildId   string    `json:"guild_id"`
+	Members   []string  `json:"members"`
+	Expires   time.Time `json:"expires"`
+}
+
+// openLobby creates a private text channel for the taken players, and posts
+// their roster and the lobby details in it. Returns the text to append to
+// the reply.
+func (b *bot) openLobby(session Session, q *scrimQueue, moderator Author,
+	players []Author, details, roster string) string {
+
+	if !b.lobby_channels || len(players) == 0 {
+		return ""
+	}
+
+	name := "scrims"
+	if q.name != "" {
+		name = q.name
+	}
+	ch, err := session.GuildChannelCreate(q.guild_id,
+		fmt.Sprintf("%s-%s", name, time.Now().Format("0102-1504")), "text")
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf(" Error creating their channel: %s", err)
+	}
+
+	l := &lobby{
+		ChannelId: ch.ID,
+		GuildId:   q.guild_id,
+		Expires:   time.Now().Add(b.lobby_lifetime),
+	}
+	mentions := []string{}
+	for _, a := range players {
+		l.Members = append(l.Members, a.UserId())
+		mentions = append(mentions, a.Mention())
+	}
+	if moderator != nil {
+		l.Members = append(l.Members, moderator.UserId())
+	}
+
+	err = b.saveLobby(l)
+	if err != nil {
+		logger.Errore(err)
+	}
+	err = b.restrictLobby(session, l)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf(" Error restricting their channel <#%s>: %s",
+			ch.ID, err)
+	}
+
+	msg := fmt.Sprintf("Taken from the %s: %s.", q.Title(),
+		strings.Join(mentions, ", "))
+	if details != "" {
+		msg += fmt.Sprintf("\nLobby details: %s", details)
+	}
+	if roster != "" {
+		msg += "\n" + roster
+	}
+	if b.lobby_expiry == lobbyExpiryArchive {
+		msg += fmt.Sprintf("\nThis channel will become read-only in %s.",
+			b.lobby_lifetime)
+	} else {
+		msg += fmt.Sprintf("\nThis channel will be deleted in %s.",
+			b.lobby_lifetime)
+	}
+	_, err = session.ChannelMessageSend(ch.ID, msg)
+	logger.Errore(err)
+
+	return fmt.Sprintf(" Their channel is <#%s>.", ch.ID)
+}
+
+// restrictLobby hides the lobby's channel from everyone but its members and
+// the bot.
+func (b *bot) restrictLobby(session Session, l *lobby) error {
+	// the @everyone role shares the guild's id
+	err := session.ChannelPermissionSet(l.ChannelId, l.GuildId, "role", 0,
+		discordgo.PermissionReadMessages)
+	if err != nil {
+		return err
+	}
+
+	allowed := l.Members
+	if b.user_id != "" {
+		allowed = append([]string{b.user_id}, allowed...)
+	}
+	for _, user_id := range allowed {
+		err = session.ChannelPermissionSet(l.ChannelId, user_id, "member",
+			permissionsLobby, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expireLobbies deletes or archives each lobby that has expired.
+func (b *bot) expireLobbies(now time.Time) {
+	live_session := b.liveSession()
+	if live_session == nil {
+		return
+	}
+
+	keys := []string{}
+	b.store.Iterate(func(key, value string) {
+		if strings.HasPrefix(key, bucketLobbies+".") && value != "" {
+			keys = append(keys, key)
+		}
+	})
+
+	for _, key := range keys {
+		value, err := b.store.Get(key)
+		if err != nil || value == "" {
+			logger.Errore(err)
+			continue
+		}
+		l := &lobby{}
+		err = json.Unmarshal([]byte(value), l)
+		if err != nil {
+			logger.Errore(err)
+			continue
+		}
+		if now.Before(l.Expires) {
+			continue
+		}
+
+		err = b.expireLobby(live_session, l)
+		if err != nil && !messageGone(err) {
+			logger.Errore(err)
+			continue
+		}
+		logger.Errore(b.store.Set(key, ""))
+	}
+}
+
+func (b *bot) expireLobby(session Session, l *lobby) error {
+	if b.lobby_expiry != lobbyExpiryArchive {
+		_, err := session.ChannelDelete(l.ChannelId)
+		return err
+	}
+
+	for _, user_id := range l.Members {
+		err := session.ChannelPermissionSet(l.ChannelId, user_id, "member",
+			discordgo.PermissionReadMessages|
+				discordgo.PermissionReadMessageHistory,
+			discordgo.PermissionSendMessages)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *bot) saveLobby(l *lobby) error {
+	bytes, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	return b.store.Set(fmt.Sprintf("%s.%s", bucketLobbies, l.ChannelId),
+		string(bytes))
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ewollesen/discordgo"
+)
+
+func TestQueueTakeLobby(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	bot.live_session = session
+	bot.user_id = "bot"
+	bot.lobby_channels = true
+	bot.lobby_lifetime = time.Hour
+	msg := newTestMessage(testModId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	session.allowAll()
+
+	q.Enqueue(newTestAuthor(testUserId, testBTag))
+	q.Enqueue(newTestAuthor(testUserId2, testBTag2))
+	q.Enqueue(newTestAuthor(testUserId3, testBTag3))
+	test.AssertEqual(bot.queueTake(q, newTestCommand("take", "2 lobby foo",
+		session, msg)), "Took 2 BattleTags from the scrimmages queue: "+
+		testBTag+", "+testBTag2+". 1 BattleTags remain in the queue. "+
+		"Their channel is <#channel-1>.")
+	test.AssertEqual(len(session.created), 1)
+	test.Assert(strings.HasPrefix(session.created[0], "scrims-"))
+
+	everyone := session.overwrites["channel-1/"+testGuildId]
+	test.AssertEqual(everyone[1], discordgo.PermissionReadMessages)
+	for _, user_id := range []string{"bot", testModId, testUserId,
+		testUserId2} {
+		test.AssertEqual(session.overwrites["channel-1/"+user_id][0],
+			permissionsLobby)
+	}
+	_, ok := session.overwrites["channel-1/"+testUserId3]
+	test.Assert(!ok)
+	test.AssertContainsString(session.replies, "Taken from the scrimmages "+
+		"queue: <@!"+testUserId+">, <@!"+testUserId2+">.\n"+
+		"Lobby details: lobby foo\n"+
+		"This channel will be deleted in 1h0m0s.")
+
+	bot.expireLobbies(time.Now())
+	test.Assert(!session.deleted["channel-1"])
+	bot.expireLobbies(time.Now().Add(2 * time.Hour))
+	test.Assert(session.deleted["channel-1"])
+
+	// once expired, it's forgotten
+	delete(session.deleted, "channel-1")
+	bot.expireLobbies(time.Now().Add(2 * time.Hour))
+	test.Assert(!session.deleted["channel-1"])
+}
+
+func TestLobbyArchive(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	bot.live_session = session
+	bot.lobby_channels = true
+	bot.lobby_lifetime = time.Hour
+	bot.lobby_expiry = lobbyExpiryArchive
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+
+	test.AssertEqual(bot.openLobby(session, q, nil, []Author{
+		newTestAuthor(testUserId, testBTag)}, "", ""),
+		" Their channel is <#channel-1>.")
+	test.AssertContainsString(session.replies, "Taken from the scrimmages "+
+		"queue: <@!"+testUserId+">.\n"+
+		"This channel will become read-only in 1h0m0s.")
+
+	bot.expireLobbies(time.Now().Add(2 * time.Hour))
+	test.Assert(!session.deleted["channel-1"])
+	test.AssertEqual(session.overwrites["channel-1/"+testUserId],
+		[2]int{discordgo.PermissionReadMessages |
+			discordgo.PermissionReadMessageHistory,
+			discordgo.PermissionSendMessages})
+}
@@ -0,0 +1,301 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"xmtp.net/xmtpbot/queue"
+)
+
+const (
+	bucketNotify = "discord.notify"
+)
+
+// sendDM sends msg to the user in a private channel.
+func sendDM(session Session, user_id, msg string) error {
+	ch, err := session.UserChannelCreate(user_id)
+	if err != nil {
+		return err
+	}
+
+	_, err = session.ChannelMessageSend(ch.ID, msg)
+
+	return err
+}
+
+// notifyTaken sends each of the taken users a DM, including the lobby
+// details if any were given. Returns the number of DMs successfully sent.
+func (b *bot) notifyTaken(session Session, q *scrimQueue,
+	taken []queue.Queueable, details string) (sent int) {
+
+	msg := fmt.Sprintf("You were taken from the %s!", q.Title())
+	if details != "" {
+		msg += fmt.Sprintf(" Lobby details: %s", details)
+	}
+
+	for _, queueable := range taken {
+		a := queueable.(Author)
+		err := sendDM(session, a.UserId(), msg)
+		if err != nil {
+			logger.Warnf("error notifying %s: %v", a.UserId(), err)
+			continue
+		}
+		b.forgetNotified(q, a.UserId())
+		sent++
+	}
+
+	return sent
+}
+
+// queueChanged is called after the contents of a queue have changed. The
+// session is nil when no connection to Discord is available.
+//
+// Users are sent their DMs by the process that made the change, so that
+// they're sent only once. Views of the queue are refreshed upon its events
+// instead, when it's watched, so that they follow changes made by any
+// process sharing the queue.
+func (b *bot) queueChanged(session Session, q *scrimQueue) {
+	b.promoteWaitlisted(session, q)
+	if session != nil {
+		b.notifyPositions(session, q)
+	}
+	if !b.watchingQueue(q) {
+		b.refreshQueueViews(q)
+	}
+}
+
+// refreshQueueViews updates the dashboards and pinned status messages showing
+// the queue.
+func (b *bot) refreshQueueViews(q *scrimQueue) {
+	b.notifyDashboards(q.guild_id)
+	b.statusChanged(q)
+}
+
+// notifyPositions sends a DM to each user who asked to be notified upon
+// reaching the top of the queue, and has done so since last being notified.
+func (b *bot) notifyPositions(session Session, q *scrimQueue) {
+	b.notify_prefs_mtx.Lock()
+	prefs, err := b.loadNotifyPrefs(q)
+	b.notify_prefs_mtx.Unlock()
+	if err != nil {
+		logger.Errore(err)
+		return
+	}
+	if len(prefs) == 0 {
+		return
+	}
+
+	queueables, err := q.List()
+	if err != nil {
+		logger.Errore(err)
+		return
+	}
+
+	queued := make(map[string]int)
+	for idx, queueable := range queueables {
+		queued[queueable.(Author).UserId()] = idx + 1
+	}
+
+	for user_id, top := range prefs {
+		pos, ok := queued[user_id]
+		if !ok || pos > top {
+			b.forgetNotified(q, user_id)
+			continue
+		}
+		if !b.markNotified(q, user_id) {
+			continue
+		}
+		err := sendDM(session, user_id, fmt.Sprintf("You're now in "+
+			"position %d of the %s.", pos, q.Title()))
+		if err != nil {
+			logger.Warnf("error notifying %s: %v", user_id, err)
+		}
+	}
+}
+
+// markNotified records that the user was notified of their position.
+// Returns false if they had already been notified.
+func (b *bot) markNotified(q *scrimQueue, user_id string) bool {
+	b.notified_mtx.Lock()
+	defer b.notified_mtx.Unlock()
+
+	key := fmt.Sprintf("%s-%s", q.Key(), user_id)
+	if b.notified[key] {
+		return false
+	}
+	b.notified[key] = true
+
+	return true
+}
+
+func (b *bot) forgetNotified(q *scrimQueue, user_id string) {
+	b.notified_mtx.Lock()
+	defer b.notified_mtx.Unlock()
+
+	delete(b.notified, fmt.Sprintf("%s-%s", q.Key(), user_id))
+}
+
+func (b *bot) queueNotify(q *scrimQueue, cmd Command) string {
+	arg := strings.TrimSpace(cmd.Args())
+	user_id := cmd.Message().Author.ID
+
+	b.notify_prefs_mtx.Lock()
+	prefs, err := b.loadNotifyPrefs(q)
+	b.notify_prefs_mtx.Unlock()
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error loading notification settings: %s", err)
+	}
+
+	switch arg {
+	case "":
+		top, ok := prefs[user_id]
+		if !ok {
+			return fmt.Sprintf("You aren't notified by the %s. Try "+
+				"`!queue notify 5`.", q.Title())
+		}
+		return fmt.Sprintf("You'll be sent a DM upon reaching the top %d "+
+			"of the %s.", top, q.Title())
+	case "off", "stop", "none":
+		err = b.updateNotifyPrefs(q, func(prefs map[string]int) {
+			delete(prefs, user_id)
+		})
+		b.forgetNotified(q, user_id)
+		if err != nil {
+			logger.Errore(err)
+			return fmt.Sprintf("Error saving notification settings: %s",
+				err)
+		}
+		return fmt.Sprintf("You'll no longer be sent a DM by the %s.",
+			q.Title())
+	}
+
+	top, err := strconv.Atoi(arg)
+	if err != nil || top < 1 {
+		return fmt.Sprintf("Invalid notify argument %q. Try "+
+			"`!queue notify 5` or `!queue notify off`.", arg)
+	}
+	err = b.updateNotifyPrefs(q, func(prefs map[string]int) {
+		prefs[user_id] = top
+	})
+	b.forgetNotified(q, user_id)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error saving notification settings: %s", err)
+	}
+	b.notifyPositions(cmd.Session(), q)
+
+	return fmt.Sprintf("You'll be sent a DM upon reaching the top %d of "+
+		"the %s.", top, q.Title())
+}
+
+func (b *bot) queueBroadcast(q *scrimQueue, cmd Command) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	text := strings.TrimSpace(cmd.Args())
+	if text == "" {
+		return "No message specified. Try `!queue broadcast Lobby opens " +
+			"in 5 minutes`."
+	}
+
+	queueables, err := q.List()
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error listing the %s: %s", q.Title(), err)
+	}
+
+	msg := fmt.Sprintf("Message to the %s from %s: %s", q.Title(),
+		cmd.Author().Nick(), text)
+	sent := 0
+	for _, queueable := range queueables {
+		a := queueable.(Author)
+		err := sendDM(cmd.Session(), a.UserId(), msg)
+		if err != nil {
+			logger.Warnf("error broadcasting to %s: %v", a.UserId(), err)
+			continue
+		}
+		sent++
+	}
+
+	return fmt.Sprintf("Sent the message to %d of %d queued users.", sent,
+		len(queueables))
+}
+
+func (b *bot) updateNotifyPrefs(q *scrimQueue,
+	fn func(prefs map[string]int)) error {
+
+	b.notify_prefs_mtx.Lock()
+	defer b.notify_prefs_mtx.Unlock()
+
+	prefs, err := b.loadNotifyPrefs(q)
+	if err != nil {
+		return err
+	}
+	fn(prefs)
+
+	return b.saveNotifyPrefs(q, prefs)
+}
+
+// forgetNotifyPrefs forgets the notification preferences of a deleted queue.
+func (b *bot) forgetNotifyPrefs(q *scrimQueue) error {
+	b.notify_prefs_mtx.Lock()
+	defer b.notify_prefs_mtx.Unlock()
+
+	return b.store.Set(b.notifyStoreKey(q), "")
+}
+
+func (b *bot) loadNotifyPrefs(q *scrimQueue) (map[string]int, error) {
+	prefs := make(map[string]int)
+
+	value, err := b.store.Get(b.notifyStoreKey(q))
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return prefs, nil
+	}
+
+	err = json.Unmarshal([]byte(value), &prefs)
+	if err != nil {
+		return nil, err
+	}
+
+	return prefs, nil
+}
+
+func (b *bot) saveNotifyPrefs(q *scrimQueue, prefs map[string]int) error {
+	bytes, err := json.Marshal(prefs)
+	if err != nil {
+		return err
+	}
+
+	return b.store.Set(b.notifyStoreKey(q), string(bytes))
+}
+
+func (b *bot) notifyStoreKey(q *scrimQueue) string {
+	return fmt.Sprintf("%s.%s", bucketNotify, q.Key())
+}
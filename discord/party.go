@@ -0,0 +1,519 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ewollesen/discordgo"
+	"xmtp.net/xmtpbot/queue"
+)
+
+var (
+	partyInviteWindow = flag.Duration("discord.party_invite_window",
+		10*time.Minute, "time invited users have to join a party with "+
+			"!party accept")
+	maxPartySize = flag.Int("discord.max_party_size", 6,
+		"maximum number of users in a queued party")
+)
+
+// partyInvite is an invitation, not yet accepted, to join a party queued by
+// its leader. Parties are made up of queued Authors sharing a party id, who
+// occupy consecutive positions and are taken together.
+type partyInvite struct {
+	party_id   string
+	q          *scrimQueue
+	leader     Author
+	channel_id string
+	expires    time.Time
+}
+
+func newPartyId(leader Author) string {
+	return fmt.Sprintf("%s-%d", leader.UserId(), time.Now().UnixNano())
+}
+
+// splitPartyArgs splits the arguments of !enqueue at the word "with",
+// returning the arguments before it, and the mentions following it. The
+// mentions are nil when there's no "with".
+func splitPartyArgs(args string) (rest string, mentions []string) {
+	fields := strings.Fields(args)
+	for idx, field := range fields {
+		if strings.ToLower(field) == "with" {
+			return strings.Join(fields[:idx], " "), fields[idx+1:]
+		}
+	}
+
+	return args, nil
+}
+
+// partyInvitees resolves the mentions of users invited to join the party of
+// cmd's author.
+func partyInvitees(cmd Command, mentions []string) (
+	[]*discordgo.User, string) {
+
+	if len(mentions) == 0 {
+		return nil, "No party members specified. Try `!enqueue " +
+			"MyBattleTag#1234 with @friend`."
+	}
+
+	seen := map[string]bool{cmd.Author().UserId(): true}
+	invitees := []*discordgo.User{}
+	for _, mention := range mentions {
+		user := mentionedUser(cmd.Message(), mention)
+		if user == nil {
+			return nil, fmt.Sprintf("%q isn't a user mention. Try "+
+				"`!enqueue MyBattleTag#1234 with @friend`.", mention)
+		}
+		if seen[user.ID] {
+			continue
+		}
+		seen[user.ID] = true
+		invitees = append(invitees, user)
+	}
+	if len(invitees) == 0 {
+		return nil, "You can't form a party with yourself."
+	}
+	if len(invitees)+1 > *maxPartySize {
+		return nil, fmt.Sprintf("Parties may have at most %d members.",
+			*maxPartySize)
+	}
+
+	return invitees, ""
+}
+
+// inviteToParty invites each of the users to join the party led by leader,
+// sending them a DM. Returns the text to append to the leader's reply.
+func (b *bot) inviteToParty(session Session, q *scrimQueue, leader Author,
+	channel_id string, invitees []*discordgo.User) string {
+
+	b.party_mtx.Lock()
+	defer b.party_mtx.Unlock()
+
+	mentions := []string{}
+	for _, user := range invitees {
+		b.party_invites[user.ID] = &partyInvite{
+			party_id:   leader.PartyId(),
+			q:          q,
+			leader:     leader,
+			channel_id: channel_id,
+			expires:    time.Now().Add(*partyInviteWindow),
+		}
+		err := sendDM(session, user.ID, fmt.Sprintf("%s invited you to "+
+			"join their party in the %s. Type `!party accept` (or `!party "+
+			"accept MyBattleTag#1234`) within %s to queue with them, or "+
+			"`!party decline`.", displayName(leader), q.Title(),
+			*partyInviteWindow))
+		if err != nil {
+			logger.Warnf("error notifying %s: %v", user.ID, err)
+		}
+		mentions = append(mentions, fmt.Sprintf("<@!%s>", user.ID))
+	}
+
+	return fmt.Sprintf(" Invited %s to the party; they must type `!party "+
+		"accept` within %s to join it.", strings.Join(mentions, ", "),
+		*partyInviteWindow)
+}
+
+func (b *bot) pendingPartyInvite(user_id string) *partyInvite {
+	b.party_mtx.Lock()
+	defer b.party_mtx.Unlock()
+
+	invite, ok := b.party_invites[user_id]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(invite.expires) {
+		delete(b.party_invites, user_id)
+		return nil
+	}
+
+	return invite
+}
+
+func (b *bot) forgetPartyInvite(user_id string) {
+	b.party_mtx.Lock()
+	defer b.party_mtx.Unlock()
+
+	delete(b.party_invites, user_id)
+}
+
+// pendingPartyInvitees returns the user ids of those yet to accept an
+// invite to the party.
+func (b *bot) pendingPartyInvitees(party_id string) []string {
+	b.party_mtx.Lock()
+	defer b.party_mtx.Unlock()
+
+	user_ids := []string{}
+	now := time.Now()
+	for user_id, invite := range b.party_invites {
+		if invite.party_id == party_id && now.Before(invite.expires) {
+			user_ids = append(user_ids, user_id)
+		}
+	}
+
+	return user_ids
+}
+
+// partyMembers returns the queued members of the party, in queue order.
+func partyMembers(q *scrimQueue, party_id string) ([]Author, error) {
+	queueables, err := q.List()
+	if err != nil {
+		return nil, err
+	}
+
+	members := []Author{}
+	for _, queueable := range queueables {
+		a := queueable.(Author)
+		if a.PartyId() == party_id {
+			members = append(members, a)
+		}
+	}
+
+	return members, nil
+}
+
+// takeEntries removes up to n Authors from the front of the queue, taking
+// the members of each party all together, or not at all. Parties that don't
+// fit are left in place, and those queued behind them are taken instead.
+func (b *bot) takeEntries(q *scrimQueue, n int) ([]queue.Queueable, error) {
+	queueables, err := q.List()
+	if err != nil {
+		return nil, err
+	}
+
+	parties := map[string][]queue.Queueable{}
+	for _, queueable := range queueables {
+		party_id := queueable.(Author).PartyId()
+		if party_id != "" {
+			parties[party_id] = append(parties[party_id], queueable)
+		}
+	}
+	if len(parties) == 0 {
+		return q.Dequeue(n)
+	}
+
+	selected := []queue.Queueable{}
+	considered := map[string]bool{}
+	for _, queueable := range queueables {
+		if len(selected) >= n {
+			break
+		}
+		party_id := queueable.(Author).PartyId()
+		if party_id == "" {
+			selected = append(selected, queueable)
+			continue
+		}
+		if considered[party_id] {
+			continue
+		}
+		considered[party_id] = true
+		if len(selected)+len(parties[party_id]) <= n {
+			selected = append(selected, parties[party_id]...)
+		}
+	}
+
+	taken := []queue.Queueable{}
+	for _, queueable := range selected {
+		removed, err := q.Remove(queueable.Key())
+		if err != nil {
+			if queue.NotFoundError.Contains(err) {
+				continue
+			}
+			return taken, err
+		}
+		taken = append(taken, removed)
+	}
+
+	return taken, nil
+}
+
+// dissolveParty lets the queued members of the party be taken separately,
+// leaving each of them in place.
+func dissolveParty(q *scrimQueue, party_id string) ([]Author, error) {
+	members, err := partyMembers(q, party_id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range members {
+		err := q.Update(a.Key(), func(queueable queue.Queueable) error {
+			queueable.(Author).SetPartyId("")
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		a.SetPartyId("")
+	}
+
+	return members, nil
+}
+
+// leftParty handles a from having left the queue while in a party, offering
+// its remaining members to dissolve it. A party left with a single member is
+// dissolved right away. Returns the text to append to the reply.
+func (b *bot) leftParty(session Session, q *scrimQueue, a Author) string {
+	if a.PartyId() == "" {
+		return ""
+	}
+
+	members, err := partyMembers(q, a.PartyId())
+	if err != nil {
+		logger.Errore(err)
+		return ""
+	}
+	switch len(members) {
+	case 0:
+		return ""
+	case 1:
+		_, err = dissolveParty(q, a.PartyId())
+		if err != nil {
+			logger.Errore(err)
+			return ""
+		}
+		return fmt.Sprintf(" %s remains queued, no longer in a party.",
+			displayName(members[0]))
+	}
+
+	for _, member := range members {
+		if session == nil {
+			// removed through the API while disconnected
+			break
+		}
+		err := sendDM(session, member.UserId(), fmt.Sprintf("%s left your "+
+			"party in the %s. Your party of %d stays queued together; type "+
+			"`!party dissolve` if you'd rather be taken separately.",
+			displayName(a), q.Title(), len(members)))
+		if err != nil {
+			logger.Warnf("error notifying %s: %v", member.UserId(), err)
+		}
+	}
+
+	return fmt.Sprintf(" The %d remaining party members stay queued "+
+		"together; any of them may type `!party dissolve` to be taken "+
+		"separately.", len(members))
+}
+
+func (b *bot) party(cmd Command) (err error) {
+	pieces := strings.SplitN(strings.TrimSpace(cmd.Args()), " ", 2)
+	subcommand := &command{
+		name:    strings.ToLower(pieces[0]),
+		message: cmd.Message(),
+		session: cmd.Session(),
+	}
+	if len(pieces) > 1 {
+		subcommand.args = strings.TrimSpace(pieces[1])
+	}
+
+	switch subcommand.name {
+	case "help":
+		return cmd.Reply(b.partyHelp())
+	case "accept", "join":
+		return cmd.Reply(b.partyAccept(subcommand))
+	case "decline":
+		return cmd.Reply(b.partyDecline(subcommand))
+	}
+
+	q, rest, err := b.selectQueue(cmd.Message().ChannelID, cmd.Session(),
+		subcommand.args)
+	if err != nil {
+		logger.Errore(err)
+		return cmd.Reply("Error looking up guild: %s", err)
+	}
+	subcommand.args = rest
+
+	switch subcommand.name {
+	case "", "show", "list":
+		return cmd.Reply(b.partyShow(q, subcommand))
+	case "dissolve", "disband":
+		return cmd.Reply(b.partyDissolve(q, subcommand))
+	}
+
+	return cmd.Reply("Unhandled party command: %q", cmd.Args())
+}
+
+func (b *bot) partyHelp() string {
+	return "Queue together with friends; a party's members are taken all together, or not at all.\n`!enqueue [name] MyBattleTag#1234 with @friend1 @friend2` -- enter the queue, inviting friends to join your party\n`!party accept [MyBattleTag#1234]` -- accept an invite, joining the party in the queue\n`!party decline` -- decline an invite\n`!party [name]` -- display your party\n`!party dissolve [name]` -- let your party's members be taken separately"
+}
+
+func (b *bot) partyAccept(cmd Command) string {
+	user := cmd.Message().Author
+	invite := b.pendingPartyInvite(user.ID)
+	if invite == nil {
+		return "You have no pending party invites."
+	}
+
+	q := b.lookupNamedQueue(invite.q.guild_id, invite.q.name)
+	members, err := partyMembers(q, invite.party_id)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error looking up the party: %s", err)
+	}
+	if len(members) == 0 {
+		b.forgetPartyInvite(user.ID)
+		return fmt.Sprintf("%s's party is no longer in the %s.",
+			displayName(invite.leader), q.Title())
+	}
+	if len(members) >= *maxPartySize {
+		b.forgetPartyInvite(user.ID)
+		return fmt.Sprintf("%s's party is already full.",
+			displayName(invite.leader))
+	}
+
+	a := newAuthor(user, cmd.Session(), invite.channel_id)
+	a.GuildId = q.guild_id
+	if q.Position(a.Key()) > -1 || q.waitlistPosition(a.Key()) > -1 {
+		return fmt.Sprintf("You're already in the %s. Type `!dequeue` "+
+			"first, then accept the invite again.", q.Title())
+	}
+	if q.waitlist != nil && q.Size() >= q.waitlist.Capacity() {
+		return fmt.Sprintf("The %s is full, so you can't join the party "+
+			"right now.", q.Title())
+	}
+
+	btag, msg := b.gameId(q, a, cmd.Args(), "!party accept")
+	if msg != "" {
+		return msg
+	}
+
+	// party members share the leader's place, in priority mode as well
+	leader := members[0]
+	a.SetBattleTag(btag)
+	a.SetPartyId(invite.party_id)
+	a.SetEnqueuedAt(leader.EnqueuedAt())
+	a.SetSkips(leader.Skips())
+
+	pos := q.Position(members[len(members)-1].Key()) + 1
+	err = q.Insert(pos, a)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error joining the party: %s", err)
+	}
+	if q.waitlistPosition(a.Key()) > -1 {
+		// the queue filled up since it was checked, and parties aren't
+		// split between it and its waitlist
+		_, err = q.waitlist.Waitlist().Remove(a.Key())
+		logger.Errore(err)
+		return fmt.Sprintf("The %s is full, so you can't join the party "+
+			"right now.", q.Title())
+	}
+	b.forgetPartyInvite(user.ID)
+	b.recordQueueEvent(q, "enqueue", a, a, pos,
+		fmt.Sprintf("(party of %s)", displayName(leader)))
+	b.queueChanged(cmd.Session(), q)
+
+	return fmt.Sprintf("Successfully added %s to the %s in position %d, "+
+		"with %s's party.", btag, q.Title(), pos, displayName(leader))
+}
+
+func (b *bot) partyDecline(cmd Command) string {
+	invite := b.pendingPartyInvite(cmd.Message().Author.ID)
+	if invite == nil {
+		return "You have no pending party invites."
+	}
+	b.forgetPartyInvite(cmd.Message().Author.ID)
+
+	return fmt.Sprintf("Declined %s's invite.", displayName(invite.leader))
+}
+
+func (b *bot) partyShow(q *scrimQueue, cmd Command) string {
+	queued, err := findQueued(q, cmd, cmd.Author().Mention())
+	if err != nil {
+		if queue.NotFoundError.Contains(err) {
+			return fmt.Sprintf("You aren't in the %s.", q.Title())
+		}
+		logger.Errore(err)
+		return fmt.Sprintf("Error looking up your party: %s", err)
+	}
+	party_id := queued.PartyId()
+	if party_id == "" {
+		return fmt.Sprintf("You aren't in a party in the %s. Try "+
+			"`!party help`.", q.Title())
+	}
+
+	members, err := partyMembers(q, party_id)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error looking up your party: %s", err)
+	}
+	names := []string{}
+	for _, a := range members {
+		names = append(names, fmt.Sprintf("%s (%d)", displayName(a),
+			q.Position(a.Key())))
+	}
+	msg := fmt.Sprintf("Your party in the %s: %s.", q.Title(),
+		strings.Join(names, ", "))
+	pending := b.pendingPartyInvitees(party_id)
+	if len(pending) > 0 {
+		mentions := []string{}
+		for _, user_id := range pending {
+			mentions = append(mentions, fmt.Sprintf("<@!%s>", user_id))
+		}
+		msg += fmt.Sprintf(" Invited: %s.", strings.Join(mentions, ", "))
+	}
+
+	return msg
+}
+
+func (b *bot) partyDissolve(q *scrimQueue, cmd Command) string {
+	queued, err := findQueued(q, cmd, cmd.Author().Mention())
+	if err != nil {
+		if queue.NotFoundError.Contains(err) {
+			return fmt.Sprintf("You aren't in the %s.", q.Title())
+		}
+		logger.Errore(err)
+		return fmt.Sprintf("Error dissolving your party: %s", err)
+	}
+	party_id := queued.PartyId()
+	if party_id == "" {
+		return fmt.Sprintf("You aren't in a party in the %s.", q.Title())
+	}
+
+	members, err := dissolveParty(q, party_id)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error dissolving your party: %s", err)
+	}
+	b.party_mtx.Lock()
+	for user_id, invite := range b.party_invites {
+		if invite.party_id == party_id {
+			delete(b.party_invites, user_id)
+		}
+	}
+	b.party_mtx.Unlock()
+	b.recordQueueEvent(q, "dissolve", cmd.Author(), cmd.Author(), 0,
+		fmt.Sprintf("(party of %d)", len(members)))
+	b.queueChanged(cmd.Session(), q)
+
+	for _, a := range members {
+		if a.UserId() == cmd.Author().UserId() {
+			continue
+		}
+		err := sendDM(cmd.Session(), a.UserId(), fmt.Sprintf("%s dissolved "+
+			"your party in the %s. You keep your place in the queue, but "+
+			"may be taken separately.", displayName(cmd.Author()),
+			q.Title()))
+		if err != nil {
+			logger.Warnf("error notifying %s: %v", a.UserId(), err)
+		}
+	}
+
+	return fmt.Sprintf("Dissolved your party in the %s. Its %d members "+
+		"keep their places, but may be taken separately.", q.Title(),
+		len(members))
+}
@@ -0,0 +1,107 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"testing"
+)
+
+func TestPartyTakenTogether(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	msg2 := newTestMessage(testUserId2, testChannelId)
+	msg3 := newTestMessage(testUserId3, testChannelId)
+
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", testBTag+" with",
+		session, msg)))
+	test.AssertContainsString(session.replies, "No party members "+
+		"specified. Try `!enqueue MyBattleTag#1234 with @friend`.")
+
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", testBTag+
+		" with <@!"+testUserId2+">", session, msg)))
+	test.AssertContainsString(session.replies, "Successfully added "+
+		testBTag+" to the scrimmages queue in position 1. Invited <@!"+
+		testUserId2+"> to the party; they must type `!party accept` "+
+		"within 10m0s to join it.")
+	test.AssertEqual(len(session.dms[testUserId2]), 1)
+
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", testBTag3,
+		session, msg3)))
+	test.AssertNil(bot.party(newTestCommand("party", "accept "+testBTag2,
+		session, msg2)))
+	test.AssertContainsString(session.replies, "Successfully added "+
+		testBTag2+" to the scrimmages queue in position 2, with "+
+		testBTag+"'s party.")
+	test.AssertNil(bot.party(newTestCommand("party", "accept", session,
+		msg2)))
+	test.AssertContainsString(session.replies,
+		"You have no pending party invites.")
+
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	test.AssertEqual(q.Position(newTestAuthor(testUserId3, testBTag3).Key()),
+		3)
+	test.AssertNil(bot.party(newTestCommand("party", "", session, msg)))
+	test.AssertContainsString(session.replies, "Your party in the "+
+		"scrimmages queue: "+testBTag+" (1), "+testBTag2+" (2).")
+
+	session.allowAll()
+	test.AssertEqual(bot.queueTake(q, newTestCommand("take", "1", session,
+		msg)), "Took 1 BattleTags from the scrimmages queue: "+testBTag3+
+		". 2 BattleTags remain in the queue.")
+	test.AssertEqual(bot.queueTake(q, newTestCommand("take", "2", session,
+		msg)), "Took 2 BattleTags from the scrimmages queue: "+testBTag+
+		", "+testBTag2+". 0 BattleTags remain in the queue.")
+}
+
+func TestPartyDissolve(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	msg2 := newTestMessage(testUserId2, testChannelId)
+	msg3 := newTestMessage(testUserId3, testChannelId)
+
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", testBTag+
+		" with <@!"+testUserId2+"> <@!"+testUserId3+">", session, msg)))
+	test.AssertNil(bot.party(newTestCommand("party", "accept "+testBTag2,
+		session, msg2)))
+	test.AssertNil(bot.party(newTestCommand("party", "accept "+testBTag3,
+		session, msg3)))
+
+	test.AssertNil(bot.dequeue(newTestCommand("dequeue", "", session, msg2)))
+	test.AssertContainsString(session.replies, "Successfully removed "+
+		testBTag2+" from the scrimmages queue. The 2 remaining party "+
+		"members stay queued together; any of them may type `!party "+
+		"dissolve` to be taken separately.")
+	test.AssertContainsString(session.dms[testUserId3], testBTag2+" left "+
+		"your party in the scrimmages queue. Your party of 2 stays queued "+
+		"together; type `!party dissolve` if you'd rather be taken "+
+		"separately.")
+
+	test.AssertNil(bot.party(newTestCommand("party", "dissolve", session,
+		msg3)))
+	test.AssertContainsString(session.replies, "Dissolved your party in "+
+		"the scrimmages queue. Its 2 members keep their places, but may be "+
+		"taken separately.")
+	test.AssertNil(bot.party(newTestCommand("party", "", session, msg)))
+	test.AssertContainsString(session.replies, "You aren't in a party in "+
+		"the scrimmages queue. Try `!party help`.")
+
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	test.AssertEqual(q.Position(newTestAuthor(testUserId, testBTag).Key()),
+		1)
+	test.AssertEqual(q.Position(newTestAuthor(testUserId3, testBTag3).Key()),
+		2)
+}
@@ -0,0 +1,234 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	bucketPlayers = "discord.players"
+
+	roleDPS     = "dps"
+	roleSupport = "support"
+	roleTank    = "tank"
+
+	maxRating = 5000
+)
+
+var (
+	allRoles = []string{roleTank, roleSupport, roleDPS}
+)
+
+// player is what's known of a guild member, independent of any queue.
+type player struct {
+	BattleTag string         `json:"battle_tag,omitempty"`
+	Ratings   map[string]int `json:"ratings,omitempty"`
+}
+
+// parseRole returns the canonical name of the role, or "" if it isn't one.
+func parseRole(role string) string {
+	switch strings.ToLower(role) {
+	case "tank", "tanks":
+		return roleTank
+	case "support", "supports", "supp", "heal", "healer", "heals":
+		return roleSupport
+	case "dps", "damage", "dam":
+		return roleDPS
+	}
+
+	return ""
+}
+
+func (b *bot) sr(cmd Command) (err error) {
+	pieces := strings.SplitN(strings.TrimSpace(cmd.Args()), " ", 2)
+	args := ""
+	if len(pieces) > 1 {
+		args = strings.TrimSpace(pieces[1])
+	}
+
+	guild_id, err := cmd.Session().GuildIdFromChannelId(
+		cmd.Message().ChannelID)
+	if err != nil {
+		logger.Errore(err)
+		return cmd.Reply("Error looking up guild: %s", err)
+	}
+
+	switch pieces[0] {
+	case "", "show":
+		return cmd.Reply(b.srShow(guild_id, cmd, args))
+	case "set":
+		return cmd.Reply(b.srSet(guild_id, cmd, args))
+	case "clear", "unset":
+		return cmd.Reply(b.srClear(guild_id, cmd, args))
+	case "help":
+		return cmd.Reply(srHelp())
+	}
+
+	return cmd.Reply("Unhandled sr command: %q", cmd.Args())
+}
+
+func srHelp() string {
+	return "Records your skill rating (SR) for each role, for balancing " +
+		"teams.\n`!sr` -- display your skill ratings\n`!sr show @user` -- " +
+		"display a user's skill ratings\n`!sr set <tank|support|dps> " +
+		"<rating>` -- set your skill rating for a role\n`!sr clear " +
+		"<tank|support|dps>` -- forget your skill rating for a role"
+}
+
+func (b *bot) srShow(guild_id string, cmd Command, args string) string {
+	user_id := cmd.Message().Author.ID
+	name := "You have"
+	if args != "" {
+		user := mentionedUser(cmd.Message(), args)
+		if user == nil {
+			return fmt.Sprintf("%q doesn't mention a user.", args)
+		}
+		user_id = user.ID
+		name = fmt.Sprintf("<@!%s> has", user_id)
+	}
+
+	p, err := b.loadPlayer(guild_id, user_id)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error loading skill ratings: %s", err)
+	}
+	if len(p.Ratings) == 0 {
+		return fmt.Sprintf("%s no skill ratings. Try `!sr set tank 2800`.",
+			name)
+	}
+
+	return fmt.Sprintf("%s skill ratings of %s.", name, formatRatings(p))
+}
+
+func (b *bot) srSet(guild_id string, cmd Command, args string) string {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return "Try `!sr set tank 2800`."
+	}
+	role := parseRole(fields[0])
+	if role == "" {
+		return fmt.Sprintf("Unknown role %q. Roles are tank, support and "+
+			"dps.", fields[0])
+	}
+	rating, err := strconv.Atoi(fields[1])
+	if err != nil || rating < 0 || rating > maxRating {
+		return fmt.Sprintf("Invalid skill rating %q.", fields[1])
+	}
+
+	btag, err := cmd.Author().BattleTag()
+	if err != nil {
+		btag = ""
+	}
+
+	err = b.updatePlayer(guild_id, cmd.Message().Author.ID,
+		func(p *player) {
+			p.Ratings[role] = rating
+			if btag != "" {
+				p.BattleTag = btag
+			}
+		})
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error saving skill rating: %s", err)
+	}
+
+	return fmt.Sprintf("Set your %s skill rating to %d.", role, rating)
+}
+
+func (b *bot) srClear(guild_id string, cmd Command, args string) string {
+	role := parseRole(args)
+	if role == "" {
+		return fmt.Sprintf("Unknown role %q. Roles are tank, support and "+
+			"dps.", args)
+	}
+
+	err := b.updatePlayer(guild_id, cmd.Message().Author.ID,
+		func(p *player) {
+			delete(p.Ratings, role)
+		})
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error saving skill rating: %s", err)
+	}
+
+	return fmt.Sprintf("Cleared your %s skill rating.", role)
+}
+
+func formatRatings(p *player) string {
+	ratings := []string{}
+	for role, rating := range p.Ratings {
+		ratings = append(ratings, fmt.Sprintf("%s %d", role, rating))
+	}
+	sort.Strings(ratings)
+
+	return strings.Join(ratings, ", ")
+}
+
+func (b *bot) updatePlayer(guild_id, user_id string,
+	fn func(p *player)) error {
+
+	b.players_mtx.Lock()
+	defer b.players_mtx.Unlock()
+
+	p, err := b.loadPlayer(guild_id, user_id)
+	if err != nil {
+		return err
+	}
+	fn(p)
+
+	return b.savePlayer(guild_id, user_id, p)
+}
+
+func (b *bot) loadPlayer(guild_id, user_id string) (*player, error) {
+	p := &player{
+		Ratings: make(map[string]int),
+	}
+
+	value, err := b.store.Get(b.playerStoreKey(guild_id, user_id))
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return p, nil
+	}
+
+	err = json.Unmarshal([]byte(value), p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Ratings == nil {
+		p.Ratings = make(map[string]int)
+	}
+
+	return p, nil
+}
+
+func (b *bot) savePlayer(guild_id, user_id string, p *player) error {
+	bytes, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	return b.store.Set(b.playerStoreKey(guild_id, user_id), string(bytes))
+}
+
+func (b *bot) playerStoreKey(guild_id, user_id string) string {
+	return fmt.Sprintf("%s.%s.%s", bucketPlayers, guild_id, user_id)
+}
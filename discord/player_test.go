@@ -0,0 +1,54 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"testing"
+)
+
+func TestSr(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+
+	test.AssertNil(bot.sr(newTestCommand("sr", "", session, msg)))
+	test.AssertNil(bot.sr(newTestCommand("sr", "set tank 2800", session,
+		msg)))
+	test.AssertNil(bot.sr(newTestCommand("sr", "set heals 2600", session,
+		msg)))
+	test.AssertNil(bot.sr(newTestCommand("sr", "set mercy 2600", session,
+		msg)))
+	test.AssertNil(bot.sr(newTestCommand("sr", "set dps 9000", session,
+		msg)))
+	test.AssertNil(bot.sr(newTestCommand("sr", "", session, msg)))
+	test.AssertNil(bot.sr(newTestCommand("sr", "clear tank", session,
+		msg)))
+	test.AssertNil(bot.sr(newTestCommand("sr", "show <@"+testUserId+">",
+		session, msg)))
+
+	expected := []string{
+		"You have no skill ratings. Try `!sr set tank 2800`.",
+		"Set your tank skill rating to 2800.",
+		"Set your support skill rating to 2600.",
+		"Unknown role \"mercy\". Roles are tank, support and dps.",
+		"Invalid skill rating \"9000\".",
+		"You have skill ratings of support 2600, tank 2800.",
+		"Cleared your tank skill rating.",
+		"<@!" + testUserId + "> has skill ratings of support 2600.",
+	}
+	test.AssertEqual(len(session.replies), len(expected))
+	for idx, reply := range session.replies {
+		test.AssertEqual(reply, expected[idx])
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"xmtp.net/xmtpbot/queue"
+)
+
+const (
+	bucketSkips = "discord.skips"
+)
+
+// priorityQueue returns the priority wrapper of the queue stored under key,
+// creating it if needed. Wrappers are kept so that their locking is shared by
+// every command using the queue.
+func (b *bot) priorityQueue(key string, q queue.Queue) queue.PriorityQueue {
+	b.priority_mtx.Lock()
+	defer b.priority_mtx.Unlock()
+
+	pq, ok := b.priority_queues[key]
+	if !ok {
+		pq = queue.NewPriority(q, *queue.SkipCredit)
+		b.priority_queues[key] = pq
+	}
+
+	return pq
+}
+
+func (b *bot) forgetPriorityQueue(key string) {
+	b.priority_mtx.Lock()
+	defer b.priority_mtx.Unlock()
+
+	delete(b.priority_queues, key)
+}
+
+func (q *scrimQueue) priority() (queue.PriorityQueue, bool) {
+	return q.prio, q.prio != nil
+}
+
+// prepareQueued stamps a with the time it entered the queue, the roles
+// they've declared, either by reacting to its status or with `!roles set`,
+// and the number of times it has previously been skipped.
+func (b *bot) prepareQueued(q *scrimQueue, a Author) {
+	a.SetEnqueuedAt(time.Now())
+	if roles := b.declaredRoles(q, a.UserId()); roles != nil {
+		a.SetRoles(roles)
+	} else if roles, err := b.loadRoles(a.UserId()); err != nil {
+		logger.Errore(err)
+	} else if len(roles) > 0 {
+		a.SetRoles(roles)
+	}
+	if _, ok := q.priority(); !ok {
+		return
+	}
+
+	b.skips_mtx.Lock()
+	skips, err := b.loadSkips(q)
+	b.skips_mtx.Unlock()
+	if err != nil {
+		logger.Errore(err)
+		return
+	}
+	a.SetSkips(skips[a.UserId()])
+}
+
+// recordSkips counts one more skip for each of the authors, moving any who
+// are queued up to the position their new priority warrants.
+func (b *bot) recordSkips(q *scrimQueue, authors []Author) error {
+	counts := make(map[string]int)
+	err := b.updateSkips(q, func(skips map[string]int) {
+		for _, a := range authors {
+			skips[a.UserId()]++
+			counts[a.UserId()] = skips[a.UserId()]
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	pq, ok := q.priority()
+	if !ok {
+		return nil
+	}
+	for _, a := range authors {
+		if q.Position(a.Key()) < 0 {
+			continue
+		}
+		skips := counts[a.UserId()]
+		a.SetSkips(skips)
+		err = pq.Requeue(a.Key(), func(queueable queue.Queueable) error {
+			queueable.(Author).SetSkips(skips)
+			return nil
+		})
+		if err != nil && !queue.NotFoundError.Contains(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resetSkips forgets the skips of the taken users.
+func (b *bot) resetSkips(q *scrimQueue, taken []queue.Queueable) error {
+	return b.updateSkips(q, func(skips map[string]int) {
+		for _, queueable := range taken {
+			delete(skips, queueable.(Author).UserId())
+		}
+	})
+}
+
+func (b *bot) queueMode(q *scrimQueue, cmd Command) string {
+	mode := strings.ToLower(strings.TrimSpace(cmd.Args()))
+	if mode == "" {
+		if _, ok := q.priority(); ok {
+			return fmt.Sprintf("The %s is in priority mode.", q.Title())
+		}
+		return fmt.Sprintf("The %s is in fifo mode.", q.Title())
+	}
+
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	enabled := false
+	switch mode {
+	case "fifo":
+	case "priority":
+		enabled = true
+	default:
+		return fmt.Sprintf("Invalid mode %q. Try `!queue mode fifo` or "+
+			"`!queue mode priority`.", mode)
+	}
+
+	err = b.queue_registry.SetPriority(q.guild_id, q.name, enabled)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error setting the mode of the %s: %s",
+			q.Title(), err)
+	}
+	b.forgetPriorityQueue(q.Key())
+
+	return fmt.Sprintf("The %s is now in %s mode.", q.Title(), mode)
+}
+
+func (b *bot) queueSkip(q *scrimQueue, cmd Command) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	args := strings.Fields(cmd.Args())
+	if len(args) < 1 {
+		return "Try `!queue skip @user` or `!queue skip example#1234`."
+	}
+
+	a, err := findQueued(q, cmd, args[0])
+	if err != nil {
+		if !queue.NotFoundError.Contains(err) {
+			logger.Errore(err)
+			return fmt.Sprintf("Error skipping %s: %s", args[0], err)
+		}
+		user := mentionedUser(cmd.Message(), args[0])
+		if user == nil {
+			return fmt.Sprintf("%s wasn't found in the %s.", args[0],
+				q.Title())
+		}
+		a = newAuthor(user, cmd.Session(), cmd.Message().ChannelID)
+	}
+
+	err = b.recordSkips(q, []Author{a})
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error skipping %s: %s", args[0], err)
+	}
+	pos := q.Position(a.Key())
+	b.recordQueueEvent(q, "skip", cmd.Author(), a, pos, "")
+	if pos < 0 {
+		return fmt.Sprintf("Recorded a skip for %s.", displayName(a))
+	}
+	b.queueChanged(cmd.Session(), q)
+
+	return fmt.Sprintf("Recorded a skip for %s, now in position %d of the "+
+		"%s.", displayName(a), pos, q.Title())
+}
+
+func (b *bot) updateSkips(q *scrimQueue, fn func(skips map[string]int)) error {
+	b.skips_mtx.Lock()
+	defer b.skips_mtx.Unlock()
+
+	skips, err := b.loadSkips(q)
+	if err != nil {
+		return err
+	}
+	fn(skips)
+
+	return b.saveSkips(q, skips)
+}
+
+func (b *bot) loadSkips(q *scrimQueue) (map[string]int, error) {
+	skips := make(map[string]int)
+
+	value, err := b.store.Get(b.skipsStoreKey(q))
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return skips, nil
+	}
+
+	err = json.Unmarshal([]byte(value), &skips)
+	if err != nil {
+		return nil, err
+	}
+
+	return skips, nil
+}
+
+func (b *bot) saveSkips(q *scrimQueue, skips map[string]int) error {
+	bytes, err := json.Marshal(skips)
+	if err != nil {
+		return err
+	}
+
+	return b.store.Set(b.skipsStoreKey(q), string(bytes))
+}
+
+func (b *bot) skipsStoreKey(q *scrimQueue) string {
+	return fmt.Sprintf("%s.%s", bucketSkips, q.Key())
+}
@@ -16,6 +16,7 @@ package discord
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -30,32 +31,41 @@ const (
 )
 
 var (
+	mentionRe = regexp.MustCompile(`^<@!?(\d+)>$`)
+
 	symbolChecked = string([]byte{0xe2, 0x9c, 0x93})
 	symbolSaltire = string([]byte{0xe2, 0x98, 0x93})
 )
 
 func (b *bot) dequeue(cmd Command) (err error) {
-	q, err := b.lookupQueue(cmd.Message().ChannelID, cmd.Session())
+	q, _, err := b.selectQueue(cmd.Message().ChannelID, cmd.Session(),
+		cmd.Args())
 	if err != nil {
 		logger.Errore(err)
 		return cmd.Reply("Error looking up guild: %s", err)
 	}
 
+	return b.dequeueFrom(q, cmd)
+}
+
+// dequeueFrom removes the command's author from the queue.
+func (b *bot) dequeueFrom(q *scrimQueue, cmd Command) (err error) {
+	pos := q.Position(cmd.Author().Key())
 	queueable, err := q.Remove(cmd.Author().Key())
 	if err != nil {
 		if queue.NotFoundError.Contains(err) {
 			btag, err := cmd.Author().BattleTag()
 			if err != nil {
-				return cmd.Reply("Error removing %s from the scrimmages queue: %s",
-					cmd.Author().Nick(), err)
+				return cmd.Reply("Error removing %s from the %s: %s",
+					cmd.Author().Nick(), q.Title(), err)
 			}
-			return cmd.Reply("BattleTag %q wasn't found in the "+
-				"scrimmages queue.", btag)
+			return cmd.Reply("BattleTag %q wasn't found in the %s.",
+				btag, q.Title())
 		}
 	}
 	if err != nil {
-		return cmd.Reply("Error removing %s from the scrimmages queue: %s",
-			cmd.Author().Nick(), err)
+		return cmd.Reply("Error removing %s from the %s: %s",
+			cmd.Author().Nick(), q.Title(), err)
 	}
 
 	if queueable == nil {
@@ -64,14 +74,19 @@ func (b *bot) dequeue(cmd Command) (err error) {
 			cmd.Author().Nick())
 	}
 
+	b.recordQueueEvent(q, "dequeue", cmd.Author(), queueable.(Author), pos,
+		"")
 	a := queueable.(Author)
+	party_msg := b.leftParty(cmd.Session(), q, a)
+	b.queueChanged(cmd.Session(), q)
+
 	btag, err := a.BattleTag()
 	if err != nil {
 		btag = a.Nick()
 	}
 
-	return cmd.Reply("Successfully removed %s from the scrimmages "+
-		"queue.", btag)
+	return cmd.Reply("Successfully removed %s from the %s.%s", btag,
+		q.Title(), party_msg)
 }
 
 func (b *bot) userEnqueueRateLimitTriggered(key string) bool {
@@ -94,43 +109,70 @@ func (b *bot) userEnqueued(key string, at time.Time) {
 }
 
 func (b *bot) enqueue(cmd Command) (err error) {
-	btag := ""
-	args := strings.Split(cmd.Args(), " ")
-	if len(args) > 0 && args[0] != "" {
-		btag = args[0]
+	q, rest, err := b.selectQueue(cmd.Message().ChannelID, cmd.Session(),
+		cmd.Args())
+	if err != nil {
+		logger.Errore(err)
+		return cmd.Reply("Error looking up guild: %s", err)
 	}
 
-	if btag == "" {
-		btag, err = cmd.Author().BattleTag()
-		if err != nil || btag == "" {
-			return cmd.Reply("No BattleTag specified. " +
-				"Try `!enqueue example#1234`.")
+	return b.enqueueIn(q, cmd, rest)
+}
+
+// enqueueIn adds the command's author to the queue, with the BattleTag and
+// party invitees given by rest, if any.
+func (b *bot) enqueueIn(q *scrimQueue, cmd Command, rest string) (
+	err error) {
+
+	rest, mentions := splitPartyArgs(rest)
+	var invitees []*discordgo.User
+	if mentions != nil {
+		var msg string
+		invitees, msg = partyInvitees(cmd, mentions)
+		if invitees == nil {
+			return cmd.Reply(msg)
 		}
 	}
-	if !util.ValidBattleTag(btag) {
-		return cmd.Reply("BattleTag %q appears to be invalid.", btag)
+
+	btag, msg := b.gameId(q, cmd.Author(), rest, "!enqueue")
+	if msg != "" {
+		return cmd.Reply(msg)
 	}
 
 	cmd.Author().SetBattleTag(btag)
-
-	q, err := b.lookupQueue(cmd.Message().ChannelID, cmd.Session())
-	if err != nil {
-		logger.Errore(err)
-		return cmd.Reply("Error looking up guild: %s", err)
-	}
+	b.prepareQueued(q, cmd.Author())
 
 	pos := q.Position(cmd.Author().Key())
 	if pos > -1 {
 		return cmd.Reply("User %s is already queued as %q "+
 			"in position %d.", cmd.Author().Mention(), btag, pos)
 	}
+	pos = q.waitlistPosition(cmd.Author().Key())
+	if pos > -1 {
+		return cmd.Reply("User %s is already waitlisted as %q "+
+			"in position %d.", cmd.Author().Mention(), btag, pos)
+	}
+
+	if open, msg := b.queueOpen(q); !open {
+		return cmd.Reply(msg)
+	}
 
-	if b.userEnqueueRateLimitTriggered(cmd.Author().Key()) {
+	rate_limit_key := q.rateLimitKey(cmd.Author())
+	if b.userEnqueueRateLimitTriggered(rate_limit_key) {
 		return cmd.Reply("You may enqueue at most once every 5 "+
 			"minutes, %s. Please try again later.",
 			cmd.Author().Mention())
 	}
 
+	if invitees != nil {
+		if q.waitlist != nil && (q.Size() >= q.waitlist.Capacity() ||
+			q.waitlist.Waitlist().Size() > 0) {
+			return cmd.Reply("The %s is full, so parties can't be formed "+
+				"in it right now.", q.Title())
+		}
+		cmd.Author().SetPartyId(newPartyId(cmd.Author()))
+	}
+
 	err = q.Enqueue(cmd.Author())
 	if err != nil {
 		if queue.AlreadyQueuedError.Contains(err) {
@@ -142,41 +184,83 @@ func (b *bot) enqueue(cmd Command) (err error) {
 		return cmd.Reply("Error enqueueing: %s", err)
 	}
 
-	b.userEnqueued(cmd.Author().Key(), time.Now())
+	b.userEnqueued(rate_limit_key, time.Now())
+	pos = q.waitlistPosition(cmd.Author().Key())
+	if pos > -1 {
+		b.recordQueueEvent(q, "waitlist", cmd.Author(), cmd.Author(), pos,
+			"")
+		b.queueChanged(cmd.Session(), q)
+		return cmd.Reply("The %s is full, so %s was added to its "+
+			"waitlist in position %d. You'll be sent a DM when a spot "+
+			"opens up.", q.Title(), btag, pos)
+	}
+	pos = q.Position(cmd.Author().Key())
+	b.recordQueueEvent(q, "enqueue", cmd.Author(), cmd.Author(), pos, "")
+	b.queueChanged(cmd.Session(), q)
+
+	msg = fmt.Sprintf("Successfully added %s to the %s in position %d.",
+		btag, q.Title(), pos)
+	if invitees != nil {
+		msg += b.inviteToParty(cmd.Session(), q, cmd.Author(),
+			cmd.Message().ChannelID, invitees)
+	}
 
-	return cmd.Reply("Successfully added %s to the scrimmages "+
-		"queue in position %d.", btag, q.Size())
+	return cmd.Reply(msg)
 }
 
 func (b *bot) queue(cmd Command) (err error) {
 	msg := ""
-	pieces := strings.SplitN(cmd.Args(), " ", 3)
+	pieces := strings.SplitN(cmd.Args(), " ", 2)
 	cmd_name := pieces[0]
 	subcommand := &command{
+		name:    cmd_name,
 		message: cmd.Message(),
 		session: cmd.Session(),
 	}
 	if len(pieces) > 1 {
-		subcommand.name = pieces[1]
+		subcommand.args = strings.TrimSpace(pieces[1])
 	}
-	if len(pieces) > 2 {
-		subcommand.args = pieces[2]
+
+	switch cmd_name {
+	case "create", "new":
+		return cmd.Reply(b.queueCreate(subcommand))
+	case "delete", "destroy":
+		return cmd.Reply(b.queueDelete(subcommand))
+	case "bind":
+		return cmd.Reply(b.queueBind(subcommand))
+	case "unbind":
+		return cmd.Reply(b.queueUnbind(subcommand))
+	case "names", "queues":
+		return cmd.Reply(b.queueNames(subcommand))
+	case "dashboard", "web":
+		return cmd.Reply(b.queueDashboard(subcommand))
+	case "token":
+		return cmd.Reply(b.queueToken(subcommand))
+	case "migrate":
+		return cmd.Reply(b.queueMigrate(subcommand))
 	}
-	q, err := b.lookupQueue(cmd.Message().ChannelID, cmd.Session())
+
+	q, rest, err := b.selectQueue(cmd.Message().ChannelID, cmd.Session(),
+		subcommand.args)
 	if err != nil {
 		logger.Errore(err)
 		return cmd.Reply("Error looking up guild: %s", err)
 	}
+	subcommand.args = rest
 
 	switch cmd_name {
 	case "", "help":
 		msg = b.queueHelp(q, subcommand)
 	case "clear":
 		msg = b.queueClear(q, subcommand)
-	case "dequeue", "remove", "del", "delete":
-		msg = "Try `!dequeue` instead, this will be implemented later."
+	case "dequeue", "remove", "del":
+		msg = b.queueRemove(q, subcommand)
 	case "enqueue", "add":
-		msg = "Try `!enqueue` instead, this will be implemented later."
+		msg = b.queueAdd(q, subcommand)
+	case "move":
+		msg = b.queueMove(q, subcommand)
+	case "swap":
+		msg = b.queueSwap(q, subcommand)
 	case "id", "identify":
 		msg = b.queueIdentifyRole(q, subcommand)
 	case "list", "show":
@@ -185,6 +269,36 @@ func (b *bot) queue(cmd Command) (err error) {
 		msg = b.queueTake(q, subcommand)
 	case "role", "roles":
 		msg = b.queueRoles(q, subcommand)
+	case "notify":
+		msg = b.queueNotify(q, subcommand)
+	case "history", "log":
+		msg = b.queueHistory(q, subcommand)
+	case "open", "unlock":
+		msg = b.queueSetOpen(q, subcommand, true)
+	case "close", "lock":
+		msg = b.queueSetOpen(q, subcommand, false)
+	case "schedule":
+		msg = b.queueSchedule(q, subcommand)
+	case "mode":
+		msg = b.queueMode(q, subcommand)
+	case "idtype", "platform":
+		msg = b.queueIdType(q, subcommand)
+	case "capacity", "limit":
+		msg = b.queueCapacity(q, subcommand)
+	case "skip":
+		msg = b.queueSkip(q, subcommand)
+	case "broadcast", "announce":
+		msg = b.queueBroadcast(q, subcommand)
+	case "pin", "status":
+		msg = b.queuePin(q, subcommand)
+	case "unpin":
+		msg = b.queueUnpin(q, subcommand)
+	case "voice":
+		msg = b.queueVoice(q, subcommand)
+	case "split":
+		msg = b.queueSplit(q, subcommand)
+	case "regroup", "return":
+		msg = b.queueRegroup(q, subcommand)
 	default:
 		msg = fmt.Sprintf("Unhandled scrimmages queue command: %q", cmd.Args())
 	}
@@ -192,11 +306,204 @@ func (b *bot) queue(cmd Command) (err error) {
 	return cmd.Reply(msg)
 }
 
-func (b *bot) queueHelp(q queue.Queue, cmd Command) string {
-	return "Manipulates the scrimmages queue.\n`!dequeue` -- remove yourself from the scrimmages queue\n`!enqueue MyBattleTag#1234` -- add your BattleTag to the scrimmages queue\n`!queue clear` -- clear the scrimmages queue\n`!queue list` -- list the BattleTags of the scrimmages queue\n`!queue pick <n>` -- removes the first `n` BattleTags from the scrimmages queue\n`!queue identify` -- display the roles that your nickname matches (ie DPS, support, or tank)"
+func (b *bot) queueHelp(q *scrimQueue, cmd Command) string {
+	return "Manipulates the scrimmages queue. Commands accept an optional queue name, eg `!enqueue ranked MyBattleTag#1234`; without one, the queue bound to the channel (or the default queue) is used.\n`!dequeue [name]` -- remove yourself from the scrimmages queue\n`!enqueue [name] [MyBattleTag#1234]` -- add your BattleTag to the scrimmages queue; without one, the BattleTag you registered with `!btag set`, or the one in your nickname, is used\n`!enqueue [name] MyBattleTag#1234 with @friend1 @friend2` -- queue as a party, taken all together or not at all (see `!party help`)\n`!queue clear [name]` -- clear the scrimmages queue\n`!queue list [name]` -- list the BattleTags of the scrimmages queue\n`!queue pick [name] <n> [lobby details]` -- removes the first `n` BattleTags from the scrimmages queue, and sends them the lobby details (once they confirm with `!ready`, when ready checks are enabled); when lobby channels are enabled, also opens a private channel for them that expires after a while\n`!queue pick [name] teams <n> [lobby details]` -- as above, then splits them into two teams balanced by skill rating (see `!sr help`)\n`!queue voice [name] [lobby|team1|team2] [channel|off]` -- display or set the voice channels of the scrimmages queue's teams, and of the lobby they return to\n`!queue split [name]` -- move the players of the teams last picked from the scrimmages queue into their teams' voice channels, creating temporary ones where none are set\n`!queue regroup [name]` -- move everyone in the teams' voice channels back to the lobby voice channel\n`!queue notify [name] <n|off>` -- get a DM upon reaching the top `n` of the scrimmages queue\n`!queue broadcast [name] <message>` -- send a DM to everyone in the scrimmages queue\n`!queue history [name] [n]` -- display the last `n` changes to the scrimmages queue\n`!queue mode [name] [fifo|priority]` -- display or set the ordering of the scrimmages queue; in priority mode, users who were skipped move ahead\n`!queue skip [name] <@user|BattleTag>` -- record that a user was passed over\n`!queue idtype [name] [type]` -- display or set the type of game id the scrimmages queue requires, one of battletag (the default), riot, steam, psn or xbox\n`!queue capacity [name] [n|off]` -- display or set the maximum size of the scrimmages queue; once full, users are waitlisted and promoted as spots open up\n`!queue open [name]` / `!queue close [name]` -- open or close the scrimmages queue to new entries, until its next scheduled opening or closing\n`!queue schedule [name]` -- display when the scrimmages queue is open\n`!queue schedule [name] add <days> <HH:MM-HH:MM>` -- add weekly open hours, eg `!queue schedule add weekdays 19:00-23:00`\n`!queue schedule [name] timezone <zone>` -- set the timezone of the open hours, eg `America/Denver`\n`!queue schedule [name] clear` -- remove the open hours\n`!queue identify` -- display your roles (ie DPS, support, or tank), and whether they were declared (see `!roles help`) or matched by your nickname\n`!queue add @user [MyBattleTag#1234] [position]` -- add a user to the scrimmages queue, by default with the BattleTag they registered (see `!btag help`)\n`!queue remove <@user|BattleTag>` -- remove a user from the scrimmages queue\n`!queue move <@user|BattleTag> <position>` -- move a user to a position in the scrimmages queue\n`!queue swap <@user|BattleTag> <@user|BattleTag>` -- swap the positions of two users\n`!queue create <name>` -- create a named queue\n`!queue delete <name>` -- delete a named queue\n`!queue bind <name>` -- make this channel use the named queue by default\n`!queue unbind` -- make this channel use the default queue\n`!queue names` -- list the named queues\n`!queue pin [name]` -- pin a message in this channel showing the scrimmages queue, kept up to date as it changes; react to it with ✅ to join, ❌ to leave, and 🛡, 💉 or ⚔ to declare your roles, when enabled\n`!queue unpin [name]` -- stop updating, and unpin, the scrimmages queue's message in this channel\n`!queue dashboard` -- link to a live web view of the queues\n`!queue token [revoke]` -- DM yourself a new token for the queue HTTP API, or revoke it (server managers only)\n`!queue migrate` -- rewrite the stored queue entries in the current format (server managers only)\n`!queue migrate legacy` -- move the queue once shared by every server into this server's default queue (server managers only)"
+}
+
+func (b *bot) queueCreate(cmd Command) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	name := strings.ToLower(cmd.Args())
+	if name == "" {
+		return "No queue name specified. Try `!queue create ranked`."
+	}
+
+	guild_id, err := cmd.Session().GuildIdFromChannelId(
+		cmd.Message().ChannelID)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error looking up guild: %s", err)
+	}
+
+	err = b.queue_registry.Create(guild_id, name)
+	if err != nil {
+		switch {
+		case QueueExistsError.Contains(err):
+			return fmt.Sprintf("The %s queue already exists.", name)
+		case InvalidQueueNameError.Contains(err):
+			if queueNameRe.MatchString(name) {
+				return fmt.Sprintf("Queue name %q is reserved, as it "+
+					"would be mistaken for a command or keyword.", name)
+			}
+			return fmt.Sprintf("Queue name %q is invalid. Names must "+
+				"start with a letter, and contain at most 32 letters, "+
+				"digits, dashes or underscores.", name)
+		}
+		logger.Errore(err)
+		return fmt.Sprintf("Error creating the %s queue: %s", name, err)
+	}
+	b.watchQueue(b.lookupNamedQueue(guild_id, name))
+
+	return fmt.Sprintf("Created the %s queue.", name)
+}
+
+func (b *bot) queueDelete(cmd Command) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	name := strings.ToLower(cmd.Args())
+	if name == "" {
+		return "No queue name specified. Try `!queue delete ranked`."
+	}
+
+	guild_id, err := cmd.Session().GuildIdFromChannelId(
+		cmd.Message().ChannelID)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error looking up guild: %s", err)
+	}
+
+	err = b.queue_registry.Delete(guild_id, name)
+	if err != nil {
+		if QueueNotFoundError.Contains(err) {
+			return fmt.Sprintf("There is no %s queue.", name)
+		}
+		logger.Errore(err)
+		return fmt.Sprintf("Error deleting the %s queue: %s", name, err)
+	}
+
+	b.forgetPriorityQueue(queueKey(guild_id, name))
+	b.forgetWaitlistQueue(queueKey(guild_id, name))
+	b.unwatchQueue(queueKey(guild_id, name))
+	err = b.queues.Delete(waitlistKey(queueKey(guild_id, name)))
+	if err != nil {
+		logger.Errore(err)
+	}
+	deleted := &scrimQueue{guild_id: guild_id, name: name}
+	err = b.store.Set(b.scheduleStoreKey(deleted), "")
+	if err != nil {
+		logger.Errore(err)
+	}
+	b.forgetStatus(cmd.Session(), deleted)
+	err = b.forgetNotifyPrefs(deleted)
+	if err != nil {
+		logger.Errore(err)
+	}
+	err = b.store.Set(b.voiceStoreKey(deleted), "")
+	if err != nil {
+		logger.Errore(err)
+	}
+	err = b.store.Set(b.teamsStoreKey(deleted), "")
+	if err != nil {
+		logger.Errore(err)
+	}
+	err = b.queues.Delete(queueKey(guild_id, name))
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error deleting the %s queue: %s", name, err)
+	}
+
+	return fmt.Sprintf("Deleted the %s queue.", name)
+}
+
+func (b *bot) queueBind(cmd Command) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	name := strings.ToLower(cmd.Args())
+	if name == "" {
+		return "No queue name specified. Try `!queue bind ranked`."
+	}
+
+	guild_id, err := cmd.Session().GuildIdFromChannelId(
+		cmd.Message().ChannelID)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error looking up guild: %s", err)
+	}
+
+	err = b.queue_registry.Bind(guild_id, cmd.Message().ChannelID, name)
+	if err != nil {
+		if QueueNotFoundError.Contains(err) {
+			return fmt.Sprintf("There is no %s queue.", name)
+		}
+		logger.Errore(err)
+		return fmt.Sprintf("Error binding the %s queue: %s", name, err)
+	}
+
+	return fmt.Sprintf("This channel now uses the %s queue.", name)
+}
+
+func (b *bot) queueUnbind(cmd Command) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	guild_id, err := cmd.Session().GuildIdFromChannelId(
+		cmd.Message().ChannelID)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error looking up guild: %s", err)
+	}
+
+	err = b.queue_registry.Unbind(guild_id, cmd.Message().ChannelID)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error unbinding this channel: %s", err)
+	}
+
+	return "This channel now uses the scrimmages queue."
+}
+
+func (b *bot) queueNames(cmd Command) string {
+	guild_id, err := cmd.Session().GuildIdFromChannelId(
+		cmd.Message().ChannelID)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error looking up guild: %s", err)
+	}
+
+	names, err := b.queue_registry.Names(guild_id)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error listing queues: %s", err)
+	}
+	if len(names) == 0 {
+		return "There are no named queues."
+	}
+
+	return fmt.Sprintf("Named queues: %s.", strings.Join(names, ", "))
 }
 
-func (b *bot) queueClear(q queue.Queue, cmd Command) string {
+func (b *bot) queueClear(q *scrimQueue, cmd Command) string {
 	ok, err := userAuthorized(cmd)
 	if err != nil {
 		logger.Errore(err)
@@ -207,40 +514,67 @@ func (b *bot) queueClear(q queue.Queue, cmd Command) string {
 		return "Permission denied."
 	}
 
+	size := q.Size()
 	if err := q.Clear(); err != nil {
-		return fmt.Sprintf("Error clearing the scrimmages queue: %s", err)
+		return fmt.Sprintf("Error clearing the %s: %s", q.Title(), err)
 	}
 	if err = b.clearUserLastEnqueued(); err != nil {
 		logger.Errore(err)
 	}
+	b.recordQueueEvent(q, "clear", cmd.Author(), nil, 0,
+		fmt.Sprintf("(%d entries)", size))
+	b.queueChanged(cmd.Session(), q)
 
-	return "Scrimmages queue cleared."
+	return fmt.Sprintf("%s cleared.", util.Capitalize(q.Title()))
 }
 
-func (b *bot) queueList(q queue.Queue, cmd Command) string {
+func (b *bot) queueList(q *scrimQueue, cmd Command) string {
 	users, err := q.List()
 	if err != nil {
 		logger.Errore(err)
-		return fmt.Sprintf("Error listing the scrimmages queue: %s", err)
+		return fmt.Sprintf("Error listing the %s: %s", q.Title(), err)
 	}
 
+	msg := ""
 	if len(users) > 0 {
-		names := []string{}
-		for _, u := range users {
-			btag, err := u.(Author).BattleTag()
-			if err != nil {
-				btag = u.(Author).Nick()
-			}
-			names = append(names, btag)
-		}
-		return fmt.Sprintf("The scrimmages queue contains %d BattleTags: %s.",
-			len(names), strings.Join(names, ", "))
+		msg = fmt.Sprintf("The %s contains %d BattleTags: %s.",
+			q.Title(), len(users), strings.Join(battleTags(users), ", "))
 	} else {
-		return "The scrimmages queue is empty."
+		msg = fmt.Sprintf("The %s is empty.", q.Title())
+	}
+	if q.waitlist == nil {
+		return msg
+	}
+
+	waiting, err := q.waitlist.Waitlist().List()
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error listing the waitlist of the %s: %s",
+			q.Title(), err)
+	}
+	msg += fmt.Sprintf(" Capacity: %d.", q.waitlist.Capacity())
+	if len(waiting) > 0 {
+		msg += fmt.Sprintf(" Waitlisted (%d): %s.", len(waiting),
+			strings.Join(battleTags(waiting), ", "))
+	}
+
+	return msg
+}
+
+func battleTags(queueables []queue.Queueable) []string {
+	btags := []string{}
+	for _, queueable := range queueables {
+		btag, err := queueable.(Author).BattleTag()
+		if err != nil {
+			btag = queueable.(Author).Nick()
+		}
+		btags = append(btags, btag)
 	}
+
+	return btags
 }
 
-func (b *bot) queueTake(q queue.Queue, cmd Command) string {
+func (b *bot) queueTake(q *scrimQueue, cmd Command) string {
 	ok, err := userAuthorized(cmd)
 	if err != nil {
 		logger.Errore(err)
@@ -252,70 +586,404 @@ func (b *bot) queueTake(q queue.Queue, cmd Command) string {
 	}
 
 	num := int64(defaultNumTaken)
-	args := strings.Split(cmd.Args(), " ")
+	details := ""
+	teams := false
+	args := strings.SplitN(cmd.Args(), " ", 2)
+	if args[0] == "teams" {
+		teams = true
+		args = strings.SplitN(strings.TrimSpace(cmd.Args()[len("teams"):]),
+			" ", 2)
+	}
 	if len(args) > 0 && args[0] != "" {
 		num, err = strconv.ParseInt(args[0], 10, 32)
 		if err != nil {
 			return fmt.Sprintf("Invalid take argument %q.", args[0])
 		}
 	}
-	taken, err := q.Dequeue(int(num))
+	if len(args) > 1 {
+		details = strings.TrimSpace(args[1])
+	}
+	if teams && (num%2 != 0 || num > maxTeamPlayers) {
+		return fmt.Sprintf("Teams require an even number of players, at "+
+			"most %d.", maxTeamPlayers)
+	}
+	ready_check := b.ready_check_window > 0
+	if ready_check && b.readyCheckActive(q) {
+		return fmt.Sprintf("A ready check for the %s is already in "+
+			"progress.", q.Title())
+	}
+	taken, err := b.takeEntries(q, int(num))
 	if err != nil {
 		logger.Errore(err)
-		return fmt.Sprintf("Error taking %d members from the "+
-			"scrimmages queues: %s", num, err)
+		return fmt.Sprintf("Error taking %d members from the %s: %s",
+			num, q.Title(), err)
 	}
-
-	btags := []string{}
+	for idx, queueable := range taken {
+		b.recordQueueEvent(q, "take", cmd.Author(), queueable.(Author),
+			idx+1, "")
+	}
+	if err = b.resetSkips(q, taken); err != nil {
+		logger.Errore(err)
+	}
+	authors := []Author{}
 	for _, queueable := range taken {
-		a := queueable.(Author)
-		btag, err := a.BattleTag()
-		if err != nil {
-			btag = a.Nick()
-		}
-		btags = append(btags, btag)
+		authors = append(authors, queueable.(Author))
+	}
+	sent := 0
+	if ready_check && len(taken) > 0 {
+		sent = b.startReadyCheck(q, cmd, taken, details, teams)
+	} else {
+		ready_check = false
+		sent = b.notifyTaken(cmd.Session(), q, taken, details)
 	}
-	msg := fmt.Sprintf("Took %d BattleTags from the scrimmages queue", len(taken))
+	b.queueChanged(cmd.Session(), q)
+
+	btags := battleTags(taken)
+	msg := fmt.Sprintf("Took %d BattleTags from the %s", len(taken),
+		q.Title())
 	if len(taken) > 0 {
 		msg += fmt.Sprintf(": %s.", strings.Join(btags, ", "))
 	} else {
 		msg += "."
 	}
 	msg += fmt.Sprintf(" %d BattleTags remain in the queue.", q.Size())
+	if sent < len(taken) {
+		msg += fmt.Sprintf(" Failed to send a DM to %d of them.",
+			len(taken)-sent)
+	}
+	if ready_check {
+		return msg + fmt.Sprintf(" Waiting up to %s for them to confirm "+
+			"they're ready.", b.ready_check_window)
+	}
+	roster := ""
+	if teams && len(taken) > 0 {
+		roster = b.teamsReport(q, authors)
+	}
+	msg += b.openLobby(cmd.Session(), q, cmd.Author(), authors, details,
+		roster)
+	if roster != "" {
+		msg += "\n" + roster
+	}
 
 	return msg
 }
 
-func (b *bot) queueIdentifyRole(q queue.Queue, cmd Command) string {
-	nick := cmd.Author().Nick()
-	roles := extractRoles(nick)
-	dps := symbolSaltire
-	if roles.DPS {
-		dps = symbolChecked
+func (b *bot) queueAdd(q *scrimQueue, cmd Command) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
 	}
-	support := symbolSaltire
-	if roles.Support {
-		support = symbolChecked
+	if !ok {
+		return "Permission denied."
+	}
+
+	args := strings.Fields(cmd.Args())
+	if len(args) < 1 {
+		return "Try `!queue add @user example#1234 [position]`."
 	}
-	tank := symbolSaltire
-	if roles.Tank {
-		tank = symbolChecked
+	user := mentionedUser(cmd.Message(), args[0])
+	if user == nil {
+		return fmt.Sprintf("%q doesn't mention a user.", args[0])
 	}
-	msg := fmt.Sprintf("Roles matched by %q: DPS: %s, Support: %s, Tank: %s",
-		nick, dps, support, tank)
+	args = args[1:]
 
-	return msg
+	// the id may be omitted if the user registered a BattleTag. Ids of
+	// some types contain spaces, in which case a trailing number is taken
+	// to be the position.
+	t := q.gameIdType()
+	pos_arg := ""
+	if n := len(args); t.Spaces && n > 0 {
+		if _, err := strconv.Atoi(args[n-1]); err == nil &&
+			(n > 1 || !t.Valid(args[0])) {
+			pos_arg = args[n-1]
+			args = args[:n-1]
+		}
+	} else if n > 1 {
+		pos_arg = args[1]
+		args = args[:1]
+	} else if n == 1 {
+		if _, err := strconv.Atoi(args[0]); err == nil && !t.Valid(args[0]) {
+			pos_arg = args[0]
+			args = nil
+		}
+	}
+	btag := strings.Join(args, " ")
+	if btag == "" && t == util.BattleTagType {
+		btag, err = b.registeredBattleTag(user.ID)
+		if err != nil {
+			logger.Errore(err)
+			return fmt.Sprintf("Error loading BattleTag: %s", err)
+		}
+		if btag == "" {
+			return fmt.Sprintf("<@!%s> hasn't registered a BattleTag. "+
+				"Try `!queue add @user example#1234 [position]`.",
+				user.ID)
+		}
+	}
+	if btag == "" {
+		return fmt.Sprintf("Try `!queue add @user %s [position]`.",
+			t.Example)
+	}
+	if !t.Valid(btag) {
+		return fmt.Sprintf("%s %q appears to be invalid.", t.Description,
+			btag)
+	}
+
+	a := newAuthor(user, cmd.Session(), cmd.Message().ChannelID)
+	a.SetBattleTag(btag)
+	b.prepareQueued(q, a)
+	if pos_arg != "" {
+		pos, err := strconv.Atoi(pos_arg)
+		if err != nil || pos < 1 {
+			return fmt.Sprintf("Invalid position %q.", pos_arg)
+		}
+		err = q.Insert(pos, a)
+	} else {
+		err = q.Enqueue(a)
+	}
+	if err != nil {
+		if queue.AlreadyQueuedError.Contains(err) {
+			return fmt.Sprintf("User %s is already queued in position %d.",
+				a.Mention(), q.Position(a.Key()))
+		}
+		logger.Errore(err)
+		return fmt.Sprintf("Error adding %s to the %s: %s", btag, q.Title(),
+			err)
+	}
+	if pos := q.waitlistPosition(a.Key()); pos > -1 {
+		b.recordQueueEvent(q, "waitlist", cmd.Author(), a, pos, "")
+		b.queueChanged(cmd.Session(), q)
+		return fmt.Sprintf("The %s is full, so %s was added to its "+
+			"waitlist in position %d.", q.Title(), btag, pos)
+	}
+	b.recordQueueEvent(q, "add", cmd.Author(), a, q.Position(a.Key()), "")
+	b.queueChanged(cmd.Session(), q)
+
+	return fmt.Sprintf("Added %s to the %s in position %d.", btag,
+		q.Title(), q.Position(a.Key()))
+}
+
+func (b *bot) queueRemove(q *scrimQueue, cmd Command) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	args := strings.Fields(cmd.Args())
+	if len(args) < 1 {
+		return "Try `!queue remove @user` or `!queue remove example#1234`."
+	}
+
+	a, err := findQueued(q, cmd, args[0])
+	if err != nil {
+		if queue.NotFoundError.Contains(err) {
+			return fmt.Sprintf("%s wasn't found in the %s.", args[0],
+				q.Title())
+		}
+		logger.Errore(err)
+		return fmt.Sprintf("Error removing %s from the %s: %s", args[0],
+			q.Title(), err)
+	}
+
+	pos := q.Position(a.Key())
+	_, err = q.Remove(a.Key())
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error removing %s from the %s: %s", args[0],
+			q.Title(), err)
+	}
+	b.recordQueueEvent(q, "remove", cmd.Author(), a, pos, "")
+	party_msg := b.leftParty(cmd.Session(), q, a)
+	b.queueChanged(cmd.Session(), q)
+
+	return fmt.Sprintf("Removed %s from the %s.%s", displayName(a),
+		q.Title(), party_msg)
+}
+
+func (b *bot) queueMove(q *scrimQueue, cmd Command) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	args := strings.Fields(cmd.Args())
+	if len(args) < 2 {
+		return "Try `!queue move @user 1` or `!queue move example#1234 1`."
+	}
+	pos, err := strconv.Atoi(args[1])
+	if err != nil || pos < 1 {
+		return fmt.Sprintf("Invalid position %q.", args[1])
+	}
+
+	from := -1
+	a, err := findQueued(q, cmd, args[0])
+	if err == nil {
+		from = q.Position(a.Key())
+		err = q.Move(a.Key(), pos)
+	}
+	if err != nil {
+		if queue.NotFoundError.Contains(err) {
+			return fmt.Sprintf("%s wasn't found in the %s.", args[0],
+				q.Title())
+		}
+		logger.Errore(err)
+		return fmt.Sprintf("Error moving %s in the %s: %s", args[0],
+			q.Title(), err)
+	}
+	b.recordQueueEvent(q, "move", cmd.Author(), a, q.Position(a.Key()),
+		fmt.Sprintf("from position %d", from))
+	b.queueChanged(cmd.Session(), q)
+
+	return fmt.Sprintf("Moved %s to position %d in the %s.", displayName(a),
+		q.Position(a.Key()), q.Title())
 }
 
-func (b *bot) queueRoles(q queue.Queue, cmd Command) string {
+func (b *bot) queueSwap(q *scrimQueue, cmd Command) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	args := strings.Fields(cmd.Args())
+	if len(args) < 2 {
+		return "Try `!queue swap @user1 @user2` or " +
+			"`!queue swap example#1234 example#2345`."
+	}
+
+	authors := []Author{}
+	for _, arg := range args[:2] {
+		a, err := findQueued(q, cmd, arg)
+		if err != nil {
+			if queue.NotFoundError.Contains(err) {
+				return fmt.Sprintf("%s wasn't found in the %s.", arg,
+					q.Title())
+			}
+			logger.Errore(err)
+			return fmt.Sprintf("Error swapping users in the %s: %s",
+				q.Title(), err)
+		}
+		authors = append(authors, a)
+	}
+
+	err = q.Swap(authors[0].Key(), authors[1].Key())
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error swapping users in the %s: %s",
+			q.Title(), err)
+	}
+	b.recordQueueEvent(q, "swap", cmd.Author(), authors[0],
+		q.Position(authors[0].Key()),
+		fmt.Sprintf("with %s", displayName(authors[1])))
+	b.queueChanged(cmd.Session(), q)
+
+	return fmt.Sprintf("Swapped %s (now position %d) and %s (now position "+
+		"%d) in the %s.",
+		displayName(authors[0]), q.Position(authors[0].Key()),
+		displayName(authors[1]), q.Position(authors[1].Key()), q.Title())
+}
+
+// queueIdentifyRole reports the author's roles, and where each came from.
+func (b *bot) queueIdentifyRole(q *scrimQueue, cmd Command) string {
+	nick := cmd.Author().Nick()
+	names, source := b.authorRoleSource(q, cmd.Author())
+	has := make(map[string]bool)
+	for _, name := range names {
+		has[name] = true
+	}
+	describe := func(role string) string {
+		if !has[role] {
+			return symbolSaltire
+		}
+		return fmt.Sprintf("%s (%s)", symbolChecked,
+			describeRoleSource(source))
+	}
+
+	return fmt.Sprintf("Roles of %q: DPS: %s, Support: %s, Tank: %s", nick,
+		describe(roleDPS), describe(roleSupport), describe(roleTank))
+}
+
+func (b *bot) queueRoles(q *scrimQueue, cmd Command) string {
 	tanks := "Tanks:"
 	supports := "Supports:"
 	dps := "DPSes:"
 
-	return "Roles queued in the scrimmages queue:\n" +
+	return fmt.Sprintf("Roles queued in the %s:\n", q.Title()) +
 		strings.Join([]string{tanks, supports, dps}, "\n")
 }
 
+// mentionedUser returns the user mentioned by arg, which is expected to be
+// one of msg's mentions. Returns nil if arg isn't a user mention.
+func mentionedUser(msg *discordgo.Message, arg string) *discordgo.User {
+	match := mentionRe.FindStringSubmatch(arg)
+	if match == nil {
+		return nil
+	}
+
+	for _, user := range msg.Mentions {
+		if user.ID == match[1] {
+			return user
+		}
+	}
+
+	return &discordgo.User{ID: match[1]}
+}
+
+// findQueued returns the queued Author identified by arg, which is either a
+// user mention or a BattleTag.
+func findQueued(q *scrimQueue, cmd Command, arg string) (Author, error) {
+	key := ""
+	if user := mentionedUser(cmd.Message(), arg); user != nil {
+		key = newAuthor(user, cmd.Session(), cmd.Message().ChannelID).Key()
+	}
+
+	queueables, err := q.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, queueable := range queueables {
+		a := queueable.(Author)
+		if key != "" {
+			if a.Key() == key {
+				return a, nil
+			}
+			continue
+		}
+		btag, err := a.BattleTag()
+		if err == nil && strings.EqualFold(btag, arg) {
+			return a, nil
+		}
+	}
+
+	return nil, queue.NotFoundError.New("%s", arg)
+}
+
+func displayName(a Author) string {
+	btag, err := a.BattleTag()
+	if err != nil || btag == "" {
+		return a.Nick()
+	}
+
+	return btag
+}
+
 func userAuthorized(cmd Command) (ok bool, err error) {
 	return cmd.Author().PermittedTo(discordgo.PermissionKickMembers)
 }
@@ -338,7 +1006,64 @@ func (b *bot) clearUserLastEnqueued() (err error) {
 	return nil
 }
 
-func (b *bot) lookupQueue(channel_id string, session Session) (queue.Queue,
+// scrimQueue is one of a guild's queues, along with the name it's known by.
+// The default queue's name is empty.
+type scrimQueue struct {
+	queue.Queue
+	guild_id string
+	name     string
+	prio     queue.PriorityQueue
+	waitlist queue.WaitlistQueue
+	id_type  *util.GameIdType
+}
+
+func (q *scrimQueue) Key() string {
+	return queueKey(q.guild_id, q.name)
+}
+
+func (q *scrimQueue) Title() string {
+	if q.name == "" {
+		return "scrimmages queue"
+	}
+
+	return fmt.Sprintf("%s queue", q.name)
+}
+
+func (q *scrimQueue) rateLimitKey(a Author) string {
+	if q.name == "" {
+		return a.Key()
+	}
+
+	return fmt.Sprintf("%s-%s", a.Key(), q.name)
+}
+
+// waitlistKey is the key of the queue's waitlist. It can't collide with a
+// named queue, as names can't contain "#".
+func waitlistKey(key string) string {
+	return fmt.Sprintf("%s#waitlist", key)
+}
+
+// waitlistPosition returns the (1-indexed) position of the key in the
+// queue's waitlist, or -1 if it isn't waitlisted.
+func (q *scrimQueue) waitlistPosition(key string) int {
+	if q.waitlist == nil {
+		return -1
+	}
+
+	return q.waitlist.Waitlist().Position(key)
+}
+
+func queueKey(guild_id, name string) string {
+	if name == "" {
+		return guild_id
+	}
+
+	return fmt.Sprintf("%s.%s", guild_id, name)
+}
+
+// lookupQueue returns the queue bound to the channel, or the guild's default
+// queue if the channel isn't bound.
+func (b *bot) lookupQueue(channel_id string, session Session) (*scrimQueue,
 	error) {
 
 	guild_id, err := session.GuildIdFromChannelId(channel_id)
@@ -346,5 +1071,118 @@ func (b *bot) lookupQueue(channel_id string, session Session) (queue.Queue,
 		return nil, err
 	}
 
-	return b.queues.Lookup(guild_id), nil
+	name, err := b.queue_registry.Bound(guild_id, channel_id)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.lookupNamedQueue(guild_id, name), nil
+}
+
+func (b *bot) lookupNamedQueue(guild_id, name string) *scrimQueue {
+	key := queueKey(guild_id, name)
+	sq := &scrimQueue{
+		Queue:    b.sessionLookup(key),
+		guild_id: guild_id,
+		name:     name,
+	}
+	if b.queue_registry.Priority(guild_id, name) {
+		sq.prio = b.priorityQueue(key, sq.Queue)
+		sq.Queue = sq.prio
+	}
+	if id_type := b.queue_registry.GameIdType(guild_id, name); id_type != "" {
+		sq.id_type = util.LookupGameIdType(id_type)
+		if sq.id_type == nil {
+			logger.Warnf("queue %s requires unknown game id type %q",
+				key, id_type)
+		}
+	}
+	if capacity := b.queue_registry.Capacity(guild_id, name); capacity > 0 {
+		sq.waitlist = b.waitlistQueue(key, sq.Queue, capacity)
+		sq.Queue = sq.waitlist
+	}
+
+	return sq
+}
+
+// peekNamedQueue returns the guild's named queue as lookupNamedQueue does,
+// but only reads what's stored: queues whose keys aren't among stored are
+// read as empty, and nothing is created, migrated or watched.
+func (b *bot) peekNamedQueue(guild_id, name string,
+	stored map[string]bool) *scrimQueue {
+
+	key := queueKey(guild_id, name)
+	sq := &scrimQueue{
+		Queue:    b.storedQueue(key, stored),
+		guild_id: guild_id,
+		name:     name,
+	}
+	if b.queue_registry.Priority(guild_id, name) {
+		sq.prio = queue.NewPriority(sq.Queue, *queue.SkipCredit)
+		sq.Queue = sq.prio
+	}
+	if capacity := b.queue_registry.Capacity(guild_id, name); capacity > 0 {
+		sq.waitlist = queue.NewWaitlist(sq.Queue,
+			b.storedQueue(waitlistKey(key), stored), capacity)
+		sq.Queue = sq.waitlist
+	}
+
+	return sq
+}
+
+func (b *bot) storedQueue(key string, stored map[string]bool) queue.Queue {
+	if !stored[key] {
+		return queue.New()
+	}
+
+	return b.sessionLookup(key)
+}
+
+// storedQueueKeys returns the keys of the queues the manager holds.
+func (b *bot) storedQueueKeys() (map[string]bool, error) {
+	lister, ok := b.queues.(queue.Lister)
+	if !ok {
+		return nil, DiscordError.New("queues of %T can't be listed",
+			b.queues)
+	}
+	keys, err := lister.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		stored[key] = true
+	}
+
+	return stored, nil
+}
+
+// selectQueue returns the queue named by the first word of args, along with
+// the remaining args. When args doesn't start with the name of one of the
+// guild's queues, the channel's queue is returned with args untouched.
+func (b *bot) selectQueue(channel_id string, session Session, args string) (
+	q *scrimQueue, rest string, err error) {
+
+	pieces := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	name := strings.ToLower(pieces[0])
+	if name != "" && validQueueName(name) {
+		guild_id, err := session.GuildIdFromChannelId(channel_id)
+		if err != nil {
+			return nil, "", err
+		}
+		if b.queue_registry.Exists(guild_id, name) {
+			if len(pieces) > 1 {
+				rest = strings.TrimSpace(pieces[1])
+			}
+			return b.lookupNamedQueue(guild_id, name), rest, nil
+		}
+	}
+
+	q, err = b.lookupQueue(channel_id, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return q, args, nil
 }
@@ -0,0 +1,389 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spacemonkeygo/errors"
+	"xmtp.net/xmtpbot/store"
+	"xmtp.net/xmtpbot/util"
+)
+
+const (
+	bucketQueues = "discord.queues"
+)
+
+var (
+	queueNameRe = regexp.MustCompile("^[a-z][a-z0-9_-]{0,31}$")
+
+	QueueExistsError = DiscordError.NewClass("queue already exists",
+		errors.NoCaptureStack())
+	QueueNotFoundError = DiscordError.NewClass("queue not found",
+		errors.NoCaptureStack())
+	InvalidQueueNameError = DiscordError.NewClass("invalid queue name",
+		errors.NoCaptureStack())
+
+	// reservedQueueNames are the subcommands, and the keywords of their
+	// arguments, which may be found where a queue name may. Queues so named
+	// would take them over, as would those named for game id types.
+	reservedQueueNames = reservedNames(
+		// !queue
+		"add", "announce", "bind", "broadcast", "capacity", "clear",
+		"close", "create", "dashboard", "del", "delete", "dequeue",
+		"destroy", "enqueue", "grab", "help", "history", "id",
+		"identify", "idtype", "limit", "list", "lock", "log", "migrate",
+		"mode", "move", "names", "new", "notify", "open", "pick", "pin",
+		"platform", "queues", "regroup", "remove", "return", "role",
+		"roles", "schedule", "show", "skip", "split", "status", "swap",
+		"take", "token", "unbind", "unlock", "unpin", "voice", "web",
+		// !party
+		"accept", "decline", "disband", "dissolve", "join",
+		// keywords
+		"fifo", "legacy", "lobby", "none", "off", "priority", "revoke",
+		"stop", "team1", "team2", "teams", "timezone", "tz", "with")
+)
+
+func reservedNames(names ...string) map[string]bool {
+	reserved := make(map[string]bool, len(names))
+	for _, name := range names {
+		reserved[name] = true
+	}
+
+	return reserved
+}
+
+// queueRegistry records the named queues of each guild, and which channels
+// are bound to them. Every guild also has an unnamed default queue, which is
+// never recorded here.
+type queueRegistry struct {
+	store store.Simple
+	mtx   sync.Mutex
+}
+
+type guildQueues struct {
+	Names    []string          `json:"names"`
+	Channels map[string]string `json:"channels"`
+	Priority map[string]bool   `json:"priority,omitempty"`
+	Capacity map[string]int    `json:"capacity,omitempty"`
+	GameIds  map[string]string `json:"game_ids,omitempty"`
+}
+
+func newQueueRegistry(store store.Simple) *queueRegistry {
+	return &queueRegistry{
+		store: store,
+	}
+}
+
+func validQueueName(name string) bool {
+	return queueNameRe.MatchString(name) && !reservedQueueNames[name] &&
+		util.LookupGameIdType(name) == nil
+}
+
+func (r *queueRegistry) Bind(guild_id, channel_id, name string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	gq, err := r.load(guild_id)
+	if err != nil {
+		return err
+	}
+	if !gq.contains(name) {
+		return QueueNotFoundError.New("%q", name)
+	}
+	gq.Channels[channel_id] = name
+
+	return r.save(guild_id, gq)
+}
+
+func (r *queueRegistry) Bound(guild_id, channel_id string) (string, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	gq, err := r.load(guild_id)
+	if err != nil {
+		return "", err
+	}
+
+	return gq.Channels[channel_id], nil
+}
+
+// Capacity returns the maximum number of active entries of the named queue,
+// 0 meaning unlimited.
+func (r *queueRegistry) Capacity(guild_id, name string) int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	gq, err := r.load(guild_id)
+	if err != nil {
+		logger.Errore(err)
+		return 0
+	}
+
+	return gq.Capacity[name]
+}
+
+func (r *queueRegistry) Create(guild_id, name string) error {
+	if !validQueueName(name) {
+		return InvalidQueueNameError.New("%q", name)
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	gq, err := r.load(guild_id)
+	if err != nil {
+		return err
+	}
+	if gq.contains(name) {
+		return QueueExistsError.New("%q", name)
+	}
+	gq.Names = append(gq.Names, name)
+	sort.Strings(gq.Names)
+
+	return r.save(guild_id, gq)
+}
+
+// Delete forgets the named queue, and unbinds any channels bound to it.
+func (r *queueRegistry) Delete(guild_id, name string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	gq, err := r.load(guild_id)
+	if err != nil {
+		return err
+	}
+	if !gq.contains(name) {
+		return QueueNotFoundError.New("%q", name)
+	}
+
+	names := []string{}
+	for _, candidate := range gq.Names {
+		if candidate != name {
+			names = append(names, candidate)
+		}
+	}
+	gq.Names = names
+	delete(gq.Priority, name)
+	delete(gq.Capacity, name)
+	delete(gq.GameIds, name)
+	for channel_id, bound := range gq.Channels {
+		if bound == name {
+			delete(gq.Channels, channel_id)
+		}
+	}
+
+	return r.save(guild_id, gq)
+}
+
+func (r *queueRegistry) Exists(guild_id, name string) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	gq, err := r.load(guild_id)
+	if err != nil {
+		logger.Errore(err)
+		return false
+	}
+
+	return gq.contains(name)
+}
+
+// GameIdType returns the name of the type of game id required by the named
+// queue, or "" if it requires the default, a BattleTag.
+func (r *queueRegistry) GameIdType(guild_id, name string) string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	gq, err := r.load(guild_id)
+	if err != nil {
+		logger.Errore(err)
+		return ""
+	}
+
+	return gq.GameIds[name]
+}
+
+func (r *queueRegistry) Names(guild_id string) ([]string, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	gq, err := r.load(guild_id)
+	if err != nil {
+		return nil, err
+	}
+
+	return gq.Names, nil
+}
+
+// Priority reports whether the named queue orders its entries by priority.
+func (r *queueRegistry) Priority(guild_id, name string) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	gq, err := r.load(guild_id)
+	if err != nil {
+		logger.Errore(err)
+		return false
+	}
+
+	return gq.Priority[name]
+}
+
+func (r *queueRegistry) SetCapacity(guild_id, name string,
+	capacity int) error {
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	gq, err := r.load(guild_id)
+	if err != nil {
+		return err
+	}
+	if name != "" && !gq.contains(name) {
+		return QueueNotFoundError.New("%q", name)
+	}
+	if capacity > 0 {
+		gq.Capacity[name] = capacity
+	} else {
+		delete(gq.Capacity, name)
+	}
+
+	return r.save(guild_id, gq)
+}
+
+func (r *queueRegistry) SetGameIdType(guild_id, name,
+	id_type string) error {
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	gq, err := r.load(guild_id)
+	if err != nil {
+		return err
+	}
+	if name != "" && !gq.contains(name) {
+		return QueueNotFoundError.New("%q", name)
+	}
+	if id_type != "" && id_type != util.BattleTagType.Name {
+		gq.GameIds[name] = id_type
+	} else {
+		delete(gq.GameIds, name)
+	}
+
+	return r.save(guild_id, gq)
+}
+
+func (r *queueRegistry) SetPriority(guild_id, name string,
+	enabled bool) error {
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	gq, err := r.load(guild_id)
+	if err != nil {
+		return err
+	}
+	if name != "" && !gq.contains(name) {
+		return QueueNotFoundError.New("%q", name)
+	}
+	if enabled {
+		gq.Priority[name] = true
+	} else {
+		delete(gq.Priority, name)
+	}
+
+	return r.save(guild_id, gq)
+}
+
+func (r *queueRegistry) Unbind(guild_id, channel_id string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	gq, err := r.load(guild_id)
+	if err != nil {
+		return err
+	}
+	delete(gq.Channels, channel_id)
+
+	return r.save(guild_id, gq)
+}
+
+// The caller is responsible for obtaining r.mtx before calling
+func (r *queueRegistry) load(guild_id string) (*guildQueues, error) {
+	gq := &guildQueues{
+		Names:    []string{},
+		Channels: make(map[string]string),
+		Priority: make(map[string]bool),
+		Capacity: make(map[string]int),
+		GameIds:  make(map[string]string),
+	}
+
+	value, err := r.store.Get(r.storeKey(guild_id))
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return gq, nil
+	}
+
+	err = json.Unmarshal([]byte(value), gq)
+	if err != nil {
+		return nil, err
+	}
+	if gq.Channels == nil {
+		gq.Channels = make(map[string]string)
+	}
+	if gq.Priority == nil {
+		gq.Priority = make(map[string]bool)
+	}
+	if gq.Capacity == nil {
+		gq.Capacity = make(map[string]int)
+	}
+	if gq.GameIds == nil {
+		gq.GameIds = make(map[string]string)
+	}
+
+	return gq, nil
+}
+
+// The caller is responsible for obtaining r.mtx before calling
+func (r *queueRegistry) save(guild_id string, gq *guildQueues) error {
+	bytes, err := json.Marshal(gq)
+	if err != nil {
+		return err
+	}
+
+	return r.store.Set(r.storeKey(guild_id), string(bytes))
+}
+
+func (r *queueRegistry) storeKey(guild_id string) string {
+	return fmt.Sprintf("%s.%s", bucketQueues, guild_id)
+}
+
+func (gq *guildQueues) contains(name string) bool {
+	for _, candidate := range gq.Names {
+		if strings.EqualFold(candidate, name) {
+			return true
+		}
+	}
+
+	return false
+}
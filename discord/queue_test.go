@@ -16,12 +16,15 @@ package discord
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/ewollesen/discordgo"
+	audit_mem "xmtp.net/xmtpbot/audit/memory"
 	"xmtp.net/xmtpbot/queue"
 	seen_mem "xmtp.net/xmtpbot/seen/memory"
+	"xmtp.net/xmtpbot/store"
 	"xmtp.net/xmtpbot/test"
 	url_mem "xmtp.net/xmtpbot/urls/memory"
 )
@@ -31,8 +34,11 @@ const (
 	testGuildId   = "765432"
 	testUserId    = "123456"
 	testUserId2   = "234567"
+	testUserId3   = "345678"
+	testModId     = "456789"
 	testBTag      = "example#1234"
 	testBTag2     = "example#2345"
+	testBTag3     = "example#3456"
 )
 
 func TestDequeue(t *testing.T) {
@@ -149,6 +155,499 @@ func TestQueueTake(t *testing.T) {
 		"the queue.")
 }
 
+func TestQueueTakeSubcommand(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+
+	session.allowAll()
+	q.Enqueue(newTestAuthor(testUserId, testBTag))
+	q.Enqueue(newTestAuthor(testUserId2, testBTag2))
+	test.AssertNil(bot.queue(newTestCommand("queue", "take 1", session, msg)))
+	test.AssertContainsString(session.replies, "Took 1 BattleTags from the "+
+		"scrimmages queue: "+testBTag+". 1 BattleTags remain in the queue.")
+}
+
+func TestQueueCreateAndDelete(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+
+	test.AssertEqual(bot.queueCreate(
+		newTestCommand("create", "ranked", session, msg)),
+		"Permission denied.")
+
+	session.allowAll()
+	test.AssertEqual(bot.queueCreate(
+		newTestCommand("create", "Ranked", session, msg)),
+		"Created the ranked queue.")
+	test.AssertEqual(bot.queueCreate(
+		newTestCommand("create", "ranked", session, msg)),
+		"The ranked queue already exists.")
+	test.AssertEqual(bot.queueCreate(
+		newTestCommand("create", "", session, msg)),
+		"No queue name specified. Try `!queue create ranked`.")
+	test.AssertEqual(bot.queueCreate(
+		newTestCommand("create", "foo#1234", session, msg)),
+		"Queue name \"foo#1234\" is invalid. Names must start with a "+
+			"letter, and contain at most 32 letters, digits, dashes or "+
+			"underscores.")
+	for _, name := range []string{"teams", "with", "accept", "show",
+		"steam"} {
+		test.AssertEqual(bot.queueCreate(
+			newTestCommand("create", name, session, msg)),
+			fmt.Sprintf("Queue name %q is reserved, as it would be "+
+				"mistaken for a command or keyword.", name))
+	}
+	test.AssertEqual(bot.queueNames(
+		newTestCommand("names", "", session, msg)),
+		"Named queues: ranked.")
+
+	test.AssertEqual(bot.queueDelete(
+		newTestCommand("delete", "unranked", session, msg)),
+		"There is no unranked queue.")
+	test.AssertEqual(bot.queueDelete(
+		newTestCommand("delete", "ranked", session, msg)),
+		"Deleted the ranked queue.")
+	test.AssertEqual(bot.queueNames(
+		newTestCommand("names", "", session, msg)),
+		"There are no named queues.")
+}
+
+func TestNamedQueueEnqueue(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+
+	session.allowAll()
+	bot.queueCreate(newTestCommand("create", "ranked", session, msg))
+
+	test.AssertNil(bot.enqueue(
+		newTestCommand("enqueue", "ranked "+testBTag, session, msg)))
+	test.AssertContainsString(session.replies,
+		"Successfully added "+testBTag+" to the ranked queue in position 1.")
+	test.AssertEqual(bot.lookupNamedQueue(testGuildId, "ranked").Size(), 1)
+
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	test.AssertEqual(q.Size(), 0)
+
+	test.AssertNil(bot.enqueue(
+		newTestCommand("enqueue", testBTag, session, msg)))
+	test.AssertContainsString(session.replies,
+		"Successfully added "+testBTag+" to the scrimmages queue in "+
+			"position 1.")
+
+	test.AssertNil(bot.dequeue(
+		newTestCommand("dequeue", "ranked", session, msg)))
+	test.AssertContainsString(session.replies,
+		"Successfully removed "+testBTag+" from the ranked queue.")
+	test.AssertEqual(bot.lookupNamedQueue(testGuildId, "ranked").Size(), 0)
+	test.AssertEqual(q.Size(), 1)
+}
+
+func TestQueueBind(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+
+	session.allowAll()
+	test.AssertEqual(bot.queueBind(
+		newTestCommand("bind", "ranked", session, msg)),
+		"There is no ranked queue.")
+	bot.queueCreate(newTestCommand("create", "ranked", session, msg))
+	test.AssertEqual(bot.queueBind(
+		newTestCommand("bind", "ranked", session, msg)),
+		"This channel now uses the ranked queue.")
+
+	test.AssertNil(bot.enqueue(
+		newTestCommand("enqueue", testBTag, session, msg)))
+	test.AssertContainsString(session.replies,
+		"Successfully added "+testBTag+" to the ranked queue in position 1.")
+
+	test.AssertEqual(bot.queueUnbind(
+		newTestCommand("unbind", "", session, msg)),
+		"This channel now uses the scrimmages queue.")
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	test.AssertEqual(q.Title(), "scrimmages queue")
+	test.AssertEqual(q.Size(), 0)
+
+	bot.queueBind(newTestCommand("bind", "ranked", session, msg))
+	ranked, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	bot.queuePin(ranked, newTestCommand("pin", "", session, msg))
+	bot.queueNotify(ranked, newTestCommand("notify", "5", session, msg))
+	test.AssertEqual(len(session.pinned), 1)
+	bot.queueDelete(newTestCommand("delete", "ranked", session, msg))
+	q, err = bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	test.AssertEqual(q.Title(), "scrimmages queue")
+
+	// the deleted queue's status is unpinned, and its settings forgotten
+	test.AssertEqual(len(session.pinned), 0)
+	edits := []string{}
+	for _, edit := range session.edits {
+		edits = append(edits, edit)
+	}
+	test.AssertContainsString(edits, "The ranked queue was deleted.")
+	prefs, err := bot.loadNotifyPrefs(ranked)
+	test.AssertNil(err)
+	test.AssertEqual(len(prefs), 0)
+}
+
+func TestQueueAdd(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	msg.Mentions = []*discordgo.User{{ID: testUserId2, Username: "baz"}}
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+
+	cmd := newTestCommand("add", "<@"+testUserId2+"> "+testBTag2, session, msg)
+	test.AssertEqual(bot.queueAdd(q, cmd), "Permission denied.")
+
+	session.allowAll()
+	q.Enqueue(newTestAuthor(testUserId, testBTag))
+	test.AssertEqual(bot.queueAdd(q, newTestCommand("add",
+		"<@!"+testUserId2+"> "+testBTag2+" 1", session, msg)),
+		"Added "+testBTag2+" to the scrimmages queue in position 1.")
+	test.AssertEqual(q.Position(testGuildId+"-"+testUserId2), 1)
+	test.AssertEqual(bot.queueAdd(q, cmd),
+		"User <@!"+testUserId2+"> is already queued in position 1.")
+	test.AssertEqual(bot.queueAdd(q, newTestCommand("add",
+		testBTag2+" "+testBTag2, session, msg)),
+		"\""+testBTag2+"\" doesn't mention a user.")
+}
+
+func TestQueueRemove(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	session.allowAll()
+
+	q.Enqueue(newTestAuthor(testUserId, testBTag))
+	q.Enqueue(newTestAuthor(testUserId2, testBTag2))
+	test.AssertEqual(bot.queueRemove(q, newTestCommand("remove",
+		"<@"+testUserId2+">", session, msg)),
+		"Removed "+testBTag2+" from the scrimmages queue.")
+	test.AssertEqual(bot.queueRemove(q, newTestCommand("remove",
+		"EXAMPLE#1234", session, msg)),
+		"Removed "+testBTag+" from the scrimmages queue.")
+	test.AssertEqual(bot.queueRemove(q, newTestCommand("remove",
+		testBTag, session, msg)),
+		testBTag+" wasn't found in the scrimmages queue.")
+	test.AssertEqual(q.Size(), 0)
+}
+
+func TestQueueMoveAndSwap(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	session.allowAll()
+
+	q.Enqueue(newTestAuthor(testUserId, testBTag))
+	q.Enqueue(newTestAuthor(testUserId2, testBTag2))
+	test.AssertEqual(bot.queueMove(q, newTestCommand("move",
+		testBTag2+" 1", session, msg)),
+		"Moved "+testBTag2+" to position 1 in the scrimmages queue.")
+	test.AssertEqual(bot.queueMove(q, newTestCommand("move",
+		testBTag2+" zero", session, msg)),
+		"Invalid position \"zero\".")
+
+	test.AssertEqual(bot.queueSwap(q, newTestCommand("swap",
+		"<@"+testUserId2+"> "+testBTag, session, msg)),
+		"Swapped "+testBTag2+" (now position 2) and "+testBTag+
+			" (now position 1) in the scrimmages queue.")
+	test.AssertEqual(bot.queueSwap(q, newTestCommand("swap",
+		testBTag+" nobody#1234", session, msg)),
+		"nobody#1234 wasn't found in the scrimmages queue.")
+}
+
+func TestQueuePriorityMode(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+
+	test.AssertEqual(bot.queueMode(q, newTestCommand("mode", "", session,
+		msg)), "The scrimmages queue is in fifo mode.")
+	test.AssertEqual(bot.queueMode(q, newTestCommand("mode", "priority",
+		session, msg)), "Permission denied.")
+	session.allowAll()
+	test.AssertEqual(bot.queueMode(q, newTestCommand("mode", "lifo",
+		session, msg)), "Invalid mode \"lifo\". Try `!queue mode fifo` "+
+		"or `!queue mode priority`.")
+	test.AssertEqual(bot.queueMode(q, newTestCommand("mode", "priority",
+		session, msg)), "The scrimmages queue is now in priority mode.")
+
+	q, err = bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	for _, a := range []Author{newTestAuthor(testUserId, testBTag),
+		newTestAuthor(testUserId2, testBTag2)} {
+		bot.prepareQueued(q, a)
+		test.AssertNil(q.Enqueue(a))
+	}
+	test.AssertEqual(bot.queueSkip(q, newTestCommand("skip", testBTag2,
+		session, msg)), "Recorded a skip for "+testBTag2+", now in "+
+		"position 1 of the scrimmages queue.")
+
+	bot.queueTake(q, newTestCommand("take", "1", session, msg))
+	q.Enqueue(newTestAuthor(testUserId2, testBTag2))
+	skips, err := bot.loadSkips(q)
+	test.AssertNil(err)
+	test.AssertEqual(skips[testUserId2], 0)
+
+	a := newTestAuthor(testUserId, testBTag)
+	test.AssertEqual(bot.queueSkip(q, newTestCommand("skip",
+		"<@"+testUserId+">", session, msg)), "Recorded a skip for "+
+		testBTag+", now in position 1 of the scrimmages queue.")
+	bot.prepareQueued(q, a)
+	test.AssertEqual(a.Skips(), 1)
+}
+
+func TestQueueTakeNotifiesTaken(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	session.allowAll()
+
+	q.Enqueue(newTestAuthor(testUserId, testBTag))
+	q.Enqueue(newTestAuthor(testUserId2, testBTag2))
+	bot.queueTake(q, newTestCommand("take", "1 join lobby foo, password bar",
+		session, msg))
+	test.AssertContainsString(session.dms[testUserId], "You were taken "+
+		"from the scrimmages queue! Lobby details: join lobby foo, "+
+		"password bar")
+	test.AssertEqual(len(session.dms[testUserId2]), 0)
+}
+
+func TestQueueTakeReadyCheck(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	bot.ready_check_window = time.Hour
+	bot.user_id = "bot"
+	mod_msg := newTestMessage(testModId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	session.allowAll()
+
+	q.Enqueue(newTestAuthor(testUserId, testBTag))
+	q.Enqueue(newTestAuthor(testUserId2, testBTag2))
+	q.Enqueue(newTestAuthor(testUserId3, testBTag3))
+	// messages are sent without holding the ready checks' lock
+	session.on_send = func() { bot.readyCheckActive(q) }
+	test.AssertEqual(bot.queueTake(q, newTestCommand("take", "2 lobby foo",
+		session, mod_msg)), "Took 2 BattleTags from the scrimmages "+
+		"queue: "+testBTag+", "+testBTag2+". 1 BattleTags remain in the "+
+		"queue. Waiting up to 1h0m0s for them to confirm they're ready.")
+	test.AssertEqual(bot.queueTake(q, newTestCommand("take", "2",
+		session, mod_msg)), "A ready check for the scrimmages queue is "+
+		"already in progress.")
+
+	ready_msg := newTestMessage(testUserId, testChannelId)
+	test.AssertNil(bot.ready(newTestCommand("ready", "", session,
+		ready_msg)))
+	test.AssertContainsString(session.replies, "Thanks, <@!"+testUserId+">, "+
+		"you're ready for the scrimmages queue.", "ready reply")
+
+	bot.expireReadyCheck(q.Key(), 1)
+	test.AssertEqual(q.Size(), 0)
+	test.AssertContainsString(session.dms[testUserId3], "You were taken "+
+		"from the scrimmages queue! Type `!ready` within 1h0m0s to "+
+		"confirm you're ready, or you'll be dropped.")
+
+	rc := bot.ready_checks[q.Key()]
+	test.Assert(rc != nil)
+	bot.reactionHandler(nil, &discordgo.Event{
+		Type: eventReactionAdd,
+		RawData: []byte(fmt.Sprintf(`{"user_id": %q, "message_id": %q, `+
+			`"channel_id": %q, "emoji": {"name": %q}}`, testUserId3,
+			rc.message_id, testChannelId, emojiReady)),
+	})
+	test.AssertEqual(len(bot.ready_checks), 0)
+
+	summary := "Ready check for the scrimmages queue complete. Ready (2): " +
+		testBTag + ", " + testBTag3 + ". Dropped (1): " + testBTag2 + "."
+	test.AssertContainsString(session.dms[testModId], summary)
+	test.AssertContainsString(session.replies, summary)
+	test.AssertContainsString(session.dms[testUserId3], "You're confirmed "+
+		"for the scrimmages queue! Lobby details: lobby foo")
+	test.AssertEqual(len(session.dms[testUserId2]), 1)
+}
+
+func TestQueueTakeTeams(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testModId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	session.allowAll()
+
+	test.AssertNil(bot.updatePlayer(testGuildId, testUserId, func(p *player) {
+		p.Ratings[roleTank] = 3000
+	}))
+	test.AssertNil(bot.updatePlayer(testGuildId, testUserId2,
+		func(p *player) {
+			p.Ratings[roleTank] = 2000
+		}))
+	q.Enqueue(newTestAuthor(testUserId, testBTag))
+	q.Enqueue(newTestAuthor(testUserId2, testBTag2))
+	q.Enqueue(newTestAuthor(testUserId3, testBTag3))
+
+	test.AssertEqual(bot.queueTake(q, newTestCommand("take", "teams 3",
+		session, msg)), "Teams require an even number of players, at "+
+		"most 12.")
+	test.AssertEqual(bot.queueTake(q, newTestCommand("take", "teams 2",
+		session, msg)), "Took 2 BattleTags from the scrimmages queue: "+
+		testBTag+", "+testBTag2+". 1 BattleTags remain in the queue.\n"+
+		"Team 1 (average 3000): "+testBTag+" (tank 3000).\n"+
+		"Team 2 (average 2000): "+testBTag2+" (tank 2000).\n"+
+		"Rating spread: 1000.")
+}
+
+func TestQueueCapacity(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	msg2 := newTestMessage(testUserId2, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+
+	test.AssertEqual(bot.queueCapacity(q, newTestCommand("capacity", "",
+		session, msg)), "The scrimmages queue has no capacity limit.")
+	session.allowAll()
+	test.AssertEqual(bot.queueCapacity(q, newTestCommand("capacity", "0",
+		session, msg)), "Invalid capacity \"0\". Try `!queue capacity "+
+		"12` or `!queue capacity off`.")
+	test.AssertEqual(bot.queueCapacity(q, newTestCommand("capacity", "1",
+		session, msg)), "The scrimmages queue now holds at most 1 users.")
+
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", testBTag, session,
+		msg)))
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", testBTag2, session,
+		msg2)))
+	test.AssertContainsString(session.replies, "The scrimmages queue is "+
+		"full, so "+testBTag2+" was added to its waitlist in position 1. "+
+		"You'll be sent a DM when a spot opens up.")
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", testBTag2, session,
+		msg2)))
+	test.AssertContainsString(session.replies, "User <@!"+testUserId2+
+		"> is already waitlisted as \""+testBTag2+"\" in position 1.")
+
+	q, err = bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	test.AssertEqual(q.Size(), 1)
+	test.AssertEqual(bot.queueList(q, newTestCommand("list", "", session,
+		msg)), "The scrimmages queue contains 1 BattleTags: "+testBTag+
+		". Capacity: 1. Waitlisted (1): "+testBTag2+".")
+
+	test.AssertNil(bot.dequeue(newTestCommand("dequeue", "", session, msg)))
+	test.AssertEqual(q.Size(), 1)
+	test.AssertEqual(q.Position(newTestAuthor(testUserId2, testBTag2).Key()),
+		1)
+	test.AssertContainsString(session.dms[testUserId2], "A spot opened up "+
+		"in the scrimmages queue! You've been promoted from the waitlist "+
+		"to position 1.")
+
+	// lookups share the queue's capacity, which adding at a position
+	// respects too
+	q2, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	test.Assert(q2.waitlist == q.waitlist)
+	msg.Mentions = []*discordgo.User{{ID: testUserId3, Username: "baz"}}
+	test.AssertEqual(bot.queueAdd(q, newTestCommand("add", "<@!"+
+		testUserId3+"> "+testBTag3+" 1", session, msg)), "The scrimmages "+
+		"queue is full, so "+testBTag3+" was added to its waitlist in "+
+		"position 1.")
+	test.AssertEqual(q.Size(), 1)
+	_, err = q.Remove(newTestAuthor(testUserId3, testBTag3).Key())
+	test.AssertNil(err)
+
+	test.AssertEqual(bot.queueCapacity(q, newTestCommand("capacity", "off",
+		session, msg)), "The scrimmages queue no longer has a capacity "+
+		"limit.")
+}
+
+func TestQueueNotify(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+
+	test.AssertEqual(bot.queueNotify(q, newTestCommand("notify", "",
+		session, msg)), "You aren't notified by the scrimmages queue. "+
+		"Try `!queue notify 5`.")
+	test.AssertEqual(bot.queueNotify(q, newTestCommand("notify", "1",
+		session, msg)), "You'll be sent a DM upon reaching the top 1 of "+
+		"the scrimmages queue.")
+
+	q.Enqueue(newTestAuthor(testUserId2, testBTag2))
+	q.Enqueue(newTestAuthor(testUserId, testBTag))
+	bot.queueChanged(session, q)
+	test.AssertEqual(len(session.dms[testUserId]), 0)
+
+	session.allowAll()
+	bot.queueTake(q, newTestCommand("take", "1", session, msg))
+	test.AssertContainsString(session.dms[testUserId],
+		"You're now in position 1 of the scrimmages queue.")
+	bot.queueChanged(session, q)
+	test.AssertEqual(len(session.dms[testUserId]), 1)
+
+	test.AssertEqual(bot.queueNotify(q, newTestCommand("notify", "off",
+		session, msg)), "You'll no longer be sent a DM by the scrimmages "+
+		"queue.")
+}
+
+func TestQueueBroadcast(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	cmd := newTestCommand("broadcast", "starting soon", session, msg)
+
+	test.AssertEqual(bot.queueBroadcast(q, cmd), "Permission denied.")
+
+	session.allowAll()
+	q.Enqueue(newTestAuthor(testUserId, testBTag))
+	q.Enqueue(newTestAuthor(testUserId2, testBTag2))
+	test.AssertEqual(bot.queueBroadcast(q, cmd),
+		"Sent the message to 2 of 2 queued users.")
+	test.AssertContainsString(session.dms[testUserId2],
+		"Message to the scrimmages queue from foobar: starting soon")
+}
+
+func TestQueueHistory(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+
+	test.AssertEqual(bot.queueHistory(q, newTestCommand("history", "",
+		session, msg)), "The scrimmages queue has no history.")
+
+	bot.enqueue(newTestCommand("enqueue", testBTag, session, msg))
+	session.allowAll()
+	bot.queueTake(q, newTestCommand("take", "", session, msg))
+	bot.queueClear(q, newTestCommand("clear", "", session, msg))
+
+	entries, err := bot.audit.Recent(testGuildId, 10)
+	test.AssertNil(err)
+	test.AssertEqual(len(entries), 3)
+	test.AssertEqual(entries[0].Action, "enqueue")
+	test.AssertEqual(entries[0].Target, testBTag)
+	test.AssertEqual(entries[0].Position, 1)
+	test.AssertEqual(entries[1].Action, "take")
+	test.AssertEqual(entries[1].ActorId, testUserId)
+	test.AssertEqual(entries[2].Action, "clear")
+
+	history := bot.queueHistory(q, newTestCommand("history", "2", session,
+		msg))
+	test.Assert(strings.HasPrefix(history,
+		"The last 2 changes to the scrimmages queue:\n"), history)
+	test.Assert(strings.HasSuffix(history, "foobar: clear (0 entries)"),
+		history)
+	test.AssertEqual(bot.queueHistory(q, newTestCommand("history", "x",
+		session, msg)), "Invalid history argument \"x\".")
+}
+
 func TestEnqueueRateLimit(t *testing.T) {
 	test, bot, session := newQueueTest(t)
 	msg := newTestMessage(testUserId, testChannelId)
@@ -192,7 +691,7 @@ func TestIdentifyRoleDefaultsToDPS(t *testing.T) {
 
 	cmd := newTestCommand("role", "", session, msg)
 	test.AssertEqual(bot.queueIdentifyRole(nil, cmd),
-		fmt.Sprintf("Roles matched by \"foobar\": DPS: %s, "+
+		fmt.Sprintf("Roles of \"foobar\": DPS: %s (default), "+
 			"Support: %s, Tank: %s",
 			symbolChecked, symbolSaltire, symbolSaltire))
 }
@@ -204,8 +703,8 @@ func TestIdentifyRolePerformsServerLookup(t *testing.T) {
 	cmd := newTestCommand("role", "", session, msg)
 	session.appendMemberNicks("foobar [tank]")
 	test.AssertEqual(bot.queueIdentifyRole(nil, cmd),
-		fmt.Sprintf("Roles matched by \"foobar [tank]\": DPS: %s, "+
-			"Support: %s, Tank: %s",
+		fmt.Sprintf("Roles of \"foobar [tank]\": DPS: %s, "+
+			"Support: %s, Tank: %s (from nickname)",
 			symbolSaltire, symbolSaltire, symbolChecked))
 }
 
@@ -225,7 +724,7 @@ func TestRoles(t *testing.T) {
 ////////////////////////////////////////////////////////////////////////
 
 func newBot() *bot {
-	return &bot{
+	b := &bot{
 		seen:               seen_mem.New(),
 		urls:               url_mem.New(),
 		mildred:            nil,
@@ -236,21 +735,55 @@ func newBot() *bot {
 		oauth_states:       make(map[string]string),
 		last_activity:      time.Now(),
 		queues:             queue.NewManager(),
+		cache:              newGuildCache(defaultCacheTTL),
 		user_last_enqueued: make(map[string]time.Time),
+		watches:            make(map[string]queue.Subscription),
+		notified:           make(map[string]bool),
+		priority_queues:    make(map[string]queue.PriorityQueue),
+		waitlist_queues:    make(map[string]*cachedWaitlist),
+		ready_checks:       make(map[string]*readyCheck),
+		party_invites:      make(map[string]*partyInvite),
+		status_pending:     make(map[string]bool),
+		declared_roles:     make(map[string]map[string]bool),
+		dashboard_subscribers: make(
+			map[string]map[chan struct{}]bool),
 	}
+	b.store = store.NewMemory()
+	b.audit = audit_mem.New()
+	b.queue_registry = newQueueRegistry(b.store)
+
+	return b
 }
 
 type mockSession struct {
 	perms   int
 	replies []string
 	nicks   []string
+	dms     map[string][]string
+	edits   map[string]string
+	pinned  map[string]bool
+	created []string
+	// permission overwrites by channel and target, as [allow, deny]
+	overwrites map[string][2]int
+	deleted    map[string]bool
+	channels   []*discordgo.Channel
+	// voice channel ids by user id
+	voice map[string]string
+	// called upon each message sent, when set
+	on_send func()
 }
 
 func newMockSession() *mockSession {
 	return &mockSession{
-		perms:   0,
-		replies: make([]string, 0),
-		nicks:   make([]string, 0),
+		perms:      0,
+		replies:    make([]string, 0),
+		nicks:      make([]string, 0),
+		dms:        make(map[string][]string),
+		edits:      make(map[string]string),
+		pinned:     make(map[string]bool),
+		overwrites: make(map[string][2]int),
+		deleted:    make(map[string]bool),
+		voice:      make(map[string]string),
 	}
 }
 
@@ -263,9 +796,12 @@ func (s *mockSession) appendMemberNicks(nicks ...string) {
 }
 
 func (s *mockSession) Member(guild_id, user_id string) (*discordgo.Member, error) {
+	// the last nickname sticks, as members are looked up with each use
 	nick := ""
 	if len(s.nicks) > 0 {
 		nick = s.nicks[0]
+	}
+	if len(s.nicks) > 1 {
 		s.nicks = s.nicks[1:]
 	}
 
@@ -281,8 +817,101 @@ func (s *mockSession) UserChannelPermissions(user_id, channel_id string) (
 
 func (s *mockSession) ChannelMessageSend(channel_id, msg string) (
 	*discordgo.Message, error) {
+	if s.on_send != nil {
+		s.on_send()
+	}
+	if strings.HasPrefix(channel_id, "dm-") {
+		user_id := strings.TrimPrefix(channel_id, "dm-")
+		s.dms[user_id] = append(s.dms[user_id], msg)
+		return nil, nil
+	}
 	s.replies = append(s.replies, msg)
-	return nil, nil
+	return &discordgo.Message{
+		ID:        fmt.Sprintf("message-%d", len(s.replies)),
+		ChannelID: channel_id,
+	}, nil
+}
+
+func (s *mockSession) ChannelMessageEdit(channel_id, message_id,
+	msg string) (*discordgo.Message, error) {
+	s.edits[message_id] = msg
+	return &discordgo.Message{ID: message_id, ChannelID: channel_id}, nil
+}
+
+func (s *mockSession) ChannelMessagePin(channel_id, message_id string) error {
+	s.pinned[message_id] = true
+	return nil
+}
+
+func (s *mockSession) ChannelMessageUnpin(channel_id,
+	message_id string) error {
+	delete(s.pinned, message_id)
+	return nil
+}
+
+func (s *mockSession) MessageReactionAdd(channel_id, message_id,
+	emoji string) error {
+	return nil
+}
+
+func (s *mockSession) UserChannelCreate(recipient_id string) (
+	*discordgo.Channel, error) {
+	return &discordgo.Channel{ID: "dm-" + recipient_id}, nil
+}
+
+func (s *mockSession) GuildChannelCreate(guild_id, name, ctype string) (
+	*discordgo.Channel, error) {
+	s.created = append(s.created, name)
+	ch := &discordgo.Channel{
+		ID:      fmt.Sprintf("channel-%d", len(s.created)),
+		GuildID: guild_id,
+		Name:    name,
+		Type:    ctype,
+	}
+	s.channels = append(s.channels, ch)
+	return ch, nil
+}
+
+func (s *mockSession) GuildChannels(guild_id string) (
+	[]*discordgo.Channel, error) {
+	return s.channels, nil
+}
+
+func (s *mockSession) GuildMemberMove(guild_id, user_id,
+	channel_id string) error {
+	s.voice[user_id] = channel_id
+	return nil
+}
+
+func (s *mockSession) VoiceStates(guild_id string) (
+	[]*discordgo.VoiceState, error) {
+	states := []*discordgo.VoiceState{}
+	for user_id, channel_id := range s.voice {
+		states = append(states, &discordgo.VoiceState{
+			UserID:    user_id,
+			ChannelID: channel_id,
+			GuildID:   guild_id,
+		})
+	}
+	return states, nil
+}
+
+func (s *mockSession) ChannelPermissionSet(channel_id, target_id,
+	target_type string, allow, deny int) error {
+	s.overwrites[channel_id+"/"+target_id] = [2]int{allow, deny}
+	return nil
+}
+
+func (s *mockSession) ChannelDelete(channel_id string) (*discordgo.Channel,
+	error) {
+	s.deleted[channel_id] = true
+	for idx, ch := range s.channels {
+		if ch.ID == channel_id {
+			s.channels = append(s.channels[:idx], s.channels[idx+1:]...)
+			break
+		}
+	}
+	return &discordgo.Channel{ID: channel_id}, nil
 }
 
 func (s *mockSession) GuildIdFromChannelId(channel_id string) (string, error) {
@@ -315,10 +944,10 @@ func newTestCommand(name, args string, session Session,
 func newTestMessage(author_id, channel_id string) *discordgo.Message {
 	return &discordgo.Message{
 		Author: &discordgo.User{
-			ID:       testUserId,
+			ID:       author_id,
 			Username: "foobar",
 		},
-		ChannelID: testChannelId,
+		ChannelID: channel_id,
 	}
 }
 
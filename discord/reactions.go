@@ -0,0 +1,210 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/ewollesen/discordgo"
+	"xmtp.net/xmtpbot/queue"
+)
+
+const (
+	emojiJoin    = "✅"
+	emojiLeave   = "❌"
+	emojiTank    = "🛡"
+	emojiSupport = "💉"
+	emojiDPS     = "⚔"
+
+	// Discord appends this to the names of some emoji, eg "🛡️"
+	emojiVariationSelector = "\ufe0f"
+)
+
+var (
+	queueReactions = flag.Bool("discord.queue_reactions", false,
+		"add reactions to pinned queue status messages, with which users "+
+			"join and leave the queue, and declare their roles")
+
+	statusReactions = []string{emojiJoin, emojiLeave, emojiTank,
+		emojiSupport, emojiDPS}
+	emojiRoles = map[string]string{
+		emojiTank:    roleTank,
+		emojiSupport: roleSupport,
+		emojiDPS:     roleDPS,
+	}
+)
+
+// reactionCommand is a command given by reacting to a queue's status
+// message. Its replies are sent by DM, rather than to the channel.
+type reactionCommand struct {
+	*command
+}
+
+func (c *reactionCommand) Reply(template string,
+	args ...interface{}) error {
+
+	return sendDM(c.session, c.message.Author.ID,
+		fmt.Sprintf(template, args...))
+}
+
+// addStatusReactions adds the reactions with which users use the queue to
+// its status message.
+func addStatusReactions(session Session, channel_id, message_id string) {
+	for _, emoji := range statusReactions {
+		err := session.MessageReactionAdd(channel_id, message_id, emoji)
+		if err != nil {
+			logger.Errore(err)
+			return
+		}
+	}
+}
+
+// queueReaction acts upon a reaction added to, or removed from, a queue's
+// status message. Reactions to other messages are ignored.
+func (b *bot) queueReaction(session Session, reaction *reactionEvent,
+	added bool) {
+
+	emoji := strings.Replace(reaction.Emoji.Name, emojiVariationSelector,
+		"", -1)
+	role, is_role := emojiRoles[emoji]
+	if emoji != emojiJoin && emoji != emojiLeave && !is_role {
+		return
+	}
+	if emoji == emojiLeave && !added {
+		return
+	}
+
+	q, err := b.statusQueue(session, reaction.ChannelId, reaction.MessageId)
+	if err != nil {
+		logger.Errore(err)
+		return
+	}
+	if q == nil {
+		return
+	}
+
+	user := &discordgo.User{ID: reaction.UserId}
+	member, err := session.Member(q.guild_id, reaction.UserId)
+	if err == nil && member.User != nil {
+		user = member.User
+	}
+	cmd := &reactionCommand{&command{
+		session: session,
+		message: &discordgo.Message{
+			ChannelID: reaction.ChannelId,
+			Author:    user,
+		},
+	}}
+
+	switch {
+	case is_role:
+		b.declareRole(q, cmd.Author(), role, added)
+	case emoji == emojiJoin && added:
+		cmd.name = "enqueue"
+		logger.Errore(b.enqueueIn(q, cmd, ""))
+	default:
+		// unreacting with emojiJoin leaves the queue, as does emojiLeave
+		if q.Position(cmd.Author().Key()) < 0 &&
+			q.waitlistPosition(cmd.Author().Key()) < 0 {
+			return
+		}
+		cmd.name = "dequeue"
+		logger.Errore(b.dequeueFrom(q, cmd))
+	}
+}
+
+// statusQueue returns the queue whose status is shown by the message, or nil
+// if it isn't a status message.
+func (b *bot) statusQueue(session Session, channel_id, message_id string) (
+	*scrimQueue, error) {
+
+	guild_id, err := session.GuildIdFromChannelId(channel_id)
+	if err != nil {
+		return nil, err
+	}
+	names, err := b.queue_registry.Names(guild_id)
+	if err != nil {
+		return nil, err
+	}
+
+	b.status_mtx.Lock()
+	defer b.status_mtx.Unlock()
+
+	for _, name := range append([]string{""}, names...) {
+		q := b.lookupNamedQueue(guild_id, name)
+		messages, err := b.loadStatusMessages(q)
+		if err != nil {
+			return nil, err
+		}
+		if messages[channel_id] == message_id {
+			return q, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// declareRole records that the author will, or won't, play the role while
+// in the queue, updating their entry if they're queued already.
+func (b *bot) declareRole(q *scrimQueue, a Author, role string, added bool) {
+	b.declared_roles_mtx.Lock()
+	key := declaredRolesKey(q, a.UserId())
+	declared := b.declared_roles[key]
+	if declared == nil {
+		declared = make(map[string]bool)
+		b.declared_roles[key] = declared
+	}
+	declared[role] = added
+	b.declared_roles_mtx.Unlock()
+
+	roles := b.declaredRoles(q, a.UserId())
+	err := q.Update(a.Key(), func(queueable queue.Queueable) error {
+		queueable.(Author).SetRoles(roles)
+		return nil
+	})
+	if err != nil {
+		if !queue.NotFoundError.Contains(err) {
+			logger.Errore(err)
+		}
+		return
+	}
+	b.queueChanged(nil, q)
+}
+
+// declaredRoles returns the roles the user has declared for the queue, in
+// the order of allRoles, or nil if they've declared none.
+func (b *bot) declaredRoles(q *scrimQueue, user_id string) []string {
+	b.declared_roles_mtx.Lock()
+	defer b.declared_roles_mtx.Unlock()
+
+	declared := b.declared_roles[declaredRolesKey(q, user_id)]
+	roles := []string{}
+	for _, role := range allRoles {
+		if declared[role] {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+
+	return roles
+}
+
+func declaredRolesKey(q *scrimQueue, user_id string) string {
+	return fmt.Sprintf("%s-%s", q.Key(), user_id)
+}
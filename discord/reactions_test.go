@@ -0,0 +1,75 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueueReactions(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	bot.live_session = session
+	bot.queue_reactions = true
+	session.allowAll()
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	bot.queuePin(q, newTestCommand("pin", "", session, msg))
+
+	react := func(emoji, message_id string, added bool) {
+		reaction := &reactionEvent{
+			UserId:    testUserId2,
+			ChannelId: testChannelId,
+			MessageId: message_id,
+		}
+		reaction.Emoji.Name = emoji
+		for i := 0; i < 5; i++ {
+			session.appendMemberNicks("Example#1234")
+		}
+		bot.queueReaction(session, reaction, added)
+	}
+
+	react(emojiJoin, "message-2", true)
+	test.AssertEqual(q.Size(), 0)
+
+	react(emojiTank+emojiVariationSelector, "message-1", true)
+	react(emojiJoin, "message-1", true)
+	test.AssertEqual(q.Size(), 1)
+	test.AssertContainsString(session.dms[testUserId2],
+		"Successfully added Example#1234 to the scrimmages queue in "+
+			"position 1.")
+	queueables, err := q.List()
+	test.AssertNil(err)
+	test.AssertEqual(strings.Join(queueables[0].(Author).Roles(), ","), roleTank)
+
+	react(emojiSupport, "message-1", true)
+	react(emojiTank, "message-1", false)
+	queueables, err = q.List()
+	test.AssertNil(err)
+	test.AssertEqual(strings.Join(queueables[0].(Author).Roles(), ","),
+		roleSupport)
+
+	react(emojiLeave, "message-1", false)
+	test.AssertEqual(q.Size(), 1)
+	react(emojiLeave, "message-1", true)
+	test.AssertEqual(q.Size(), 0)
+	test.AssertContainsString(session.dms[testUserId2],
+		"Successfully removed Example#1234 from the scrimmages queue.")
+
+	dms := len(session.dms[testUserId2])
+	react(emojiJoin, "message-1", false)
+	test.AssertEqual(len(session.dms[testUserId2]), dms)
+}
@@ -0,0 +1,367 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ewollesen/discordgo"
+	"xmtp.net/xmtpbot/queue"
+)
+
+const (
+	emojiReady          = "✅"
+	eventReactionAdd    = "MESSAGE_REACTION_ADD"
+	eventReactionRemove = "MESSAGE_REACTION_REMOVE"
+	readyCheckMaxRuns   = 10
+)
+
+var (
+	readyCheckWindow = flag.Duration("discord.ready_check_window", 0,
+		"time taken players have to confirm they're ready, by typing "+
+			"!ready or reacting to the ready check; 0 disables ready checks")
+)
+
+// readyCheck tracks the players taken from a queue until each of them has
+// either confirmed they're ready, or been dropped for failing to do so in
+// time. Dropped players are replaced by the next players in the queue.
+type readyCheck struct {
+	q          *scrimQueue
+	session    Session
+	moderator  Author
+	channel_id string
+	message_id string
+	details    string
+	teams      bool
+	round      int
+	pending    map[string]Author
+	ready      []Author
+	dropped    []Author
+	timer      *time.Timer
+}
+
+type reactionEvent struct {
+	UserId    string `json:"user_id"`
+	ChannelId string `json:"channel_id"`
+	MessageId string `json:"message_id"`
+	Emoji     struct {
+		Name string `json:"name"`
+	} `json:"emoji"`
+}
+
+func (b *bot) readyCheckActive(q *scrimQueue) bool {
+	b.ready_checks_mtx.Lock()
+	defer b.ready_checks_mtx.Unlock()
+
+	_, ok := b.ready_checks[q.Key()]
+
+	return ok
+}
+
+// startReadyCheck asks the taken players to confirm they're ready. Returns
+// the number of them successfully sent a DM.
+func (b *bot) startReadyCheck(q *scrimQueue, cmd Command,
+	taken []queue.Queueable, details string, teams bool) (sent int) {
+
+	rc := &readyCheck{
+		q:          q,
+		session:    cmd.Session(),
+		moderator:  cmd.Author(),
+		channel_id: cmd.Message().ChannelID,
+		details:    details,
+		teams:      teams,
+	}
+
+	b.ready_checks_mtx.Lock()
+	b.ready_checks[q.Key()] = rc
+	round, pending := b.beginReadyRound(rc, taken)
+	b.ready_checks_mtx.Unlock()
+
+	return b.announceReadyRound(rc, round, pending)
+}
+
+// beginReadyRound makes the taken players the pending players of a new
+// round of the ready check, returning the round and its players, who are
+// then to be sent announceReadyRound.
+//
+// The caller is responsible for obtaining b.ready_checks_mtx before calling
+func (b *bot) beginReadyRound(rc *readyCheck, taken []queue.Queueable) (
+	round int, pending []Author) {
+
+	rc.round++
+	rc.pending = make(map[string]Author)
+	for _, queueable := range taken {
+		a := queueable.(Author)
+		rc.pending[a.UserId()] = a
+		pending = append(pending, a)
+	}
+
+	key, round := rc.q.Key(), rc.round
+	rc.timer = time.AfterFunc(b.ready_check_window, func() {
+		b.expireReadyCheck(key, round)
+	})
+
+	return round, pending
+}
+
+// announceReadyRound asks the round's players to confirm they're ready, in
+// the channel the ready check started in and by DM. Returns the number of
+// them successfully sent a DM.
+func (b *bot) announceReadyRound(rc *readyCheck, round int,
+	pending []Author) (sent int) {
+
+	mentions := []string{}
+	for _, a := range pending {
+		mentions = append(mentions, a.Mention())
+	}
+
+	msg, err := rc.session.ChannelMessageSend(rc.channel_id, fmt.Sprintf(
+		"Ready check for the %s: %s, type `!ready` or react with %s "+
+			"within %s, or you'll be dropped.", rc.q.Title(),
+		strings.Join(mentions, ", "), emojiReady, b.ready_check_window))
+	if err != nil {
+		logger.Errore(err)
+	} else if msg != nil {
+		b.ready_checks_mtx.Lock()
+		if rc.round == round {
+			rc.message_id = msg.ID
+		}
+		b.ready_checks_mtx.Unlock()
+		err = rc.session.MessageReactionAdd(rc.channel_id, msg.ID,
+			emojiReady)
+		if err != nil {
+			logger.Warnf("error adding ready reaction: %v", err)
+		}
+	}
+
+	dm := fmt.Sprintf("You were taken from the %s! Type `!ready` within %s "+
+		"to confirm you're ready, or you'll be dropped.", rc.q.Title(),
+		b.ready_check_window)
+	for _, a := range pending {
+		err := sendDM(rc.session, a.UserId(), dm)
+		if err != nil {
+			logger.Warnf("error notifying %s: %v", a.UserId(), err)
+			continue
+		}
+		b.forgetNotified(rc.q, a.UserId())
+		sent++
+	}
+
+	return sent
+}
+
+// markReady records that the user is ready, returning the ready check they
+// belong to, or nil if they have none pending. When message_id is given,
+// only the ready check announced by that message matches.
+func (b *bot) markReady(guild_id, user_id, message_id string) *readyCheck {
+	b.ready_checks_mtx.Lock()
+	var found *readyCheck
+	finished := false
+	for _, rc := range b.ready_checks {
+		if guild_id != "" && rc.q.guild_id != guild_id {
+			continue
+		}
+		if message_id != "" && rc.message_id != message_id {
+			continue
+		}
+		a, ok := rc.pending[user_id]
+		if !ok {
+			continue
+		}
+		delete(rc.pending, user_id)
+		rc.ready = append(rc.ready, a)
+		if len(rc.pending) == 0 {
+			b.removeReadyCheck(rc)
+			finished = true
+		}
+		found = rc
+		break
+	}
+	b.ready_checks_mtx.Unlock()
+
+	if finished {
+		b.finishReadyCheck(found)
+	}
+
+	return found
+}
+
+// expireReadyCheck drops the players who didn't confirm in time, replacing
+// them with the next players in the queue.
+func (b *bot) expireReadyCheck(key string, round int) {
+	b.ready_checks_mtx.Lock()
+	rc, ok := b.ready_checks[key]
+	if !ok || rc.round != round {
+		b.ready_checks_mtx.Unlock()
+		return
+	}
+	dropped := []Author{}
+	for _, a := range rc.pending {
+		dropped = append(dropped, a)
+	}
+	rc.dropped = append(rc.dropped, dropped...)
+	rc.pending = nil
+	finished := len(dropped) == 0 || rc.round >= readyCheckMaxRuns
+	if finished {
+		b.removeReadyCheck(rc)
+	}
+	b.ready_checks_mtx.Unlock()
+
+	for _, a := range dropped {
+		b.recordQueueEvent(rc.q, "drop", rc.moderator, a, 0,
+			"(not ready)")
+	}
+	if finished {
+		b.finishReadyCheck(rc)
+		return
+	}
+
+	// while nothing is pending, the ready check is left alone by everyone
+	// else, so its replacements can be taken without holding the lock
+	taken, err := b.takeEntries(rc.q, len(dropped))
+	if err != nil {
+		logger.Errore(err)
+	}
+	if len(taken) > 0 {
+		for idx, queueable := range taken {
+			b.recordQueueEvent(rc.q, "take", rc.moderator,
+				queueable.(Author), idx+1, "(replacement)")
+		}
+		if err = b.resetSkips(rc.q, taken); err != nil {
+			logger.Errore(err)
+		}
+		b.queueChanged(rc.session, rc.q)
+	}
+
+	b.ready_checks_mtx.Lock()
+	if len(taken) == 0 {
+		b.removeReadyCheck(rc)
+		b.ready_checks_mtx.Unlock()
+		b.finishReadyCheck(rc)
+		return
+	}
+	round, pending := b.beginReadyRound(rc, taken)
+	b.ready_checks_mtx.Unlock()
+
+	b.announceReadyRound(rc, round, pending)
+}
+
+// removeReadyCheck stops tracking the ready check, which is then to be
+// finished with finishReadyCheck.
+//
+// The caller is responsible for obtaining b.ready_checks_mtx before calling
+func (b *bot) removeReadyCheck(rc *readyCheck) {
+	delete(b.ready_checks, rc.q.Key())
+	if rc.timer != nil {
+		rc.timer.Stop()
+	}
+}
+
+// finishReadyCheck sends the lobby details to the ready players, and the
+// final roster to the moderator. The ready check must have been removed with
+// removeReadyCheck, after which it's no longer shared, and so is finished
+// without holding b.ready_checks_mtx.
+func (b *bot) finishReadyCheck(rc *readyCheck) {
+	msg := fmt.Sprintf("You're confirmed for the %s!", rc.q.Title())
+	if rc.details != "" {
+		msg += fmt.Sprintf(" Lobby details: %s", rc.details)
+	}
+	for _, a := range rc.ready {
+		err := sendDM(rc.session, a.UserId(), msg)
+		if err != nil {
+			logger.Warnf("error notifying %s: %v", a.UserId(), err)
+		}
+	}
+
+	summary := rc.summary()
+	roster := ""
+	if rc.teams {
+		roster = b.teamsReport(rc.q, rc.ready)
+	}
+	summary += b.openLobby(rc.session, rc.q, rc.moderator, rc.ready,
+		rc.details, roster)
+	if roster != "" {
+		summary += "\n" + roster
+	}
+	_, err := rc.session.ChannelMessageSend(rc.channel_id, summary)
+	logger.Errore(err)
+	err = sendDM(rc.session, rc.moderator.UserId(), summary)
+	if err != nil {
+		logger.Warnf("error notifying %s: %v", rc.moderator.UserId(), err)
+	}
+}
+
+func (rc *readyCheck) summary() string {
+	names := func(authors []Author) string {
+		if len(authors) == 0 {
+			return "none"
+		}
+		btags := []string{}
+		for _, a := range authors {
+			btags = append(btags, displayName(a))
+		}
+		return strings.Join(btags, ", ")
+	}
+
+	return fmt.Sprintf("Ready check for the %s complete. Ready (%d): %s. "+
+		"Dropped (%d): %s.", rc.q.Title(), len(rc.ready), names(rc.ready),
+		len(rc.dropped), names(rc.dropped))
+}
+
+func (b *bot) ready(cmd Command) (err error) {
+	guild_id, err := cmd.Session().GuildIdFromChannelId(
+		cmd.Message().ChannelID)
+	if err != nil {
+		logger.Errore(err)
+		return cmd.Reply("Error looking up guild: %s", err)
+	}
+
+	rc := b.markReady(guild_id, cmd.Message().Author.ID, "")
+	if rc == nil {
+		return cmd.Reply("You have no pending ready check, %s.",
+			cmd.Author().Mention())
+	}
+
+	return cmd.Reply("Thanks, %s, you're ready for the %s.",
+		cmd.Author().Mention(), rc.q.Title())
+}
+
+func (b *bot) reactionHandler(s *discordgo.Session, e *discordgo.Event) {
+	if e.Type != eventReactionAdd && e.Type != eventReactionRemove {
+		return
+	}
+
+	var reaction reactionEvent
+	err := json.Unmarshal(e.RawData, &reaction)
+	if err != nil {
+		logger.Errore(err)
+		return
+	}
+	if reaction.UserId == b.myDiscordUserId(s) {
+		return
+	}
+
+	added := e.Type == eventReactionAdd
+	if added && reaction.Emoji.Name == emojiReady {
+		b.markReady("", reaction.UserId, reaction.MessageId)
+	}
+	if b.queue_reactions {
+		b.queueReaction(&session{Session: s, cache: b.cache}, &reaction, added)
+	}
+}
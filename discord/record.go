@@ -0,0 +1,195 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ewollesen/discordgo"
+	"xmtp.net/xmtpbot/queue"
+)
+
+const authorRecordVersion = 2
+
+// AuthorMarshaler reads and writes the queue records of authors, in any of
+// the formats they've been stored in.
+var AuthorMarshaler = newAuthorMarshaler()
+
+// authorRecord is the compact form in which queued authors are stored,
+// holding only what's needed to identify them and order the queue.
+type authorRecord struct {
+	Version    int      `json:"v"`
+	UserId     string   `json:"u"`
+	GuildId    string   `json:"g"`
+	Username   string   `json:"n,omitempty"`
+	BattleTag  string   `json:"b,omitempty"`
+	Roles      []string `json:"r,omitempty"`
+	EnqueuedAt int64    `json:"t,omitempty"`
+	Skips      int      `json:"s,omitempty"`
+	PartyId    string   `json:"p,omitempty"`
+}
+
+func newAuthorMarshaler() *queue.MarshalerRegistry {
+	r := queue.NewMarshalerRegistry()
+	r.Register(queue.UnversionedRecord, nil, unmarshalAuthorV1)
+	r.Register(authorRecordVersion, marshalAuthorV2, unmarshalAuthorV2)
+
+	return r
+}
+
+func marshalAuthorV2(queueable queue.Queueable) ([]byte, error) {
+	a, ok := queueable.(*author)
+	if !ok {
+		return nil, queue.MarshalError.New("unexpected queueable %T",
+			queueable)
+	}
+	guild_id, err := a.guildId()
+	if err != nil {
+		return nil, err
+	}
+	btag, err := a.BattleTag()
+	if err != nil {
+		return nil, err
+	}
+
+	record := &authorRecord{
+		Version:   authorRecordVersion,
+		UserId:    a.User.ID,
+		GuildId:   guild_id,
+		Username:  a.User.Username,
+		BattleTag: btag,
+		Roles:     a.Roles(),
+		Skips:     a.Skips_,
+		PartyId:   a.PartyId_,
+	}
+	if !a.EnqueuedAt_.IsZero() {
+		record.EnqueuedAt = a.EnqueuedAt_.Unix()
+	}
+
+	return json.Marshal(record)
+}
+
+func unmarshalAuthorV2(data []byte) (queue.Queueable, error) {
+	record := &authorRecord{}
+	err := json.Unmarshal(data, record)
+	if err != nil {
+		return nil, err
+	}
+	if record.UserId == "" {
+		return nil, queue.MarshalError.New("record has no user id")
+	}
+
+	a := &author{
+		BattleTag_: record.BattleTag,
+		GuildId:    record.GuildId,
+		PartyId_:   record.PartyId,
+		Roles_:     record.Roles,
+		Skips_:     record.Skips,
+		User: &discordgo.User{
+			ID:       record.UserId,
+			Username: record.Username,
+		},
+	}
+	if a.Roles_ == nil {
+		a.Roles_ = []string{}
+	}
+	if record.EnqueuedAt != 0 {
+		a.EnqueuedAt_ = time.Unix(record.EnqueuedAt, 0)
+	}
+
+	return a, nil
+}
+
+func (b *bot) queueMigrate(cmd Command) string {
+	ok, err := cmd.Author().PermittedTo(discordgo.PermissionManageServer)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	guild_id, err := cmd.Session().GuildIdFromChannelId(
+		cmd.Message().ChannelID)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error looking up guild: %s", err)
+	}
+	switch strings.TrimSpace(cmd.Args()) {
+	case "":
+	case "legacy":
+		return b.migrateLegacyQueue(cmd, guild_id)
+	default:
+		return "Try `!queue migrate` or `!queue migrate legacy`."
+	}
+
+	names, err := b.queue_registry.Names(guild_id)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error looking up queues: %s", err)
+	}
+
+	migrated := 0
+	for _, name := range append([]string{""}, names...) {
+		q := &scrimQueue{guild_id: guild_id, name: name}
+		for _, key := range []string{q.Key(), waitlistKey(q.Key())} {
+			migrator, ok := b.queues.Lookup(key).(queue.Migrator)
+			if !ok {
+				return "This bot's queues are rewritten in the current " +
+					"format whenever they're saved; there's nothing to " +
+					"migrate."
+			}
+			n, err := migrator.Migrate()
+			if err != nil {
+				logger.Errore(err)
+				return fmt.Sprintf("Error migrating the %s: %s",
+					q.Title(), err)
+			}
+			migrated += n
+		}
+	}
+
+	return fmt.Sprintf("Rewrote %d queue entries in the current format "+
+		"(version %d).", migrated, authorRecordVersion)
+}
+
+// migrateLegacyQueue moves the queue once shared by every guild to the
+// guild's default queue. Only one guild can inherit it, so it's up to a
+// manager of that guild to ask for it.
+func (b *bot) migrateLegacyQueue(cmd Command, guild_id string) string {
+	migrator, ok := b.queues.(queue.LegacyMigrator)
+	if !ok {
+		return "This bot's queues were never shared between guilds; " +
+			"there's nothing to migrate."
+	}
+
+	q := b.lookupNamedQueue(guild_id, "")
+	migrated, err := migrator.MigrateLegacy(q.Key())
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error migrating the shared queue: %s", err)
+	}
+	if !migrated {
+		return "There's no shared queue left to migrate."
+	}
+	b.queueChanged(cmd.Session(), q)
+
+	return fmt.Sprintf("Moved the shared queue to the %s.", q.Title())
+}
@@ -0,0 +1,117 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ewollesen/discordgo"
+	"xmtp.net/xmtpbot/queue"
+	"xmtp.net/xmtpbot/queue/redistest"
+	"xmtp.net/xmtpbot/test"
+)
+
+func TestAuthorRecord(t *testing.T) {
+	test := test.New(t)
+	at := time.Unix(1476000000, 0)
+	a := &author{
+		BattleTag_:  testBTag,
+		EnqueuedAt_: at,
+		GuildId:     testGuildId,
+		Member_:     &discordgo.Member{Nick: "foobar [tank]"},
+		PartyId_:    "party",
+		Skips_:      2,
+		User:        &discordgo.User{ID: testUserId, Username: "foobar"},
+	}
+
+	data, err := AuthorMarshaler.Marshal(a)
+	test.AssertNil(err)
+	test.AssertEqual(string(data), `{"v":2,"u":"`+testUserId+`","g":"`+
+		testGuildId+`","n":"foobar","b":"`+testBTag+`","r":["tank"],`+
+		`"t":1476000000,"s":2,"p":"party"}`)
+
+	queueable, err := AuthorMarshaler.Unmarshal(data)
+	test.AssertNil(err)
+	restored := queueable.(Author)
+	test.AssertEqual(restored.Key(), a.Key())
+	test.AssertEqual(displayName(restored), testBTag)
+	test.Assert(restored.EnqueuedAt().Equal(at))
+	test.AssertEqual(restored.Skips(), 2)
+	test.AssertEqual(restored.PartyId(), "party")
+	test.AssertEqual(len(restored.Roles()), 1)
+	test.AssertEqual(restored.Roles()[0], roleTank)
+}
+
+func TestAuthorRecordLegacy(t *testing.T) {
+	test := test.New(t)
+	data := []byte(`{"battle_tag":"` + testBTag + `","channel_id":"` +
+		testChannelId + `","guild_id":"` + testGuildId + `","member":` +
+		`{"nick":"foobar [support]"},"user":{"id":"` + testUserId +
+		`","username":"foobar"}}`)
+
+	version, err := queue.RecordVersion(data)
+	test.AssertNil(err)
+	test.AssertEqual(version, queue.UnversionedRecord)
+
+	queueable, err := AuthorMarshaler.Unmarshal(data)
+	test.AssertNil(err)
+	a := queueable.(Author)
+	test.AssertEqual(a.Key(), testGuildId+"-"+testUserId)
+	test.AssertEqual(displayName(a), testBTag)
+	test.AssertEqual(len(a.Roles()), 1)
+	test.AssertEqual(a.Roles()[0], roleSupport)
+
+	migrated, err := AuthorMarshaler.Marshal(a)
+	test.AssertNil(err)
+	version, err = queue.RecordVersion(migrated)
+	test.AssertNil(err)
+	test.AssertEqual(version, authorRecordVersion)
+}
+
+func TestQueueMigrateLegacy(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	migrate := func() string {
+		return bot.queueMigrate(newTestCommand("migrate", "legacy",
+			session, msg))
+	}
+
+	test.AssertEqual(migrate(), "Permission denied.")
+	session.allowAll()
+	test.AssertEqual(migrate(), "This bot's queues were never shared "+
+		"between guilds; there's nothing to migrate.")
+
+	server, err := redistest.NewServer()
+	test.AssertNil(err)
+	defer server.Close()
+	legacy := queue.NewRedis("xmtpbot.testing", server.Client(),
+		AuthorMarshaler)
+	test.AssertNil(legacy.Enqueue(newTestAuthor(testUserId2, testBTag2)))
+	bot.queues = queue.NewRedisManager("xmtpbot.testing", server.Client(),
+		AuthorMarshaler)
+
+	// looking the queue up leaves the shared queue alone
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	test.AssertEqual(q.Size(), 0)
+	test.AssertEqual(legacy.Size(), 1)
+
+	test.AssertEqual(migrate(), "Moved the shared queue to the "+
+		"scrimmages queue.")
+	test.AssertEqual(q.Size(), 1)
+	test.AssertEqual(legacy.Size(), 0)
+	test.AssertEqual(migrate(), "There's no shared queue left to migrate.")
+}
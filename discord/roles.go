@@ -0,0 +1,310 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	roleFlex = "flex"
+
+	roleSourceReaction = "reaction"
+	roleSourceDeclared = "declared"
+	roleSourceNickname = "nickname"
+	roleSourceDefault  = "default"
+)
+
+var (
+	heroesFile = flag.String("discord.heroes_file", "",
+		"JSON file mapping each role (tank, support, dps or flex) to the "+
+			"heroes, and other words, that imply it when found in brackets "+
+			"in a nickname; the built-in table is used if unset")
+
+	// defaultHeroTable is the table of the heroes at launch.
+	defaultHeroTable = map[string][]string{
+		roleTank: {"tank", "d.va", "dva", "rein", "reinhardt", "roadhog",
+			"road", "hog", "wins", "winston", "zarya"},
+		roleDPS: {"dps", "dam", "damage"},
+		roleSupport: {"supp", "support", "heal", "healer", "heals", "healz",
+			"ana", "lucio", "mercy", "zen", "zenyatta"},
+		roleFlex: {"any", "fill", "flex"},
+	}
+
+	hero_table_mtx sync.Mutex
+	hero_table     = mustCompileHeroTable(defaultHeroTable)
+)
+
+// compileHeroTable returns, for each role of the table, a regexp matching
+// any of its words.
+func compileHeroTable(table map[string][]string) (
+	map[string]*regexp.Regexp, error) {
+
+	compiled := make(map[string]*regexp.Regexp)
+	for role, words := range table {
+		role = strings.ToLower(role)
+		if role != roleFlex && parseRole(role) != role {
+			return nil, DiscordError.New("unknown role %q in hero table",
+				role)
+		}
+		quoted := []string{}
+		for _, word := range words {
+			if word = strings.TrimSpace(word); word != "" {
+				quoted = append(quoted, regexp.QuoteMeta(word))
+			}
+		}
+		if len(quoted) == 0 {
+			continue
+		}
+		re, err := regexp.Compile(fmt.Sprintf("(?i:\\b(%s)\\b)",
+			strings.Join(quoted, "|")))
+		if err != nil {
+			return nil, err
+		}
+		compiled[role] = re
+	}
+
+	return compiled, nil
+}
+
+func mustCompileHeroTable(
+	table map[string][]string) map[string]*regexp.Regexp {
+
+	compiled, err := compileHeroTable(table)
+	if err != nil {
+		panic(err)
+	}
+
+	return compiled
+}
+
+// loadHeroTable replaces the hero table with the one in filename.
+func loadHeroTable(filename string) error {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	table := make(map[string][]string)
+	err = json.Unmarshal(bytes, &table)
+	if err != nil {
+		return err
+	}
+	compiled, err := compileHeroTable(table)
+	if err != nil {
+		return err
+	}
+
+	hero_table_mtx.Lock()
+	defer hero_table_mtx.Unlock()
+	hero_table = compiled
+
+	return nil
+}
+
+func heroTable() map[string]*regexp.Regexp {
+	hero_table_mtx.Lock()
+	defer hero_table_mtx.Unlock()
+
+	return hero_table
+}
+
+func (b *bot) roles(cmd Command) (err error) {
+	pieces := strings.SplitN(strings.TrimSpace(cmd.Args()), " ", 2)
+	args := ""
+	if len(pieces) > 1 {
+		args = strings.TrimSpace(pieces[1])
+	}
+
+	switch pieces[0] {
+	case "", "show":
+		return cmd.Reply(b.rolesShow(cmd, args))
+	case "set":
+		return cmd.Reply(b.rolesSet(cmd, args))
+	case "clear", "unset":
+		return cmd.Reply(b.rolesClear(cmd))
+	case "help":
+		return cmd.Reply(rolesHelp())
+	}
+
+	return cmd.Reply("Unhandled roles command: %q", cmd.Args())
+}
+
+func rolesHelp() string {
+	return "Declares the roles you play, for queue listings and balancing " +
+		"teams. Without any, the roles in brackets in your nickname are " +
+		"used, eg `Name [tank/support]`.\n`!roles` -- display your " +
+		"declared roles\n`!roles show @user` -- display a user's declared " +
+		"roles\n`!roles set <tank|support|dps|flex> ...` -- declare the " +
+		"roles you play\n`!roles clear` -- forget your declared roles"
+}
+
+func (b *bot) rolesShow(cmd Command, args string) string {
+	user_id := cmd.Message().Author.ID
+	name := "You have"
+	if args != "" {
+		user := mentionedUser(cmd.Message(), args)
+		if user == nil {
+			return fmt.Sprintf("%q doesn't mention a user.", args)
+		}
+		user_id = user.ID
+		name = fmt.Sprintf("<@!%s> has", user_id)
+	}
+
+	roles, err := b.loadRoles(user_id)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error loading roles: %s", err)
+	}
+	if len(roles) == 0 {
+		return fmt.Sprintf("%s declared no roles. Try `!roles set tank "+
+			"support`.", name)
+	}
+
+	return fmt.Sprintf("%s declared the roles %s.", name,
+		strings.Join(roles, ", "))
+}
+
+func (b *bot) rolesSet(cmd Command, args string) string {
+	fields := strings.FieldsFunc(args, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '/'
+	})
+	if len(fields) == 0 {
+		return "Try `!roles set tank support`."
+	}
+
+	declared := make(map[string]bool)
+	for _, field := range fields {
+		switch strings.ToLower(field) {
+		case roleFlex, "any", "fill":
+			for _, role := range allRoles {
+				declared[role] = true
+			}
+			continue
+		}
+		role := parseRole(field)
+		if role == "" {
+			return fmt.Sprintf("Unknown role %q. Roles are tank, support, "+
+				"dps and flex.", field)
+		}
+		declared[role] = true
+	}
+	roles := []string{}
+	for _, role := range allRoles {
+		if declared[role] {
+			roles = append(roles, role)
+		}
+	}
+
+	err := b.saveRoles(cmd.Message().Author.ID, roles)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error saving roles: %s", err)
+	}
+
+	return fmt.Sprintf("Declared your roles as %s.", strings.Join(roles, ", "))
+}
+
+func (b *bot) rolesClear(cmd Command) string {
+	err := b.saveRoles(cmd.Message().Author.ID, nil)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error saving roles: %s", err)
+	}
+
+	return "Forgot your roles. Those in your nickname will be used instead."
+}
+
+// authorRoleSource returns the author's roles and where they came from. Roles
+// declared by reacting to the queue's status take precedence over those
+// declared with `!roles set`, which take precedence over those in the
+// author's nickname. When there are none of those, the author is assumed to
+// play DPS. q may be nil.
+func (b *bot) authorRoleSource(q *scrimQueue, a Author) (roles []string,
+	source string) {
+
+	if q != nil {
+		if roles := b.declaredRoles(q, a.UserId()); roles != nil {
+			return roles, roleSourceReaction
+		}
+	}
+
+	roles, err := b.loadRoles(a.UserId())
+	if err != nil {
+		logger.Errore(err)
+	} else if len(roles) > 0 {
+		return roles, roleSourceDeclared
+	}
+
+	matched := matchRoles(a.Nick())
+	if matched.hasRole() {
+		return matched.names(), roleSourceNickname
+	}
+
+	return []string{roleDPS}, roleSourceDefault
+}
+
+func describeRoleSource(source string) string {
+	switch source {
+	case roleSourceReaction:
+		return "reacted to the queue's status"
+	case roleSourceDeclared:
+		return "declared with `!roles set`"
+	case roleSourceNickname:
+		return "from nickname"
+	}
+
+	return "default"
+}
+
+// loadRoles returns the roles declared by the user with `!roles set`.
+func (b *bot) loadRoles(user_id string) ([]string, error) {
+	value, err := b.store.Get(b.rolesStoreKey(user_id))
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, nil
+	}
+
+	var roles []string
+	err = json.Unmarshal([]byte(value), &roles)
+	if err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (b *bot) saveRoles(user_id string, roles []string) error {
+	if len(roles) == 0 {
+		return b.store.Set(b.rolesStoreKey(user_id), "")
+	}
+	bytes, err := json.Marshal(roles)
+	if err != nil {
+		return err
+	}
+
+	return b.store.Set(b.rolesStoreKey(user_id), string(bytes))
+}
+
+func (b *bot) rolesStoreKey(user_id string) string {
+	return fmt.Sprintf("%s.%s", bucketRoles, user_id)
+}
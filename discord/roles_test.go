@@ -0,0 +1,104 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRolesCommand(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+
+	test.AssertNil(bot.roles(newTestCommand("roles", "", session, msg)))
+	test.AssertNil(bot.roles(newTestCommand("roles", "set mercy", session,
+		msg)))
+	test.AssertNil(bot.roles(newTestCommand("roles", "set support/tank",
+		session, msg)))
+	test.AssertNil(bot.roles(newTestCommand("roles", "show <@"+testUserId+
+		">", session, msg)))
+	expected := []string{
+		"You have declared no roles. Try `!roles set tank support`.",
+		"Unknown role \"mercy\". Roles are tank, support, dps and flex.",
+		"Declared your roles as tank, support.",
+		"<@!" + testUserId + "> has declared the roles tank, support.",
+	}
+	test.AssertEqual(len(session.replies), len(expected))
+	for idx, reply := range session.replies {
+		test.AssertEqual(reply, expected[idx])
+	}
+
+	// declared roles take precedence over the nickname
+	session.appendMemberNicks("foobar [dps]")
+	test.AssertEqual(bot.queueIdentifyRole(q, newTestCommand("id", "",
+		session, msg)), fmt.Sprintf("Roles of \"foobar [dps]\": DPS: %s, "+
+		"Support: %s (declared with `!roles set`), Tank: %s (declared "+
+		"with `!roles set`)", symbolSaltire, symbolChecked, symbolChecked))
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", testBTag, session,
+		msg)))
+	queueables, err := q.List()
+	test.AssertNil(err)
+	test.AssertEqual(strings.Join(queueables[0].(Author).Roles(), ","),
+		"tank,support")
+
+	test.AssertNil(bot.roles(newTestCommand("roles", "set flex", session,
+		msg)))
+	test.AssertContainsString(session.replies, "Declared your roles as "+
+		"tank, support, dps.")
+	test.AssertNil(bot.roles(newTestCommand("roles", "clear", session,
+		msg)))
+	session.appendMemberNicks("foobar [dps]")
+	test.AssertEqual(bot.queueIdentifyRole(q, newTestCommand("id", "",
+		session, msg)), fmt.Sprintf("Roles of \"foobar [dps]\": DPS: %s "+
+		"(from nickname), Support: %s, Tank: %s", symbolChecked,
+		symbolSaltire, symbolSaltire))
+}
+
+func TestLoadHeroTable(t *testing.T) {
+	test := newBotTest(t)
+	defer func() {
+		hero_table = mustCompileHeroTable(defaultHeroTable)
+	}()
+
+	dir, err := ioutil.TempDir("", "heroes")
+	test.AssertNil(err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "heroes.json")
+
+	test.AssertNil(ioutil.WriteFile(filename, []byte(`{"healer": ["moira"]}`),
+		0644))
+	test.AssertErrorContains(loadHeroTable(filename), DiscordError)
+	test.AssertOnlyTank("foo [zarya]")
+
+	test.AssertNil(ioutil.WriteFile(filename, []byte(`{
+		"tank": ["orisa", "d.va"],
+		"support": ["moira", "symmetra"],
+		"flex": ["flex"]
+	}`), 0644))
+	test.AssertNil(loadHeroTable(filename))
+	test.AssertOnlySupport("foo [Moira]")
+	test.AssertOnlyTank("foo [d.va]")
+	test.AssertOnlyDPS("foo [dva]")
+	test.AssertOnlyDPS("foo [zarya]")
+	test.AssertRoles("foo [orisa/symmetra]", &roles{Tank: true, Support: true})
+	test.AssertFlex("foo [flex]")
+}
@@ -0,0 +1,555 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spacemonkeygo/errors"
+	"xmtp.net/xmtpbot/util"
+)
+
+const (
+	bucketSchedule = "discord.schedule"
+
+	overrideOpen   = "open"
+	overrideClosed = "closed"
+
+	minutesPerDay = 24 * 60
+)
+
+var (
+	scheduleTimezone = flag.String("discord.schedule_timezone", "UTC",
+		"default timezone of queue schedules")
+	InvalidScheduleError = DiscordError.NewClass("invalid schedule",
+		errors.NoCaptureStack())
+
+	scheduleInterval = flag.Duration("discord.schedule_interval",
+		time.Minute, "how often to check whether scheduled queues have "+
+			"opened or closed")
+
+	weekdays = map[string][]time.Weekday{
+		"sun": {time.Sunday},
+		"mon": {time.Monday},
+		"tue": {time.Tuesday},
+		"wed": {time.Wednesday},
+		"thu": {time.Thursday},
+		"fri": {time.Friday},
+		"sat": {time.Saturday},
+		"daily": {time.Sunday, time.Monday, time.Tuesday, time.Wednesday,
+			time.Thursday, time.Friday, time.Saturday},
+		"weekdays": {time.Monday, time.Tuesday, time.Wednesday,
+			time.Thursday, time.Friday},
+		"weekends": {time.Saturday, time.Sunday},
+	}
+)
+
+// queueSchedule records when a queue is open to new entries. Moderators may
+// override the schedule, until its next scheduled opening or closing.
+type queueSchedule struct {
+	GuildId       string           `json:"guild_id"`
+	Name          string           `json:"name"`
+	ChannelId     string           `json:"channel_id"`
+	Timezone      string           `json:"timezone,omitempty"`
+	Windows       []scheduleWindow `json:"windows,omitempty"`
+	Override      string           `json:"override,omitempty"`
+	OverrideUntil time.Time        `json:"override_until,omitempty"`
+	Open          bool             `json:"open"`
+}
+
+// scheduleWindow is a weekly period during which a queue is open. Start and
+// End are minutes after midnight; when End isn't after Start, the window ends
+// on the following day.
+type scheduleWindow struct {
+	Day   time.Weekday `json:"day"`
+	Start int          `json:"start"`
+	End   int          `json:"end"`
+}
+
+func (w scheduleWindow) String() string {
+	return fmt.Sprintf("%s %s-%s", w.Day.String()[:3], formatMinutes(w.Start),
+		formatMinutes(w.End))
+}
+
+func (w scheduleWindow) contains(day time.Weekday, minute int) bool {
+	if w.End > w.Start {
+		return w.Day == day && minute >= w.Start && minute < w.End
+	}
+
+	return (w.Day == day && minute >= w.Start) ||
+		((w.Day+1)%7 == day && minute < w.End)
+}
+
+func (s *queueSchedule) location() *time.Location {
+	tz := s.Timezone
+	if tz == "" {
+		tz = *scheduleTimezone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		logger.Warnf("unknown timezone %q: %v", tz, err)
+		return time.UTC
+	}
+
+	return loc
+}
+
+func (s *queueSchedule) scheduledOpen(at time.Time) bool {
+	if len(s.Windows) == 0 {
+		return true
+	}
+
+	local := at.In(s.location())
+	minute := local.Hour()*60 + local.Minute()
+	for _, w := range s.Windows {
+		if w.contains(local.Weekday(), minute) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// overridden reports whether a moderator's override is in effect.
+func (s *queueSchedule) overridden(at time.Time) bool {
+	return s.Override != "" &&
+		(s.OverrideUntil.IsZero() || at.Before(s.OverrideUntil))
+}
+
+func (s *queueSchedule) isOpen(at time.Time) bool {
+	if s.overridden(at) {
+		return s.Override == overrideOpen
+	}
+
+	return s.scheduledOpen(at)
+}
+
+// nextTransition returns the first time after at when the schedule opens or
+// closes the queue. Returns the zero time if it never does.
+func (s *queueSchedule) nextTransition(at time.Time) time.Time {
+	if len(s.Windows) == 0 {
+		return time.Time{}
+	}
+
+	loc := s.location()
+	local := at.In(loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0,
+		0, loc)
+	boundaries := []time.Time{}
+	for day := -1; day <= 8; day++ {
+		date := midnight.AddDate(0, 0, day)
+		for _, w := range s.Windows {
+			if date.Weekday() != w.Day {
+				continue
+			}
+			end := w.End
+			if end <= w.Start {
+				end += minutesPerDay
+			}
+			boundaries = append(boundaries,
+				date.Add(time.Duration(w.Start)*time.Minute),
+				date.Add(time.Duration(end)*time.Minute))
+		}
+	}
+	sort.Slice(boundaries, func(i, j int) bool {
+		return boundaries[i].Before(boundaries[j])
+	})
+
+	open := s.scheduledOpen(at)
+	for _, boundary := range boundaries {
+		if boundary.After(at) && s.scheduledOpen(boundary) != open {
+			return boundary
+		}
+	}
+
+	return time.Time{}
+}
+
+// nextOpen returns when the queue next opens, or false if it won't open
+// without a moderator's help.
+func (s *queueSchedule) nextOpen(at time.Time) (time.Time, bool) {
+	if s.isOpen(at) {
+		return at, true
+	}
+	if s.overridden(at) {
+		if s.OverrideUntil.IsZero() {
+			return time.Time{}, false
+		}
+		at = s.OverrideUntil
+		if s.scheduledOpen(at) {
+			return at, true
+		}
+	}
+
+	next := s.nextTransition(at)
+
+	return next, !next.IsZero()
+}
+
+func (s *queueSchedule) describe() string {
+	if len(s.Windows) == 0 {
+		return "no schedule"
+	}
+
+	windows := []string{}
+	for _, w := range s.Windows {
+		windows = append(windows, w.String())
+	}
+
+	return fmt.Sprintf("open %s (%s)", strings.Join(windows, ", "),
+		s.location())
+}
+
+// closedMessage explains that the queue is closed, and when it next opens.
+func (b *bot) closedMessage(q *scrimQueue, s *queueSchedule,
+	now time.Time) string {
+
+	msg := fmt.Sprintf("The %s is closed.", q.Title())
+	next, ok := s.nextOpen(now)
+	if ok {
+		msg += fmt.Sprintf(" It next opens %s.",
+			next.In(s.location()).Format("Mon Jan 2 at 15:04 MST"))
+	}
+
+	return msg
+}
+
+// queueOpen reports whether the queue accepts new entries, and if not, a
+// message saying so.
+func (b *bot) queueOpen(q *scrimQueue) (bool, string) {
+	s, err := b.loadSchedule(q)
+	if err != nil {
+		logger.Errore(err)
+		return true, ""
+	}
+
+	now := time.Now()
+	if s.isOpen(now) {
+		return true, ""
+	}
+
+	return false, b.closedMessage(q, s, now)
+}
+
+func (b *bot) queueSetOpen(q *scrimQueue, cmd Command, open bool) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	now := time.Now()
+	var s *queueSchedule
+	err = b.updateSchedule(q, func(schedule *queueSchedule) {
+		s = schedule
+		s.Override = overrideClosed
+		if open {
+			s.Override = overrideOpen
+		}
+		s.OverrideUntil = s.nextTransition(now)
+		if s.ChannelId == "" {
+			s.ChannelId = cmd.Message().ChannelID
+		}
+		s.Open = open
+	})
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error saving the schedule of the %s: %s",
+			q.Title(), err)
+	}
+	action := "close"
+	if open {
+		action = "open"
+	}
+	b.recordQueueEvent(q, action, cmd.Author(), nil, 0, "")
+	b.statusChanged(q)
+
+	until := ""
+	if !s.OverrideUntil.IsZero() {
+		until = fmt.Sprintf(" until %s", s.OverrideUntil.In(
+			s.location()).Format("Mon Jan 2 at 15:04 MST"))
+	}
+	if open {
+		return fmt.Sprintf("The %s is now open%s.", q.Title(), until)
+	}
+
+	return fmt.Sprintf("The %s is now closed%s.", q.Title(), until)
+}
+
+func (b *bot) queueSchedule(q *scrimQueue, cmd Command) string {
+	fields := strings.Fields(cmd.Args())
+	if len(fields) == 0 {
+		s, err := b.loadSchedule(q)
+		if err != nil {
+			logger.Errore(err)
+			return fmt.Sprintf("Error loading the schedule of the %s: %s",
+				q.Title(), err)
+		}
+		now := time.Now()
+		if s.isOpen(now) {
+			return fmt.Sprintf("The %s is open, and has %s.", q.Title(),
+				s.describe())
+		}
+		return fmt.Sprintf("%s It has %s.", b.closedMessage(q, s, now),
+			s.describe())
+	}
+
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	var update func(s *queueSchedule)
+	switch fields[0] {
+	case "add":
+		if len(fields) != 3 {
+			return "Try `!queue schedule add weekdays 19:00-23:00`."
+		}
+		windows, err := parseWindows(fields[1], fields[2])
+		if err != nil {
+			return fmt.Sprintf("%s. Try `!queue schedule add weekdays "+
+				"19:00-23:00`.", util.Capitalize(errors.GetMessage(err)))
+		}
+		update = func(s *queueSchedule) {
+			s.Windows = append(s.Windows, windows...)
+		}
+	case "clear":
+		update = func(s *queueSchedule) {
+			s.Windows = nil
+		}
+	case "timezone", "tz":
+		if len(fields) != 2 {
+			return "Try `!queue schedule timezone America/Denver`."
+		}
+		_, err := time.LoadLocation(fields[1])
+		if err != nil {
+			return fmt.Sprintf("Unknown timezone %q.", fields[1])
+		}
+		update = func(s *queueSchedule) {
+			s.Timezone = fields[1]
+		}
+	default:
+		return fmt.Sprintf("Unhandled schedule command: %q", cmd.Args())
+	}
+
+	var s *queueSchedule
+	err = b.updateSchedule(q, func(schedule *queueSchedule) {
+		s = schedule
+		update(s)
+		s.ChannelId = cmd.Message().ChannelID
+		s.Override = ""
+		s.OverrideUntil = time.Time{}
+		s.Open = s.isOpen(time.Now())
+	})
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error saving the schedule of the %s: %s",
+			q.Title(), err)
+	}
+
+	return fmt.Sprintf("The %s now has %s. Openings and closings will be "+
+		"announced in this channel.", q.Title(), s.describe())
+}
+
+// parseWindows parses days such as "mon", "mon,wed" or "weekdays", and hours
+// such as "19:00-23:00".
+func parseWindows(days, hours string) ([]scheduleWindow, error) {
+	pieces := strings.Split(hours, "-")
+	if len(pieces) != 2 {
+		return nil, InvalidScheduleError.New("hours %q", hours)
+	}
+	start, err := parseMinutes(pieces[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseMinutes(pieces[1])
+	if err != nil {
+		return nil, err
+	}
+
+	windows := []scheduleWindow{}
+	for _, day := range strings.Split(strings.ToLower(days), ",") {
+		if len(day) > 3 && weekdays[day] == nil {
+			day = day[:3]
+		}
+		matched, ok := weekdays[day]
+		if !ok {
+			return nil, InvalidScheduleError.New("day %q", day)
+		}
+		for _, weekday := range matched {
+			windows = append(windows, scheduleWindow{
+				Day:   weekday,
+				Start: start,
+				End:   end,
+			})
+		}
+	}
+
+	return windows, nil
+}
+
+func parseMinutes(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, InvalidScheduleError.New("time %q", clock)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func formatMinutes(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// runScheduler periodically announces scheduled openings and closings, until
+// stop is closed.
+func (b *bot) runScheduler(stop chan struct{}) {
+	ticker := time.NewTicker(*scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			b.checkSchedules(now)
+			b.expireLobbies(now)
+		}
+	}
+}
+
+// checkSchedules announces each queue that has opened or closed since last
+// checked.
+func (b *bot) checkSchedules(now time.Time) {
+	keys := []string{}
+	b.store.Iterate(func(key, value string) {
+		if strings.HasPrefix(key, bucketSchedule+".") {
+			keys = append(keys, key)
+		}
+	})
+
+	for _, key := range keys {
+		value, err := b.store.Get(key)
+		if err != nil || value == "" {
+			logger.Errore(err)
+			continue
+		}
+		s := &queueSchedule{}
+		err = json.Unmarshal([]byte(value), s)
+		if err != nil {
+			logger.Errore(err)
+			continue
+		}
+		q := b.lookupNamedQueue(s.GuildId, s.Name)
+
+		var changed *queueSchedule
+		err = b.updateSchedule(q, func(schedule *queueSchedule) {
+			if schedule.isOpen(now) != schedule.Open {
+				schedule.Open = !schedule.Open
+				changed = schedule
+			}
+		})
+		if err != nil {
+			logger.Errore(err)
+			continue
+		}
+		if changed == nil {
+			continue
+		}
+		b.statusChanged(q)
+		live_session := b.liveSession()
+		if live_session == nil || changed.ChannelId == "" {
+			continue
+		}
+
+		msg := fmt.Sprintf("The %s is now open!", q.Title())
+		if !changed.Open {
+			msg = b.closedMessage(q, changed, now)
+		}
+		_, err = live_session.ChannelMessageSend(changed.ChannelId, msg)
+		logger.Errore(err)
+	}
+}
+
+// updateSchedule applies fn to the queue's schedule, saving it only if fn
+// changed it, as the scheduler checks every schedule each minute.
+func (b *bot) updateSchedule(q *scrimQueue,
+	fn func(s *queueSchedule)) error {
+
+	b.schedule_mtx.Lock()
+	defer b.schedule_mtx.Unlock()
+
+	s, err := b.loadSchedule(q)
+	if err != nil {
+		return err
+	}
+	before, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	fn(s)
+
+	after, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(before, after) {
+		return nil
+	}
+
+	return b.store.Set(b.scheduleStoreKey(q), string(after))
+}
+
+func (b *bot) loadSchedule(q *scrimQueue) (*queueSchedule, error) {
+	s := &queueSchedule{
+		GuildId: q.guild_id,
+		Name:    q.name,
+		Open:    true,
+	}
+
+	value, err := b.store.Get(b.scheduleStoreKey(q))
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return s, nil
+	}
+
+	err = json.Unmarshal([]byte(value), s)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (b *bot) scheduleStoreKey(q *scrimQueue) string {
+	return fmt.Sprintf("%s.%s", bucketSchedule, q.Key())
+}
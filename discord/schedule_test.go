@@ -0,0 +1,166 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"xmtp.net/xmtpbot/store"
+)
+
+func TestScheduleWindows(t *testing.T) {
+	test, _, _ := newQueueTest(t)
+
+	windows, err := parseWindows("fri,sat", "20:00-02:00")
+	test.AssertNil(err)
+	test.AssertEqual(len(windows), 2)
+	_, err = parseWindows("someday", "20:00-02:00")
+	test.Assert(err != nil)
+	_, err = parseWindows("weekdays", "20:00")
+	test.Assert(err != nil)
+
+	s := &queueSchedule{Timezone: "UTC", Windows: windows}
+	// 2017-01-06 is a Friday
+	test.Assert(!s.isOpen(scheduleTime(6, 19, 59)))
+	test.Assert(s.isOpen(scheduleTime(6, 20, 0)))
+	test.Assert(s.isOpen(scheduleTime(7, 1, 59)))
+	test.Assert(!s.isOpen(scheduleTime(7, 2, 0)))
+	test.Assert(s.isOpen(scheduleTime(8, 1, 0)))
+	test.Assert(!s.isOpen(scheduleTime(8, 20, 0)))
+
+	test.AssertEqual(s.nextTransition(scheduleTime(7, 12, 0)),
+		scheduleTime(7, 20, 0))
+	test.AssertEqual(s.nextTransition(scheduleTime(7, 21, 0)),
+		scheduleTime(8, 2, 0))
+	test.AssertEqual(s.nextTransition(scheduleTime(8, 12, 0)),
+		scheduleTime(13, 20, 0))
+}
+
+func TestScheduleOverride(t *testing.T) {
+	test, _, _ := newQueueTest(t)
+
+	s := &queueSchedule{
+		Timezone: "UTC",
+		Windows: []scheduleWindow{
+			{Day: time.Friday, Start: 20 * 60, End: 23 * 60},
+		},
+	}
+	now := scheduleTime(6, 21, 0)
+	s.Override = overrideClosed
+	s.OverrideUntil = s.nextTransition(now)
+	test.Assert(!s.isOpen(now))
+	next, ok := s.nextOpen(now)
+	test.Assert(ok)
+	test.AssertEqual(next, scheduleTime(13, 20, 0))
+	test.Assert(!s.isOpen(scheduleTime(6, 23, 30)))
+
+	s.Windows = nil
+	s.OverrideUntil = time.Time{}
+	_, ok = s.nextOpen(now)
+	test.Assert(!ok)
+}
+
+func TestQueueOpenAndClose(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+
+	test.AssertEqual(bot.queueSetOpen(q, newTestCommand("close", "",
+		session, msg), false), "Permission denied.")
+	session.allowAll()
+	test.AssertEqual(bot.queueSetOpen(q, newTestCommand("close", "",
+		session, msg), false), "The scrimmages queue is now closed.")
+
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", testBTag,
+		session, msg)))
+	test.AssertEqual(q.Size(), 0)
+	test.AssertContainsString(session.replies,
+		"The scrimmages queue is closed.")
+
+	test.AssertEqual(bot.queueSetOpen(q, newTestCommand("open", "",
+		session, msg), true), "The scrimmages queue is now open.")
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", testBTag,
+		session, msg)))
+	test.AssertEqual(q.Size(), 1)
+}
+
+func TestQueueScheduleCommand(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	session.allowAll()
+
+	test.AssertEqual(bot.queueSchedule(q, newTestCommand("schedule", "",
+		session, msg)), "The scrimmages queue is open, and has no "+
+		"schedule.")
+	test.AssertEqual(bot.queueSchedule(q, newTestCommand("schedule",
+		"add mon 25:00-26:00", session, msg)), "Invalid schedule: time \"25:00\". "+
+		"Try `!queue schedule add weekdays 19:00-23:00`.")
+	test.AssertEqual(bot.queueSchedule(q, newTestCommand("schedule",
+		"timezone Mars/Olympus", session, msg)),
+		"Unknown timezone \"Mars/Olympus\".")
+	test.AssertEqual(bot.queueSchedule(q, newTestCommand("schedule",
+		"add mon 20:00-22:00", session, msg)), "The scrimmages queue now "+
+		"has open Mon 20:00-22:00 (UTC). Openings and closings will be "+
+		"announced in this channel.")
+
+	s, err := bot.loadSchedule(q)
+	test.AssertNil(err)
+	// 2017-01-02 is a Monday
+	s.Open = false
+	test.AssertNil(bot.updateSchedule(q, func(schedule *queueSchedule) {
+		*schedule = *s
+	}))
+	bot.live_session = session
+	bot.checkSchedules(scheduleTime(2, 20, 30))
+	test.AssertContainsString(session.replies,
+		"The scrimmages queue is now open!")
+	bot.checkSchedules(scheduleTime(2, 22, 30))
+	last := session.replies[len(session.replies)-1]
+	test.Assert(strings.HasPrefix(last, "The scrimmages queue is closed. "+
+		"It next opens Mon Jan 9 at 20:00 UTC."), last)
+
+	// schedules are only saved when they open or close
+	counting := &countingStore{Simple: bot.store}
+	bot.store = counting
+	bot.checkSchedules(scheduleTime(2, 22, 31))
+	bot.checkSchedules(scheduleTime(2, 22, 32))
+	test.AssertEqual(counting.sets, 0)
+	bot.store = counting.Simple
+
+	test.AssertEqual(bot.queueSchedule(q, newTestCommand("schedule",
+		"clear", session, msg)), "The scrimmages queue now has no "+
+		"schedule. Openings and closings will be announced in this "+
+		"channel.")
+}
+
+func scheduleTime(day, hour, minute int) time.Time {
+	return time.Date(2017, time.January, day, hour, minute, 0, 0, time.UTC)
+}
+
+type countingStore struct {
+	store.Simple
+	sets int
+}
+
+func (s *countingStore) Set(key, value string) error {
+	s.sets++
+
+	return s.Simple.Set(key, value)
+}
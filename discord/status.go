@@ -0,0 +1,389 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	bucketStatus = "discord.status"
+
+	// Discord refuses messages longer than this
+	maxMessageLength = 2000
+
+	// The most entries of a queue listed in its status message
+	maxStatusEntries = 25
+)
+
+var (
+	statusUpdateDelay = flag.Duration("discord.status_update_delay",
+		5*time.Second, "delay between a change to a queue and the edit "+
+			"of its pinned status messages, so that a burst of changes "+
+			"makes a single edit")
+)
+
+func (b *bot) queuePin(q *scrimQueue, cmd Command) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	channel_id := cmd.Message().ChannelID
+	messages, err := b.statusMessages(q)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error loading the status of the %s: %s",
+			q.Title(), err)
+	}
+	if message_id, ok := messages[channel_id]; ok {
+		_, err = cmd.Session().ChannelMessageEdit(channel_id, message_id,
+			b.statusMessage(q))
+		if err == nil {
+			return fmt.Sprintf("The status of the %s is already pinned "+
+				"in this channel.", q.Title())
+		}
+		if !messageGone(err) {
+			logger.Errore(err)
+			return fmt.Sprintf("Error updating the status of the %s: %s",
+				q.Title(), err)
+		}
+		// it was deleted, so pin a new one
+	}
+
+	msg, err := cmd.Session().ChannelMessageSend(channel_id,
+		b.statusMessage(q))
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error posting the status of the %s: %s",
+			q.Title(), err)
+	}
+	err = cmd.Session().ChannelMessagePin(channel_id, msg.ID)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error pinning the status of the %s: %s",
+			q.Title(), err)
+	}
+	if b.queue_reactions {
+		addStatusReactions(cmd.Session(), channel_id, msg.ID)
+	}
+	err = b.updateStatusMessages(q, func(messages map[string]string) {
+		messages[channel_id] = msg.ID
+	})
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error saving the status of the %s: %s",
+			q.Title(), err)
+	}
+
+	return fmt.Sprintf("Pinned the status of the %s, which will be kept "+
+		"up to date.", q.Title())
+}
+
+func (b *bot) queueUnpin(q *scrimQueue, cmd Command) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	channel_id := cmd.Message().ChannelID
+	messages, err := b.statusMessages(q)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error loading the status of the %s: %s",
+			q.Title(), err)
+	}
+	message_id, ok := messages[channel_id]
+	if !ok {
+		return fmt.Sprintf("The status of the %s isn't pinned in this "+
+			"channel.", q.Title())
+	}
+	err = cmd.Session().ChannelMessageUnpin(channel_id, message_id)
+	if err != nil && !messageGone(err) {
+		logger.Errore(err)
+		return fmt.Sprintf("Error unpinning the status of the %s: %s",
+			q.Title(), err)
+	}
+	err = b.updateStatusMessages(q, func(messages map[string]string) {
+		forgetStatusMessage(messages, channel_id, message_id)
+	})
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error saving the status of the %s: %s",
+			q.Title(), err)
+	}
+
+	return fmt.Sprintf("Unpinned the status of the %s.", q.Title())
+}
+
+// forgetStatus unpins the status messages of a deleted queue, noting its
+// deletion in them, and forgets them.
+func (b *bot) forgetStatus(session Session, q *scrimQueue) {
+	messages, err := b.statusMessages(q)
+	if err != nil {
+		logger.Errore(err)
+		return
+	}
+	for channel_id, message_id := range messages {
+		_, err = session.ChannelMessageEdit(channel_id, message_id,
+			fmt.Sprintf("The %s was deleted.", q.Title()))
+		if err != nil && !messageGone(err) {
+			logger.Errore(err)
+		}
+		err = session.ChannelMessageUnpin(channel_id, message_id)
+		if err != nil && !messageGone(err) {
+			logger.Errore(err)
+		}
+	}
+	logger.Errore(b.updateStatusMessages(q,
+		func(current map[string]string) {
+			for channel_id, message_id := range messages {
+				forgetStatusMessage(current, channel_id, message_id)
+			}
+		}))
+}
+
+// statusChanged arranges for the pinned status messages of the queue to be
+// updated. Changes made within the status update delay of the first are
+// collected into a single edit of each message, to stay well within
+// Discord's rate limits.
+func (b *bot) statusChanged(q *scrimQueue) {
+	if b.status_update_delay <= 0 {
+		b.updateStatus(q)
+		return
+	}
+
+	b.status_mtx.Lock()
+	defer b.status_mtx.Unlock()
+
+	key := q.Key()
+	if b.status_pending[key] {
+		return
+	}
+	b.status_pending[key] = true
+	guild_id, name := q.guild_id, q.name
+	time.AfterFunc(b.status_update_delay, func() {
+		b.status_mtx.Lock()
+		delete(b.status_pending, key)
+		b.status_mtx.Unlock()
+
+		b.updateStatus(b.lookupNamedQueue(guild_id, name))
+	})
+}
+
+// updateStatus edits each of the queue's pinned status messages to describe
+// the queue as it is now. The status lock isn't held while editing, so that
+// a slow or rate-limited edit doesn't hold up every other change's.
+func (b *bot) updateStatus(q *scrimQueue) {
+	live_session := b.liveSession()
+	if live_session == nil {
+		return
+	}
+
+	messages, err := b.statusMessages(q)
+	if err != nil {
+		logger.Errore(err)
+		return
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	status := b.statusMessage(q)
+	gone := make(map[string]string)
+	for channel_id, message_id := range messages {
+		_, err = live_session.ChannelMessageEdit(channel_id, message_id,
+			status)
+		if err == nil {
+			continue
+		}
+		if !messageGone(err) {
+			logger.Errore(err)
+			continue
+		}
+		logger.Infof("forgetting deleted status message %s of %s",
+			message_id, q.Key())
+		gone[channel_id] = message_id
+	}
+	if len(gone) == 0 {
+		return
+	}
+	logger.Errore(b.updateStatusMessages(q,
+		func(messages map[string]string) {
+			for channel_id, message_id := range gone {
+				forgetStatusMessage(messages, channel_id, message_id)
+			}
+		}))
+}
+
+// statusMessage describes the queue's state, roster and role counts.
+func (b *bot) statusMessage(q *scrimQueue) string {
+	dq, err := b.describeQueue(q, time.Now())
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error listing the %s: %s", q.Title(), err)
+	}
+
+	lines := []string{}
+	open, closed_msg := b.queueOpen(q)
+	if open {
+		lines = append(lines, fmt.Sprintf("**%s** (open)", dq.Title))
+	} else {
+		lines = append(lines, fmt.Sprintf("**%s** (closed)", dq.Title),
+			closed_msg)
+	}
+
+	size := fmt.Sprintf("%d queued", len(dq.Entries))
+	if dq.Capacity > 0 {
+		size = fmt.Sprintf("%d/%d queued", len(dq.Entries), dq.Capacity)
+	}
+	counts := map[string]int{}
+	for _, entry := range dq.Entries {
+		for _, role := range entry.Roles {
+			counts[role]++
+		}
+	}
+	lines = append(lines, fmt.Sprintf("%s. Tanks: %d, Supports: %d, "+
+		"DPSes: %d", size, counts[roleTank], counts[roleSupport],
+		counts[roleDPS]))
+
+	for idx, entry := range dq.Entries {
+		if idx == maxStatusEntries {
+			lines = append(lines, fmt.Sprintf("...and %d more",
+				len(dq.Entries)-idx))
+			break
+		}
+		line := fmt.Sprintf("%d. %s", entry.Position, entry.BattleTag)
+		if len(entry.Roles) > 0 {
+			line += fmt.Sprintf(" (%s)", strings.Join(entry.Roles, ", "))
+		}
+		lines = append(lines, line)
+	}
+	if len(dq.Waitlist) > 0 {
+		waiting := []string{}
+		for _, entry := range dq.Waitlist {
+			waiting = append(waiting, entry.BattleTag)
+		}
+		lines = append(lines, fmt.Sprintf("Waitlisted (%d): %s",
+			len(dq.Waitlist), strings.Join(waiting, ", ")))
+	}
+
+	return truncateMessage(strings.Join(lines, "\n"), maxMessageLength)
+}
+
+// truncateMessage shortens msg to at most max characters, marking it as
+// shortened. Characters are never split, which Discord would reject.
+func truncateMessage(msg string, max int) string {
+	if utf8.RuneCountInString(msg) <= max {
+		return msg
+	}
+	runes := []rune(msg)
+
+	return string(runes[:max-3]) + "..."
+}
+
+// messageGone reports whether err is Discord's response to a request about a
+// message, or channel, that no longer exists.
+func messageGone(err error) bool {
+	return strings.HasPrefix(err.Error(), "HTTP 404")
+}
+
+// statusMessages returns the ids of the queue's pinned status messages, by
+// channel.
+func (b *bot) statusMessages(q *scrimQueue) (map[string]string, error) {
+	b.status_mtx.Lock()
+	defer b.status_mtx.Unlock()
+
+	return b.loadStatusMessages(q)
+}
+
+// updateStatusMessages applies fn to the ids of the queue's pinned status
+// messages, saving them afterward. fn mustn't make any requests of Discord.
+func (b *bot) updateStatusMessages(q *scrimQueue,
+	fn func(messages map[string]string)) error {
+
+	b.status_mtx.Lock()
+	defer b.status_mtx.Unlock()
+
+	messages, err := b.loadStatusMessages(q)
+	if err != nil {
+		return err
+	}
+	fn(messages)
+
+	return b.saveStatusMessages(q, messages)
+}
+
+// forgetStatusMessage forgets the channel's status message, unless it was
+// replaced by another since message_id was read.
+func forgetStatusMessage(messages map[string]string, channel_id,
+	message_id string) {
+
+	if messages[channel_id] == message_id {
+		delete(messages, channel_id)
+	}
+}
+
+// The caller is responsible for obtaining b.status_mtx before calling
+func (b *bot) loadStatusMessages(q *scrimQueue) (map[string]string, error) {
+	messages := make(map[string]string)
+	value, err := b.store.Get(b.statusStoreKey(q))
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return messages, nil
+	}
+	err = json.Unmarshal([]byte(value), &messages)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// The caller is responsible for obtaining b.status_mtx before calling
+func (b *bot) saveStatusMessages(q *scrimQueue,
+	messages map[string]string) error {
+
+	if len(messages) == 0 {
+		return b.store.Set(b.statusStoreKey(q), "")
+	}
+	bytes, err := json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+
+	return b.store.Set(b.statusStoreKey(q), string(bytes))
+}
+
+func (b *bot) statusStoreKey(q *scrimQueue) string {
+	return fmt.Sprintf("%s.%s", bucketStatus, q.Key())
+}
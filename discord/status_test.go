@@ -0,0 +1,84 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"xmtp.net/xmtpbot/test"
+)
+
+func TestQueuePin(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	bot.live_session = session
+	msg := newTestMessage(testUserId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+
+	test.AssertEqual(bot.queuePin(q, newTestCommand("pin", "", session,
+		msg)), "Permission denied.")
+	session.allowAll()
+	// status messages are posted without holding the status lock
+	session.on_send = func() { bot.statusChanged(q) }
+	test.AssertEqual(bot.queuePin(q, newTestCommand("pin", "", session,
+		msg)), "Pinned the status of the scrimmages queue, which will be "+
+		"kept up to date.")
+	test.AssertEqual(session.replies[0], "**Scrimmages queue** (open)\n"+
+		"0 queued. Tanks: 0, Supports: 0, DPSes: 0")
+	test.Assert(session.pinned["message-1"])
+	session.on_send = nil
+
+	test.AssertNil(bot.enqueue(newTestCommand("enqueue", testBTag,
+		session, msg)))
+	test.AssertEqual(session.edits["message-1"],
+		"**Scrimmages queue** (open)\n"+
+			"1 queued. Tanks: 0, Supports: 0, DPSes: 1\n"+
+			"1. "+testBTag+" (dps)")
+
+	bot.queueSetOpen(q, newTestCommand("close", "", session, msg), false)
+	test.AssertEqual(session.edits["message-1"],
+		"**Scrimmages queue** (closed)\n"+
+			"The scrimmages queue is closed.\n"+
+			"1 queued. Tanks: 0, Supports: 0, DPSes: 1\n"+
+			"1. "+testBTag+" (dps)")
+
+	test.AssertEqual(bot.queuePin(q, newTestCommand("pin", "", session,
+		msg)), "The status of the scrimmages queue is already pinned in "+
+		"this channel.")
+	test.AssertEqual(bot.queueUnpin(q, newTestCommand("unpin", "", session,
+		msg)), "Unpinned the status of the scrimmages queue.")
+	test.Assert(!session.pinned["message-1"])
+	test.AssertEqual(bot.queueUnpin(q, newTestCommand("unpin", "", session,
+		msg)), "The status of the scrimmages queue isn't pinned in this "+
+		"channel.")
+
+	delete(session.edits, "message-1")
+	test.AssertNil(bot.dequeue(newTestCommand("dequeue", "", session, msg)))
+	_, edited := session.edits["message-1"]
+	test.Assert(!edited)
+}
+
+func TestTruncateMessage(t *testing.T) {
+	test := test.New(t)
+
+	test.AssertEqual(truncateMessage("héllo", 5), "héllo")
+	test.AssertEqual(truncateMessage("héllo wörld", 8), "héllo...")
+	truncated := truncateMessage(strings.Repeat("ö", maxMessageLength+1),
+		maxMessageLength)
+	test.Assert(utf8.ValidString(truncated))
+	test.AssertEqual(utf8.RuneCountInString(truncated), maxMessageLength)
+}
@@ -0,0 +1,320 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spacemonkeygo/errors"
+	"xmtp.net/xmtpbot/util"
+)
+
+const (
+	// maxTeamPlayers bounds the exhaustive search of balanceTeams, which
+	// takes a fraction of a second for two teams of six, but grows by more
+	// than an order of magnitude with each additional pair of players.
+	maxTeamPlayers = 12
+)
+
+var (
+	defaultRating = flag.Int("discord.default_rating", 2500,
+		"skill rating assumed for players who haven't set one")
+
+	TeamsError = DiscordError.NewClass("unable to balance teams",
+		errors.NoCaptureStack())
+)
+
+// teamCandidate is a player to be placed on a team, along with their rating
+// in each role they're able to play.
+type teamCandidate struct {
+	user_id string
+	name    string
+	ratings map[string]int
+}
+
+type teamMember struct {
+	user_id string
+	name    string
+	role    string
+	rating  int
+}
+
+type team struct {
+	members []teamMember
+	total   int
+}
+
+func (t *team) average() int {
+	if len(t.members) == 0 {
+		return 0
+	}
+
+	return t.total / len(t.members)
+}
+
+// balanceTeams splits the candidates into two teams of equal size, such that
+// the difference in their average ratings is minimized. Each team is made up
+// of an equal number of tanks, supports and DPSes (the remainder being
+// played by whoever is best suited), unless no split allows that, in which
+// case roles are ignored and composed is false.
+func balanceTeams(candidates []teamCandidate) (teams [2]*team,
+	composed bool, err error) {
+
+	n := len(candidates)
+	if n < 2 || n%2 != 0 {
+		return teams, false, TeamsError.New(
+			"an even number of players is required, not %d", n)
+	}
+	if n > maxTeamPlayers {
+		return teams, false, TeamsError.New(
+			"at most %d players can be balanced, not %d", maxTeamPlayers, n)
+	}
+
+	size := n / 2
+	counts := make(map[string]int)
+	for _, role := range allRoles {
+		counts[role] = size / len(allRoles)
+	}
+	teams, ok := bestSplit(candidates, counts, size%len(allRoles))
+	if ok {
+		return teams, true, nil
+	}
+
+	teams, ok = bestSplit(candidates, map[string]int{}, size)
+	if !ok {
+		return teams, false, TeamsError.New("no split found")
+	}
+
+	return teams, false, nil
+}
+
+// bestSplit tries every split of the candidates into two teams, each having
+// the given number of players per role, plus flex players of any role.
+func bestSplit(candidates []teamCandidate, counts map[string]int,
+	flex int) (best [2]*team, ok bool) {
+
+	size := len(candidates) / 2
+	best_diff := -1
+	in_first := make([]bool, len(candidates))
+
+	var split func(idx, chosen int)
+	split = func(idx, chosen int) {
+		if chosen == size {
+			first, second := []teamCandidate{}, []teamCandidate{}
+			for i, candidate := range candidates {
+				if in_first[i] {
+					first = append(first, candidate)
+				} else {
+					second = append(second, candidate)
+				}
+			}
+			firsts := assignRoles(first, counts, flex)
+			seconds := assignRoles(second, counts, flex)
+			first_totals, second_totals := sortedTotals(firsts),
+				sortedTotals(seconds)
+			// walk both sorted totals together, always advancing the
+			// lesser, to find the closest pair
+			i, j := 0, 0
+			for i < len(first_totals) && j < len(second_totals) {
+				diff := first_totals[i] - second_totals[j]
+				abs := diff
+				if abs < 0 {
+					abs = -abs
+				}
+				if best_diff < 0 || abs < best_diff {
+					best_diff = abs
+					best = [2]*team{firsts[first_totals[i]],
+						seconds[second_totals[j]]}
+				}
+				if diff < 0 {
+					i++
+				} else {
+					j++
+				}
+			}
+			return
+		}
+		if idx >= len(candidates) || len(candidates)-idx < size-chosen {
+			return
+		}
+		in_first[idx] = true
+		split(idx+1, chosen+1)
+		in_first[idx] = false
+		if idx > 0 {
+			// the first candidate is always on the first team, so that
+			// each split is only tried once
+			split(idx+1, chosen)
+		}
+	}
+	split(0, 0)
+
+	return best, best_diff >= 0
+}
+
+// assignRoles returns every achievable rating total of the candidates, when
+// they're assigned the given number of players per role, plus flex players,
+// along with a team achieving each total.
+func assignRoles(candidates []teamCandidate, counts map[string]int,
+	flex int) map[int]*team {
+
+	teams := make(map[int]*team)
+	remaining := make(map[string]int)
+	for role, count := range counts {
+		remaining[role] = count
+	}
+	members := []teamMember{}
+
+	var assign func(idx, total, flex int)
+	assign = func(idx, total, flex int) {
+		if idx == len(candidates) {
+			if _, ok := teams[total]; !ok {
+				teams[total] = &team{
+					members: append([]teamMember{}, members...),
+					total:   total,
+				}
+			}
+			return
+		}
+		candidate := candidates[idx]
+		for _, role := range allRoles {
+			rating, ok := candidate.ratings[role]
+			if !ok || remaining[role] == 0 {
+				continue
+			}
+			remaining[role]--
+			members = append(members, teamMember{
+				user_id: candidate.user_id,
+				name:    candidate.name,
+				role:    role,
+				rating:  rating,
+			})
+			assign(idx+1, total+rating, flex)
+			members = members[:len(members)-1]
+			remaining[role]++
+		}
+		if flex > 0 {
+			role, rating := bestRole(candidate)
+			members = append(members, teamMember{
+				user_id: candidate.user_id,
+				name:    candidate.name,
+				role:    role,
+				rating:  rating,
+			})
+			assign(idx+1, total+rating, flex-1)
+			members = members[:len(members)-1]
+		}
+	}
+	assign(0, 0, flex)
+
+	return teams
+}
+
+func sortedTotals(teams map[int]*team) []int {
+	totals := make([]int, 0, len(teams))
+	for total := range teams {
+		totals = append(totals, total)
+	}
+	sort.Ints(totals)
+
+	return totals
+}
+
+func bestRole(candidate teamCandidate) (best string, rating int) {
+	for _, role := range allRoles {
+		r, ok := candidate.ratings[role]
+		if ok && (best == "" || r > rating) {
+			best, rating = role, r
+		}
+	}
+
+	return best, rating
+}
+
+// teamCandidates looks up the ratings of each of the authors. Authors who
+// haven't set any ratings are assumed to play the roles they were queued with
+// at the default rating, or any role if they have none.
+func (b *bot) teamCandidates(guild_id string,
+	authors []Author) []teamCandidate {
+
+	candidates := []teamCandidate{}
+	for _, a := range authors {
+		candidate := teamCandidate{
+			user_id: a.UserId(),
+			name:    displayName(a),
+			ratings: make(map[string]int),
+		}
+		p, err := b.loadPlayer(guild_id, a.UserId())
+		if err != nil {
+			logger.Errore(err)
+		} else {
+			for role, rating := range p.Ratings {
+				candidate.ratings[role] = rating
+			}
+		}
+		if len(candidate.ratings) == 0 {
+			for _, role := range a.Roles() {
+				candidate.ratings[role] = *defaultRating
+			}
+		}
+		if len(candidate.ratings) == 0 {
+			for _, role := range allRoles {
+				candidate.ratings[role] = *defaultRating
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates
+}
+
+// teamsReport splits the authors into two balanced teams, and describes them.
+// The teams are remembered, so that they can be moved into their voice
+// channels.
+func (b *bot) teamsReport(q *scrimQueue, authors []Author) string {
+	teams, composed, err := balanceTeams(b.teamCandidates(q.guild_id,
+		authors))
+	if err != nil {
+		return fmt.Sprintf("%s.", util.Capitalize(errors.GetMessage(err)))
+	}
+	err = b.saveTeams(q, teams)
+	if err != nil {
+		logger.Errore(err)
+	}
+
+	lines := []string{}
+	for idx, t := range teams {
+		members := []string{}
+		for _, member := range t.members {
+			members = append(members, fmt.Sprintf("%s (%s %d)", member.name,
+				member.role, member.rating))
+		}
+		lines = append(lines, fmt.Sprintf("Team %d (average %d): %s.",
+			idx+1, t.average(), strings.Join(members, ", ")))
+	}
+	spread := teams[0].average() - teams[1].average()
+	if spread < 0 {
+		spread = -spread
+	}
+	lines = append(lines, fmt.Sprintf("Rating spread: %d.", spread))
+	if !composed {
+		lines = append(lines, "There weren't enough players of each role "+
+			"to balance the team compositions, so roles were ignored.")
+	}
+
+	return strings.Join(lines, "\n")
+}
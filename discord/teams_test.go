@@ -0,0 +1,112 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"fmt"
+	"testing"
+
+	"xmtp.net/xmtpbot/test"
+)
+
+func TestBalanceTeams(t *testing.T) {
+	test := test.New(t)
+
+	candidates := []teamCandidate{
+		newCandidate("tank1", roleTank, 3000),
+		newCandidate("tank2", roleTank, 2000),
+		newCandidate("supp1", roleSupport, 2800),
+		newCandidate("supp2", roleSupport, 2600),
+		newCandidate("dps1", roleDPS, 3200),
+		newCandidate("dps2", roleDPS, 2200),
+	}
+	teams, composed, err := balanceTeams(candidates)
+	test.AssertNil(err)
+	test.Assert(composed)
+	for _, t := range teams {
+		roles := make(map[string]int)
+		for _, member := range t.members {
+			roles[member.role]++
+		}
+		test.AssertEqual(roles[roleTank], 1)
+		test.AssertEqual(roles[roleSupport], 1)
+		test.AssertEqual(roles[roleDPS], 1)
+	}
+	// no composed split comes closer than 7800 vs 8000
+	diff := teams[0].total - teams[1].total
+	test.Assert(diff == 200 || diff == -200)
+}
+
+func TestBalanceTeamsIgnoresRolesWhenImpossible(t *testing.T) {
+	test := test.New(t)
+
+	candidates := []teamCandidate{
+		newCandidate("tank1", roleTank, 3000),
+		newCandidate("tank2", roleTank, 2000),
+		newCandidate("tank3", roleTank, 2500),
+		newCandidate("tank4", roleTank, 2500),
+		newCandidate("tank5", roleTank, 2400),
+		newCandidate("tank6", roleTank, 2600),
+	}
+	teams, composed, err := balanceTeams(candidates)
+	test.AssertNil(err)
+	test.Assert(!composed)
+	test.AssertEqual(teams[0].total, teams[1].total)
+}
+
+func TestBalanceTeamsRequiresEvenPlayers(t *testing.T) {
+	test := test.New(t)
+
+	_, _, err := balanceTeams([]teamCandidate{
+		newCandidate("tank1", roleTank, 3000),
+	})
+	test.AssertErrorContains(err, TeamsError)
+
+	too_many := []teamCandidate{}
+	for i := 0; i < maxTeamPlayers+2; i++ {
+		too_many = append(too_many, newCandidate("dps", roleDPS, 2500))
+	}
+	_, _, err = balanceTeams(too_many)
+	test.AssertErrorContains(err, TeamsError)
+}
+
+func TestBalanceTeamsOfMostPlayers(t *testing.T) {
+	test := test.New(t)
+
+	// players of every role make for the largest search
+	candidates := []teamCandidate{}
+	for i := 0; i < maxTeamPlayers; i++ {
+		candidates = append(candidates, teamCandidate{
+			name: fmt.Sprintf("player%d", i),
+			ratings: map[string]int{
+				roleTank:    1500 + i*137%1000,
+				roleSupport: 2000 + i*211%900,
+				roleDPS:     1800 + i*97%1300,
+			},
+		})
+	}
+	teams, composed, err := balanceTeams(candidates)
+	test.AssertNil(err)
+	test.Assert(composed)
+	test.AssertEqual(len(teams[0].members), maxTeamPlayers/2)
+	test.AssertEqual(teams[0].total, teams[1].total)
+}
+
+func newCandidate(name, role string, rating int) teamCandidate {
+	return teamCandidate{
+		name:    name,
+		ratings: map[string]int{role: rating},
+	}
+}
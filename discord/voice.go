@@ -0,0 +1,458 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"xmtp.net/xmtpbot/util"
+)
+
+const (
+	bucketTeams = "discord.teams"
+	bucketVoice = "discord.voice"
+
+	channelTypeVoice = "voice"
+)
+
+// voiceChannels are the voice channels configured for a queue's teams, and
+// the lobby they return to afterwards.
+type voiceChannels struct {
+	Lobby string    `json:"lobby"`
+	Teams [2]string `json:"teams"`
+}
+
+type teamPlayer struct {
+	UserId string `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// teamAssignment records the teams last formed from a queue, and the
+// temporary voice channels created for them.
+type teamAssignment struct {
+	Teams     [2][]teamPlayer `json:"teams"`
+	Temporary []string        `json:"temporary,omitempty"`
+}
+
+func (b *bot) queueVoice(q *scrimQueue, cmd Command) string {
+	args := strings.Fields(cmd.Args())
+	if len(args) == 0 {
+		return b.describeVoiceChannels(q, cmd)
+	}
+
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	if len(args) < 2 {
+		return "Try `!queue voice team1 <channel>`, or " +
+			"`!queue voice lobby off`."
+	}
+	channel_id := ""
+	if strings.ToLower(args[1]) != "off" {
+		channel_id, err = findVoiceChannel(cmd.Session(), q.guild_id,
+			strings.Join(args[1:], " "))
+		if err != nil {
+			logger.Errore(err)
+			return fmt.Sprintf("Error looking up voice channels: %s", err)
+		}
+		if channel_id == "" {
+			return fmt.Sprintf("No voice channel named %q was found.",
+				strings.Join(args[1:], " "))
+		}
+	}
+
+	channels, err := b.loadVoiceChannels(q)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error loading the voice channels of the %s: %s",
+			q.Title(), err)
+	}
+	target := strings.ToLower(args[0])
+	switch target {
+	case "lobby":
+		channels.Lobby = channel_id
+	case "team1", "1":
+		channels.Teams[0] = channel_id
+	case "team2", "2":
+		channels.Teams[1] = channel_id
+	default:
+		return fmt.Sprintf("Invalid voice channel %q. Try `lobby`, "+
+			"`team1` or `team2`.", args[0])
+	}
+	err = b.saveVoiceChannels(q, channels)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error saving the voice channels of the %s: %s",
+			q.Title(), err)
+	}
+
+	if channel_id == "" {
+		return fmt.Sprintf("Unset the %s voice channel of the %s.", target,
+			q.Title())
+	}
+	return fmt.Sprintf("Set the %s voice channel of the %s to %s.", target,
+		q.Title(), voiceChannelName(cmd.Session(), q.guild_id, channel_id))
+}
+
+func (b *bot) describeVoiceChannels(q *scrimQueue, cmd Command) string {
+	channels, err := b.loadVoiceChannels(q)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error loading the voice channels of the %s: %s",
+			q.Title(), err)
+	}
+
+	describe := func(channel_id string) string {
+		if channel_id == "" {
+			return "none"
+		}
+		return voiceChannelName(cmd.Session(), q.guild_id, channel_id)
+	}
+	return fmt.Sprintf("Voice channels of the %s. Lobby: %s, team 1: %s, "+
+		"team 2: %s.", q.Title(), describe(channels.Lobby),
+		describe(channels.Teams[0]), describe(channels.Teams[1]))
+}
+
+// queueSplit moves the players of the queue's last teams into their teams'
+// voice channels, creating temporary ones for teams that have none.
+func (b *bot) queueSplit(q *scrimQueue, cmd Command) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	assignment, err := b.loadTeams(q)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error loading the teams of the %s: %s",
+			q.Title(), err)
+	}
+	if assignment == nil {
+		return fmt.Sprintf("No teams have been picked from the %s. Try "+
+			"`!queue pick teams <n>`.", q.Title())
+	}
+	channels, err := b.loadVoiceChannels(q)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error loading the voice channels of the %s: %s",
+			q.Title(), err)
+	}
+	in_voice, err := voiceChannelsByUser(cmd.Session(), q.guild_id)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error looking up voice states: %s", err)
+	}
+
+	moved := 0
+	absent := []string{}
+	for idx, players := range assignment.Teams {
+		channel_id := channels.Teams[idx]
+		if channel_id == "" && idx < len(assignment.Temporary) {
+			channel_id = assignment.Temporary[idx]
+		}
+		if channel_id == "" {
+			ch, err := cmd.Session().GuildChannelCreate(q.guild_id,
+				fmt.Sprintf("%s team %d", util.Capitalize(q.Title()),
+					idx+1),
+				channelTypeVoice)
+			if err != nil {
+				logger.Errore(err)
+				return fmt.Sprintf("Error creating a voice channel for "+
+					"team %d: %s", idx+1, err)
+			}
+			channel_id = ch.ID
+			for len(assignment.Temporary) <= idx {
+				assignment.Temporary = append(assignment.Temporary, "")
+			}
+			assignment.Temporary[idx] = channel_id
+			err = b.saveTeamAssignment(q, assignment)
+			if err != nil {
+				logger.Errore(err)
+			}
+		}
+
+		for _, player := range players {
+			if in_voice[player.UserId] == "" {
+				absent = append(absent, player.Name)
+				continue
+			}
+			err = cmd.Session().GuildMemberMove(q.guild_id, player.UserId,
+				channel_id)
+			if err != nil {
+				logger.Errore(err)
+				return fmt.Sprintf("Error moving %s: %s", player.Name, err)
+			}
+			moved++
+		}
+	}
+
+	msg := fmt.Sprintf("Moved %d players of the %s's teams into their "+
+		"voice channels.", moved, q.Title())
+	if len(absent) > 0 {
+		msg += fmt.Sprintf(" Not in a voice channel: %s.",
+			strings.Join(absent, ", "))
+	}
+
+	return msg
+}
+
+// queueRegroup moves everyone in the voice channels of the queue's teams
+// back to its lobby voice channel, and deletes any temporary team channels.
+func (b *bot) queueRegroup(q *scrimQueue, cmd Command) string {
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	channels, err := b.loadVoiceChannels(q)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error loading the voice channels of the %s: %s",
+			q.Title(), err)
+	}
+	if channels.Lobby == "" {
+		return fmt.Sprintf("The %s has no lobby voice channel. Try "+
+			"`!queue voice lobby <channel>`.", q.Title())
+	}
+	assignment, err := b.loadTeams(q)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error loading the teams of the %s: %s",
+			q.Title(), err)
+	}
+
+	team_channels := make(map[string]bool)
+	for _, channel_id := range channels.Teams {
+		if channel_id != "" {
+			team_channels[channel_id] = true
+		}
+	}
+	var temporary []string
+	if assignment != nil {
+		temporary = assignment.Temporary
+	}
+	for _, channel_id := range temporary {
+		if channel_id != "" {
+			team_channels[channel_id] = true
+		}
+	}
+	if len(team_channels) == 0 {
+		return fmt.Sprintf("The %s's teams have no voice channels.",
+			q.Title())
+	}
+
+	in_voice, err := voiceChannelsByUser(cmd.Session(), q.guild_id)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error looking up voice states: %s", err)
+	}
+	moved := 0
+	for user_id, channel_id := range in_voice {
+		if !team_channels[channel_id] {
+			continue
+		}
+		err = cmd.Session().GuildMemberMove(q.guild_id, user_id,
+			channels.Lobby)
+		if err != nil {
+			logger.Errore(err)
+			return fmt.Sprintf("Error moving <@!%s>: %s", user_id, err)
+		}
+		moved++
+	}
+
+	for _, channel_id := range temporary {
+		if channel_id == "" {
+			continue
+		}
+		_, err = cmd.Session().ChannelDelete(channel_id)
+		if err != nil && !messageGone(err) {
+			logger.Errore(err)
+		}
+	}
+	if len(temporary) > 0 {
+		assignment.Temporary = nil
+		err = b.saveTeamAssignment(q, assignment)
+		if err != nil {
+			logger.Errore(err)
+		}
+	}
+
+	return fmt.Sprintf("Moved %d players back to %s.", moved,
+		voiceChannelName(cmd.Session(), q.guild_id, channels.Lobby))
+}
+
+// findVoiceChannel returns the id of the guild's voice channel with the
+// given name, mention or id, or "" if there's none.
+func findVoiceChannel(session Session, guild_id, name string) (string,
+	error) {
+
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "<#"), ">")
+	channels, err := session.GuildChannels(guild_id)
+	if err != nil {
+		return "", err
+	}
+	for _, ch := range channels {
+		if ch.Type != channelTypeVoice {
+			continue
+		}
+		if ch.ID == name || strings.EqualFold(ch.Name, name) {
+			return ch.ID, nil
+		}
+	}
+
+	return "", nil
+}
+
+func voiceChannelName(session Session, guild_id, channel_id string) string {
+	channels, err := session.GuildChannels(guild_id)
+	if err != nil {
+		logger.Errore(err)
+		return channel_id
+	}
+	for _, ch := range channels {
+		if ch.ID == channel_id {
+			return ch.Name
+		}
+	}
+
+	return channel_id
+}
+
+// voiceChannelsByUser maps the id of each of the guild's members who are in a
+// voice channel to the id of that channel.
+func voiceChannelsByUser(session Session, guild_id string) (
+	map[string]string, error) {
+
+	states, err := session.VoiceStates(guild_id)
+	if err != nil {
+		return nil, err
+	}
+	in_voice := make(map[string]string)
+	for _, state := range states {
+		if state.ChannelID != "" {
+			in_voice[state.UserID] = state.ChannelID
+		}
+	}
+
+	return in_voice, nil
+}
+
+// saveTeams remembers the teams formed from the queue, replacing the last
+// ones. Any temporary voice channels of the last teams are kept, to be
+// reused.
+func (b *bot) saveTeams(q *scrimQueue, teams [2]*team) error {
+	assignment, err := b.loadTeams(q)
+	if err != nil {
+		return err
+	}
+	if assignment == nil {
+		assignment = &teamAssignment{}
+	}
+	for idx, t := range teams {
+		assignment.Teams[idx] = nil
+		for _, member := range t.members {
+			assignment.Teams[idx] = append(assignment.Teams[idx], teamPlayer{
+				UserId: member.user_id,
+				Name:   member.name,
+			})
+		}
+	}
+
+	return b.saveTeamAssignment(q, assignment)
+}
+
+func (b *bot) saveTeamAssignment(q *scrimQueue,
+	assignment *teamAssignment) error {
+
+	bytes, err := json.Marshal(assignment)
+	if err != nil {
+		return err
+	}
+
+	return b.store.Set(b.teamsStoreKey(q), string(bytes))
+}
+
+// loadTeams returns the teams last formed from the queue, or nil if there
+// are none.
+func (b *bot) loadTeams(q *scrimQueue) (*teamAssignment, error) {
+	value, err := b.store.Get(b.teamsStoreKey(q))
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, nil
+	}
+
+	assignment := &teamAssignment{}
+	err = json.Unmarshal([]byte(value), assignment)
+	if err != nil {
+		return nil, err
+	}
+
+	return assignment, nil
+}
+
+func (b *bot) loadVoiceChannels(q *scrimQueue) (*voiceChannels, error) {
+	channels := &voiceChannels{}
+	value, err := b.store.Get(b.voiceStoreKey(q))
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return channels, nil
+	}
+	err = json.Unmarshal([]byte(value), channels)
+	if err != nil {
+		return nil, err
+	}
+
+	return channels, nil
+}
+
+func (b *bot) saveVoiceChannels(q *scrimQueue, channels *voiceChannels) error {
+	bytes, err := json.Marshal(channels)
+	if err != nil {
+		return err
+	}
+
+	return b.store.Set(b.voiceStoreKey(q), string(bytes))
+}
+
+func (b *bot) teamsStoreKey(q *scrimQueue) string {
+	return fmt.Sprintf("%s.%s", bucketTeams, q.Key())
+}
+
+func (b *bot) voiceStoreKey(q *scrimQueue) string {
+	return fmt.Sprintf("%s.%s", bucketVoice, q.Key())
+}
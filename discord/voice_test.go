@@ -0,0 +1,86 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"testing"
+
+	"github.com/ewollesen/discordgo"
+)
+
+func TestQueueSplitAndRegroup(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	msg := newTestMessage(testModId, testChannelId)
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	session.channels = []*discordgo.Channel{
+		{ID: "lobby-vc", Name: "Lobby", Type: channelTypeVoice},
+		{ID: "red-vc", Name: "Red Team", Type: channelTypeVoice},
+		{ID: "general", Name: "general", Type: "text"},
+	}
+
+	test.AssertEqual(bot.queueVoice(q, newTestCommand("voice", "lobby Lobby",
+		session, msg)), "Permission denied.")
+	session.allowAll()
+	test.AssertEqual(bot.queueSplit(q, newTestCommand("split", "", session,
+		msg)), "No teams have been picked from the scrimmages queue. Try "+
+		"`!queue pick teams <n>`.")
+	test.AssertEqual(bot.queueVoice(q, newTestCommand("voice", "lobby lobby",
+		session, msg)), "Set the lobby voice channel of the scrimmages "+
+		"queue to Lobby.")
+	test.AssertEqual(bot.queueVoice(q, newTestCommand("voice",
+		"team1 red team", session, msg)), "Set the team1 voice channel of "+
+		"the scrimmages queue to Red Team.")
+	test.AssertEqual(bot.queueVoice(q, newTestCommand("voice",
+		"team2 general", session, msg)), "No voice channel named "+
+		"\"general\" was found.")
+	test.AssertEqual(bot.queueVoice(q, newTestCommand("voice", "", session,
+		msg)), "Voice channels of the scrimmages queue. Lobby: Lobby, "+
+		"team 1: Red Team, team 2: none.")
+
+	test.AssertNil(bot.updatePlayer(testGuildId, testUserId, func(p *player) {
+		p.Ratings[roleTank] = 3000
+	}))
+	test.AssertNil(bot.updatePlayer(testGuildId, testUserId2,
+		func(p *player) {
+			p.Ratings[roleTank] = 2000
+		}))
+	q.Enqueue(newTestAuthor(testUserId, testBTag))
+	q.Enqueue(newTestAuthor(testUserId2, testBTag2))
+	bot.queueTake(q, newTestCommand("take", "teams 2", session, msg))
+
+	session.voice[testUserId] = "lobby-vc"
+	test.AssertEqual(bot.queueSplit(q, newTestCommand("split", "", session,
+		msg)), "Moved 1 players of the scrimmages queue's teams into "+
+		"their voice channels. Not in a voice channel: "+testBTag2+".")
+	test.AssertEqual(session.voice[testUserId], "red-vc")
+	test.AssertEqual(len(session.created), 1)
+	test.AssertEqual(session.created[0], "Scrimmages queue team 2")
+
+	session.voice[testUserId2] = "lobby-vc"
+	test.AssertEqual(bot.queueSplit(q, newTestCommand("split", "", session,
+		msg)), "Moved 2 players of the scrimmages queue's teams into "+
+		"their voice channels.")
+	test.AssertEqual(session.voice[testUserId2], "channel-1")
+	test.AssertEqual(len(session.created), 1)
+
+	session.voice[testUserId3] = "general-vc"
+	test.AssertEqual(bot.queueRegroup(q, newTestCommand("regroup", "",
+		session, msg)), "Moved 2 players back to Lobby.")
+	test.AssertEqual(session.voice[testUserId], "lobby-vc")
+	test.AssertEqual(session.voice[testUserId2], "lobby-vc")
+	test.AssertEqual(session.voice[testUserId3], "general-vc")
+	test.Assert(session.deleted["channel-1"])
+}
@@ -0,0 +1,142 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"xmtp.net/xmtpbot/queue"
+)
+
+// cachedWaitlist is the WaitlistQueue of a queue, along with the active
+// queue it was made for.
+type cachedWaitlist struct {
+	queue.WaitlistQueue
+	active queue.Queue
+}
+
+// waitlistQueue returns the queue of the given capacity made of active and
+// the queue's waitlist. Every lookup of the queue shares it, so that its
+// capacity is enforced among them all.
+func (b *bot) waitlistQueue(key string, active queue.Queue,
+	capacity int) queue.WaitlistQueue {
+
+	b.waitlist_mtx.Lock()
+	defer b.waitlist_mtx.Unlock()
+
+	wq, ok := b.waitlist_queues[key]
+	if !ok || wq.active != active || wq.Capacity() != capacity {
+		wq = &cachedWaitlist{
+			WaitlistQueue: queue.NewWaitlist(active,
+				b.sessionLookup(waitlistKey(key)), capacity),
+			active: active,
+		}
+		b.waitlist_queues[key] = wq
+	}
+
+	return wq.WaitlistQueue
+}
+
+func (b *bot) forgetWaitlistQueue(key string) {
+	b.waitlist_mtx.Lock()
+	defer b.waitlist_mtx.Unlock()
+
+	delete(b.waitlist_queues, key)
+}
+
+// promoteWaitlisted fills any open spots in the queue from its waitlist, and
+// sends each promoted user a DM.
+func (b *bot) promoteWaitlisted(session Session, q *scrimQueue) {
+	if q.waitlist == nil {
+		return
+	}
+
+	promoted, err := q.waitlist.Promote()
+	if err != nil {
+		logger.Errore(err)
+	}
+	for _, queueable := range promoted {
+		a := queueable.(Author)
+		pos := q.Position(a.Key())
+		b.recordQueueEvent(q, "promote", a, a, pos, "from waitlist")
+		if session == nil {
+			continue
+		}
+		err := sendDM(session, a.UserId(), fmt.Sprintf("A spot opened up "+
+			"in the %s! You've been promoted from the waitlist to "+
+			"position %d.", q.Title(), pos))
+		if err != nil {
+			logger.Warnf("error notifying %s: %v", a.UserId(), err)
+		}
+	}
+}
+
+func (b *bot) queueCapacity(q *scrimQueue, cmd Command) string {
+	arg := strings.ToLower(strings.TrimSpace(cmd.Args()))
+	if arg == "" {
+		if q.waitlist == nil {
+			return fmt.Sprintf("The %s has no capacity limit.", q.Title())
+		}
+		return fmt.Sprintf("The %s holds at most %d users, with %d "+
+			"waitlisted.", q.Title(), q.waitlist.Capacity(),
+			q.waitlist.Waitlist().Size())
+	}
+
+	ok, err := userAuthorized(cmd)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error authorizing %s: %s",
+			cmd.Author().Nick(), err)
+	}
+	if !ok {
+		return "Permission denied."
+	}
+
+	capacity := 0
+	if arg != "off" && arg != "none" {
+		capacity, err = strconv.Atoi(arg)
+		if err != nil || capacity < 1 {
+			return fmt.Sprintf("Invalid capacity %q. Try `!queue "+
+				"capacity 12` or `!queue capacity off`.", arg)
+		}
+	}
+
+	err = b.queue_registry.SetCapacity(q.guild_id, q.name, capacity)
+	if err != nil {
+		logger.Errore(err)
+		return fmt.Sprintf("Error setting the capacity of the %s: %s",
+			q.Title(), err)
+	}
+	b.recordQueueEvent(q, "capacity", cmd.Author(), nil, 0, arg)
+
+	// raising (or removing) the limit may make room for waitlisted users
+	q = b.lookupNamedQueue(q.guild_id, q.name)
+	b.watchQueue(q)
+	if capacity == 0 {
+		q.waitlist = queue.NewWaitlist(q.Queue,
+			b.sessionLookup(waitlistKey(q.Key())), 0)
+	}
+	b.queueChanged(cmd.Session(), q)
+
+	if capacity == 0 {
+		return fmt.Sprintf("The %s no longer has a capacity limit.",
+			q.Title())
+	}
+
+	return fmt.Sprintf("The %s now holds at most %d users.", q.Title(),
+		capacity)
+}
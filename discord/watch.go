@@ -0,0 +1,145 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"github.com/ewollesen/discordgo"
+)
+
+func (b *bot) guildCreateHandler(s *discordgo.Session,
+	e *discordgo.GuildCreate) {
+
+	if e.Unavailable != nil && *e.Unavailable {
+		return
+	}
+	b.watchGuild(e.ID)
+}
+
+// watchGuild watches the guild's default queue, and each queue registered
+// for it. The bot only watches the queues of the guilds it's in, as each
+// watch holds a subscription open.
+func (b *bot) watchGuild(guild_id string) {
+	names, err := b.queue_registry.Names(guild_id)
+	if err != nil {
+		logger.Errore(err)
+		return
+	}
+	for _, name := range append([]string{""}, names...) {
+		b.watchQueue(b.lookupNamedQueue(guild_id, name))
+	}
+}
+
+// unwatchGuild stops watching the guild's queues.
+func (b *bot) unwatchGuild(guild_id string) {
+	names, err := b.queue_registry.Names(guild_id)
+	if err != nil {
+		logger.Errore(err)
+	}
+	for _, name := range append([]string{""}, names...) {
+		b.unwatchQueue(queueKey(guild_id, name))
+	}
+}
+
+// watchQueue subscribes to the changes made to the registered queue, and to
+// its waitlist, refreshing the queue's views upon each of them. Queues
+// already being watched are left alone.
+func (b *bot) watchQueue(q *scrimQueue) {
+	if !b.watch_queues {
+		return
+	}
+	if q.name != "" && !b.queue_registry.Exists(q.guild_id, q.name) {
+		return
+	}
+
+	key := q.Key()
+	b.watch(key, q.guild_id, q.name)
+	if q.waitlist != nil {
+		b.watch(waitlistKey(key), q.guild_id, q.name)
+	}
+}
+
+func (b *bot) watch(key, guild_id, name string) {
+	b.watches_mtx.Lock()
+	defer b.watches_mtx.Unlock()
+
+	if _, ok := b.watches[key]; ok {
+		return
+	}
+	sub, err := b.queues.Lookup(key).Subscribe()
+	if err != nil {
+		logger.Warnf("not watching queue %s: %v", key, err)
+		return
+	}
+	b.watches[key] = sub
+
+	go func() {
+		for range sub.Events() {
+			// a burst of changes calls for a single refresh
+			for drained := false; !drained; {
+				select {
+				case _, ok := <-sub.Events():
+					drained = !ok
+				default:
+					drained = true
+				}
+			}
+			if name != "" && !b.queue_registry.Exists(guild_id, name) {
+				continue
+			}
+			b.refreshQueueViews(b.lookupNamedQueue(guild_id, name))
+		}
+	}()
+}
+
+// watchingQueue reports whether the views of the queue are refreshed upon
+// its changes.
+func (b *bot) watchingQueue(q *scrimQueue) bool {
+	b.watches_mtx.Lock()
+	defer b.watches_mtx.Unlock()
+
+	if _, ok := b.watches[q.Key()]; !ok {
+		return false
+	}
+	if q.waitlist == nil {
+		return true
+	}
+	_, ok := b.watches[waitlistKey(q.Key())]
+
+	return ok
+}
+
+// unwatchQueue stops watching the queue with the given key, and its
+// waitlist.
+func (b *bot) unwatchQueue(key string) {
+	b.watches_mtx.Lock()
+	defer b.watches_mtx.Unlock()
+
+	for _, watched := range []string{key, waitlistKey(key)} {
+		if sub, ok := b.watches[watched]; ok {
+			logger.Errore(sub.Close())
+			delete(b.watches, watched)
+		}
+	}
+}
+
+func (b *bot) unwatchQueues() {
+	b.watches_mtx.Lock()
+	defer b.watches_mtx.Unlock()
+
+	for key, sub := range b.watches {
+		logger.Errore(sub.Close())
+		delete(b.watches, key)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discord
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchQueue(t *testing.T) {
+	test, bot, session := newQueueTest(t)
+	bot.watch_queues = true
+	defer bot.unwatchQueues()
+	q, err := bot.lookupQueue(testChannelId, session)
+	test.AssertNil(err)
+	// looking queues up doesn't watch them, only the bot's guilds are
+	test.Assert(!bot.watchingQueue(q))
+	test.Assert(!bot.watchingQueue(bot.lookupNamedQueue(testGuildId,
+		"nope")))
+	bot.watchGuild(testGuildId)
+	test.Assert(bot.watchingQueue(q))
+	test.AssertEqual(len(bot.watches), 1)
+
+	// changes made elsewhere, eg by another process sharing the queue,
+	// refresh its views all the same
+	wakeup := bot.subscribeDashboard(testGuildId)
+	test.AssertNil(bot.queues.Lookup(q.Key()).Enqueue(
+		newTestAuthor(testUserId, testBTag)))
+	select {
+	case <-wakeup:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dashboard wasn't refreshed")
+	}
+
+	bot.unwatchQueue(q.Key())
+	test.Assert(!bot.watchingQueue(q))
+}
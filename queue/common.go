@@ -34,9 +34,19 @@ type Queue interface {
 	// Enqueue the given Queueable
 	Enqueue(queueable Queueable) error
 
+	// Insert the given Queueable at the (1-indexed) position
+	//
+	// Positions beyond the end of the Queue append the Queueable.
+	Insert(pos int, queueable Queueable) error
+
 	// Return all Queueables in the Queue
 	List() ([]Queueable, error)
 
+	// Move the Queueable with a matching key to the (1-indexed) position
+	//
+	// Positions beyond the end of the Queue move the Queueable to the end.
+	Move(key string, pos int) error
+
 	// Return the (1-indexed) position of the Queueable in the Queue
 	//
 	// Returns -1 if the key isn't found in the Queue.
@@ -47,6 +57,18 @@ type Queue interface {
 
 	// Return the size of the Queue
 	Size() int
+
+	// Subscribe to the changes made to the Queue
+	Subscribe() (Subscription, error)
+
+	// Exchange the positions of the Queueables with the matching keys
+	Swap(key1, key2 string) error
+
+	// Apply fn to the Queueable with a matching key, which keeps its
+	// position, as a single change
+	//
+	// The Queueable is stored as fn leaves it, unless fn returns an error.
+	Update(key string, fn func(queueable Queueable) error) error
 }
 
 type Queueable interface {
@@ -55,5 +77,88 @@ type Queueable interface {
 }
 
 type Manager interface {
-	Lookup(channel_key string) Queue
+	// Remove the Queue with the given key, discarding its contents
+	Delete(key string) error
+
+	// Return the Queue with the given key, creating it if necessary
+	Lookup(key string) Queue
+}
+
+// LegacyMigrator is implemented by Managers whose storage predates keyed
+// Queues, when a single Queue was shared by every key.
+type LegacyMigrator interface {
+	// Move the legacy Queue's contents, if any, to the Queue with the given
+	// key, unless that Queue already holds entries
+	//
+	// Returns whether there were contents to move.
+	MigrateLegacy(key string) (bool, error)
+}
+
+// Lister is implemented by Managers able to enumerate the Queues they hold.
+type Lister interface {
+	// Return the keys of the Queues held
+	Keys() ([]string, error)
+}
+
+// storedEntry pairs a Queueable with the raw value it was stored as, so that
+// it can be written back unchanged.
+type storedEntry struct {
+	Queueable
+	raw string
+}
+
+// remarshal rewrites the entry's raw value from its Queueable.
+func (e *storedEntry) remarshal(marshaler Marshaler) error {
+	bytes, err := marshaler.Marshal(e.Queueable)
+	if err != nil {
+		return err
+	}
+	e.raw = string(bytes)
+
+	return nil
+}
+
+// updateStored applies fn to the *storedEntry with a matching key, and
+// remarshals it, returning entries along with the resulting Updated event.
+func updateStored(marshaler Marshaler, entries []Queueable, key string,
+	fn func(queueable Queueable) error) ([]Queueable, []Event, error) {
+
+	idx := indexOf(entries, key)
+	if idx == -1 {
+		return nil, nil, NotFoundError.New("")
+	}
+	stored := entries[idx].(*storedEntry)
+	err := fn(stored.Queueable)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = stored.remarshal(marshaler)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return entries, []Event{{
+		Type:     Updated,
+		Key:      key,
+		Position: idx + 1,
+	}}, nil
+}
+
+// migrateStored remarshals those of the *storedEntry entries stored in an
+// older format, returning how many were.
+func migrateStored(marshaler Marshaler, entries []Queueable) (int, error) {
+	migrated := 0
+	for _, entry := range entries {
+		stored := entry.(*storedEntry)
+		if !migrationNeeded(marshaler, []byte(stored.raw)) {
+			continue
+		}
+		err := stored.remarshal(marshaler)
+		if err != nil {
+			return 0, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
 }
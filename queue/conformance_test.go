@@ -0,0 +1,98 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"xmtp.net/xmtpbot/queue"
+	"xmtp.net/xmtpbot/queue/queuetest"
+	"xmtp.net/xmtpbot/queue/redistest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	queuetest.RunQueueTests(t, func(t *testing.T) queue.Queue {
+		return queue.New()
+	})
+	queuetest.RunManagerTests(t, func(t *testing.T) queue.Manager {
+		return queue.NewManager()
+	})
+}
+
+func TestSnapshotConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xmtpbot-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	count := 0
+	newManager := func(t *testing.T) queue.Manager {
+		count++
+		return queue.NewSnapshotManager(filepath.Join(dir,
+			fmt.Sprintf("queues-%d.json", count)), queuetest.Marshaler, 0)
+	}
+
+	queuetest.RunQueueTests(t, func(t *testing.T) queue.Queue {
+		return newManager(t).Lookup("foo")
+	})
+	queuetest.RunManagerTests(t, newManager)
+}
+
+func TestFileConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xmtpbot-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	count := 0
+	newManager := func(t *testing.T) queue.Manager {
+		count++
+		return queue.NewFileManager(filepath.Join(dir,
+			fmt.Sprintf("queues-%d", count)), queuetest.Marshaler)
+	}
+
+	queuetest.RunQueueTests(t, func(t *testing.T) queue.Queue {
+		return newManager(t).Lookup("foo")
+	})
+	queuetest.RunManagerTests(t, newManager)
+}
+
+func TestRedisConformance(t *testing.T) {
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := server.Client()
+	defer client.Close()
+
+	count := 0
+	queuetest.RunQueueTests(t, func(t *testing.T) queue.Queue {
+		count++
+		return queue.NewRedis(fmt.Sprintf("xmtpbot.testing.%d", count),
+			client, queuetest.Marshaler)
+	})
+	queuetest.RunManagerTests(t, func(t *testing.T) queue.Manager {
+		count++
+		return queue.NewRedisManager(fmt.Sprintf("xmtpbot.testing.%d",
+			count), client, queuetest.Marshaler)
+	})
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+// Copy replaces the contents of each of the Queues held by dst with those of
+// the Queue of the same key held by src, returning the number of entries
+// copied. Queues held only by dst are left alone.
+//
+// Nothing else should be changing either Manager's Queues while they're
+// copied.
+func Copy(dst, src Manager) (copied int, err error) {
+	lister, ok := src.(Lister)
+	if !ok {
+		return 0, Error.New("%T can't list its queues", src)
+	}
+	keys, err := lister.Keys()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		queueables, err := src.Lookup(key).List()
+		if err != nil {
+			return copied, err
+		}
+		q := dst.Lookup(key)
+		err = q.Clear()
+		if err != nil {
+			return copied, err
+		}
+		for _, queueable := range queueables {
+			err = q.Enqueue(queueable)
+			if err != nil {
+				return copied, err
+			}
+			copied++
+		}
+		logger.Debugf("copied %d entries of queue %q", len(queueables), key)
+	}
+
+	return copied, nil
+}
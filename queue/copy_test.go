@@ -0,0 +1,52 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	rt := newRedisTest(t)
+	defer rt.Close()
+	test, dir, cleanup := newFileTest(t)
+	defer cleanup()
+
+	src := NewRedisManager("xmtpbot.copying", rt.server.Client(),
+		JSONMarshaler(redisTestMarshaler))
+	test.AssertNil(src.Lookup("foo").Enqueue(newQueueable("foo", "bar")))
+	test.AssertNil(src.Lookup("foo").Enqueue(newQueueable("baz", "quux")))
+	test.AssertNil(src.Lookup("bar#waitlist").Enqueue(
+		newQueueable("dead", "beef")))
+
+	dst := NewFileManager(dir, JSONMarshaler(testMarshaler))
+	test.AssertNil(dst.Lookup("foo").Enqueue(newQueueable("stale", "")))
+	test.AssertNil(dst.Lookup("other").Enqueue(newQueueable("kept", "")))
+
+	copied, err := Copy(dst, src)
+	test.AssertNil(err)
+	test.AssertEqual(copied, 3)
+	assertOrder(test, dst.Lookup("foo"), "foo", "baz")
+	assertOrder(test, dst.Lookup("bar#waitlist"), "dead")
+	assertOrder(test, dst.Lookup("other"), "kept")
+
+	_, err = Copy(dst, &unlistableManager{src})
+	test.Assert(err != nil)
+}
+
+// unlistableManager hides its Manager's Lister implementation.
+type unlistableManager struct {
+	Manager
+}
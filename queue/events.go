@@ -0,0 +1,149 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"sync"
+)
+
+// The number of Events a Subscription holds for its reader. Events published
+// while a Subscription's buffer is full are dropped.
+const subscriptionBuffer = 64
+
+type EventType string
+
+const (
+	Enqueued EventType = "enqueued"
+	Removed  EventType = "removed"
+	Taken    EventType = "taken"
+	Cleared  EventType = "cleared"
+	Moved    EventType = "moved"
+	Updated  EventType = "updated"
+)
+
+// Event describes a change to a Queue.
+type Event struct {
+	Type EventType `json:"type"`
+
+	// The key of the Queueable changed, if any
+	Key string `json:"key,omitempty"`
+
+	// The (1-indexed) position of the Queueable after it was enqueued,
+	// moved or updated, or before it was removed or taken
+	Position int `json:"position,omitempty"`
+}
+
+type Subscription interface {
+	// Return the channel on which the Queue's changes are delivered, in the
+	// order they were made. The channel is closed when the Subscription is.
+	Events() <-chan Event
+
+	// Stop delivering changes
+	Close() error
+}
+
+// broadcaster delivers the Events published to it to its Subscriptions.
+type broadcaster struct {
+	mtx         sync.Mutex
+	subscribers map[*subscription]bool
+}
+
+type subscription struct {
+	events      chan Event
+	broadcaster *broadcaster
+	once        sync.Once
+}
+
+func (b *broadcaster) Subscribe() (Subscription, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	s := &subscription{
+		events:      make(chan Event, subscriptionBuffer),
+		broadcaster: b,
+	}
+	if b.subscribers == nil {
+		b.subscribers = make(map[*subscription]bool)
+	}
+	b.subscribers[s] = true
+
+	return s, nil
+}
+
+func (b *broadcaster) publish(events ...Event) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	for s := range b.subscribers {
+		for _, event := range events {
+			s.deliver(event)
+		}
+	}
+}
+
+func (b *broadcaster) unsubscribe(s *subscription) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	delete(b.subscribers, s)
+}
+
+func (s *subscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *subscription) Close() error {
+	s.once.Do(func() {
+		s.broadcaster.unsubscribe(s)
+		close(s.events)
+	})
+
+	return nil
+}
+
+// deliver sends the event without blocking, dropping it if the subscriber
+// has fallen too far behind.
+func (s *subscription) deliver(event Event) {
+	select {
+	case s.events <- event:
+	default:
+		logger.Warnf("subscription buffer full; dropped %s event",
+			event.Type)
+	}
+}
+
+// takenEvents returns the Events describing the Queueables taken from the
+// front of a Queue.
+func takenEvents(queueables []Queueable) []Event {
+	events := make([]Event, 0, len(queueables))
+	for idx, queueable := range queueables {
+		events = append(events, Event{
+			Type:     Taken,
+			Key:      queueable.Key(),
+			Position: idx + 1,
+		})
+	}
+
+	return events
+}
+
+// swappedEvents returns the Events describing the exchange of the positions
+// of the Queueables with the given keys.
+func swappedEvents(queueables []Queueable, key1, key2 string) []Event {
+	return []Event{
+		{Type: Moved, Key: key1, Position: indexOf(queueables, key1) + 1},
+		{Type: Moved, Key: key2, Position: indexOf(queueables, key2) + 1},
+	}
+}
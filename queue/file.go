@@ -0,0 +1,454 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const fileExtension = ".json"
+
+var (
+	FileDirname = flag.String("queue.file_dirname", "queues",
+		"directory in which to store file-backed queues "+
+			"(relative to config_dir)")
+)
+
+// fileManager is a Manager of queues that are each stored in a file of their
+// own, which is rewritten atomically before any change to the queue is
+// reported as made. Queues are held in memory once they're looked up, so a
+// directory mustn't be shared by more than one process at a time.
+type fileManager struct {
+	dir       string
+	marshaler Marshaler
+	queues    map[string]*fileQueue
+	mtx       sync.Mutex
+}
+
+var _ Manager = (*fileManager)(nil)
+var _ Lister = (*fileManager)(nil)
+
+// NewFileManager returns a Manager of queues stored in files in dir.
+func NewFileManager(dir string, marshaler Marshaler) Manager {
+	logger.Infof("file-backed queues initialized at: %q", dir)
+
+	return &fileManager{
+		dir:       dir,
+		marshaler: marshaler,
+		queues:    make(map[string]*fileQueue),
+	}
+}
+
+func (m *fileManager) Delete(key string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	q, ok := m.queues[key]
+	if ok {
+		return q.delete()
+	}
+
+	return removeFile(m.filename(key))
+}
+
+func (m *fileManager) Keys() ([]string, error) {
+	infos, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var keys []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, fileExtension) {
+			continue
+		}
+		key, err := url.PathUnescape(
+			strings.TrimSuffix(name, fileExtension))
+		if err != nil {
+			logger.Warnf("ignoring queue file %q: %v", name, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (m *fileManager) Lookup(key string) Queue {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	q, ok := m.queues[key]
+	if !ok {
+		q = newFileQueue(m.filename(key), m.marshaler)
+		m.queues[key] = q
+	}
+
+	return q
+}
+
+func (m *fileManager) filename(key string) string {
+	return filepath.Join(m.dir, url.PathEscape(key)+fileExtension)
+}
+
+// fileQueue is a queue whose records are kept in memory exactly as they're
+// stored in its file.
+type fileQueue struct {
+	broadcaster
+	filename  string
+	marshaler Marshaler
+	records   []string
+	// set when the file couldn't be read, so that it's never overwritten
+	// with an empty queue
+	err error
+	mtx sync.Mutex
+}
+
+var _ Queue = (*fileQueue)(nil)
+var _ Migrator = (*fileQueue)(nil)
+
+func newFileQueue(filename string, marshaler Marshaler) *fileQueue {
+	q := &fileQueue{
+		filename:  filename,
+		marshaler: marshaler,
+	}
+	q.err = q.load()
+	if q.err != nil {
+		logger.Errorf("failed to load queue from %q: %v", filename, q.err)
+	}
+
+	return q
+}
+
+func (q *fileQueue) Clear() error {
+	return q.update(func(entries []Queueable) ([]Queueable, []Event,
+		error) {
+
+		return nil, []Event{{Type: Cleared}}, nil
+	})
+}
+
+func (q *fileQueue) Dequeue(n int) (queueables []Queueable, err error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	err = q.update(func(entries []Queueable) ([]Queueable, []Event,
+		error) {
+
+		taken := entries[:min(n, len(entries))]
+		for _, entry := range taken {
+			queueables = append(queueables, entry.(*storedEntry).Queueable)
+		}
+
+		return entries[len(taken):], takenEvents(taken), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return queueables, nil
+}
+
+func (q *fileQueue) Enqueue(queueable Queueable) error {
+	return q.insert(-1, queueable)
+}
+
+func (q *fileQueue) Insert(pos int, queueable Queueable) error {
+	return q.insert(pos, queueable)
+}
+
+// insert inserts the queueable at the (1-indexed) position, or at the end of
+// the queue when pos is negative.
+func (q *fileQueue) insert(pos int, queueable Queueable) error {
+	bytes, err := q.marshaler.Marshal(queueable)
+	if err != nil {
+		return err
+	}
+
+	return q.update(func(entries []Queueable) ([]Queueable, []Event,
+		error) {
+
+		if indexOf(entries, queueable.Key()) >= 0 {
+			return nil, nil, AlreadyQueuedError.New("")
+		}
+		if pos < 0 {
+			pos = len(entries) + 1
+		}
+
+		inserted := insertAt(entries, pos, &storedEntry{
+			Queueable: queueable,
+			raw:       string(bytes),
+		})
+
+		return inserted, []Event{{
+			Type:     Enqueued,
+			Key:      queueable.Key(),
+			Position: indexOf(inserted, queueable.Key()) + 1,
+		}}, nil
+	})
+}
+
+func (q *fileQueue) List() ([]Queueable, error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.err != nil {
+		return nil, q.err
+	}
+	entries, err := q.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	queueables := make([]Queueable, 0, len(entries))
+	for _, entry := range entries {
+		queueables = append(queueables, entry.(*storedEntry).Queueable)
+	}
+
+	return queueables, nil
+}
+
+func (q *fileQueue) Move(key string, pos int) error {
+	return q.update(func(entries []Queueable) ([]Queueable, []Event,
+		error) {
+
+		moved, err := moveTo(entries, key, pos)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return moved, []Event{{
+			Type:     Moved,
+			Key:      key,
+			Position: indexOf(moved, key) + 1,
+		}}, nil
+	})
+}
+
+func (q *fileQueue) Position(key string) int {
+	queueables, err := q.List()
+	if err != nil {
+		logger.Errore(err)
+		return -1
+	}
+	idx := indexOf(queueables, key)
+	if idx == -1 {
+		return -1
+	}
+
+	return idx + 1
+}
+
+func (q *fileQueue) Remove(key string) (queueable Queueable, err error) {
+	err = q.update(func(entries []Queueable) ([]Queueable, []Event,
+		error) {
+
+		idx := indexOf(entries, key)
+		if idx == -1 {
+			return nil, nil, NotFoundError.New("")
+		}
+		queueable = entries[idx].(*storedEntry).Queueable
+
+		removed := make([]Queueable, 0, len(entries)-1)
+		removed = append(removed, entries[:idx]...)
+		removed = append(removed, entries[idx+1:]...)
+
+		return removed, []Event{{
+			Type:     Removed,
+			Key:      key,
+			Position: idx + 1,
+		}}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return queueable, nil
+}
+
+func (q *fileQueue) Size() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.err != nil {
+		return -1
+	}
+
+	return len(q.records)
+}
+
+func (q *fileQueue) Swap(key1, key2 string) error {
+	return q.update(func(entries []Queueable) ([]Queueable, []Event,
+		error) {
+
+		swapped, err := swap(entries, key1, key2)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return swapped, swappedEvents(swapped, key1, key2), nil
+	})
+}
+
+func (q *fileQueue) Migrate() (migrated int, err error) {
+	err = q.update(func(entries []Queueable) ([]Queueable, []Event,
+		error) {
+
+		n, err := migrateStored(q.marshaler, entries)
+		migrated = n
+
+		return entries, nil, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return migrated, nil
+}
+
+// Update applies fn to the entry with a matching key, rewriting the file.
+func (q *fileQueue) Update(key string,
+	fn func(queueable Queueable) error) error {
+
+	return q.update(func(entries []Queueable) ([]Queueable, []Event,
+		error) {
+
+		return updateStored(q.marshaler, entries, key, fn)
+	})
+}
+
+// update replaces the queue's records with the entries returned by fn, once
+// they've been written to the queue's file, then publishes the events fn
+// returns. Should fn, or writing the file, fail, the queue is left
+// unchanged. Each entry passed to, and returned by, fn is a *storedEntry.
+func (q *fileQueue) update(
+	fn func(entries []Queueable) ([]Queueable, []Event, error)) error {
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.err != nil {
+		return q.err
+	}
+	entries, err := q.entries()
+	if err != nil {
+		return err
+	}
+
+	updated, events, err := fn(entries)
+	if err != nil {
+		return err
+	}
+
+	records := make([]string, 0, len(updated))
+	for _, entry := range updated {
+		records = append(records, entry.(*storedEntry).raw)
+	}
+	err = q.write(records)
+	if err != nil {
+		return err
+	}
+	q.records = records
+	q.publish(events...)
+
+	return nil
+}
+
+// The caller is responsible for obtaining q.mtx before calling
+func (q *fileQueue) entries() ([]Queueable, error) {
+	entries := make([]Queueable, 0, len(q.records))
+	for _, record := range q.records {
+		queueable, err := q.marshaler.Unmarshal([]byte(record))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &storedEntry{
+			Queueable: queueable,
+			raw:       record,
+		})
+	}
+
+	return entries, nil
+}
+
+func (q *fileQueue) load() error {
+	bytes, err := ioutil.ReadFile(q.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var records []json.RawMessage
+	err = json.Unmarshal(bytes, &records)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		q.records = append(q.records, string(record))
+	}
+
+	return nil
+}
+
+func (q *fileQueue) write(records []string) error {
+	raw := make([]json.RawMessage, 0, len(records))
+	for _, record := range records {
+		raw = append(raw, json.RawMessage(record))
+	}
+	bytes, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(q.filename, bytes)
+}
+
+// delete empties the queue and removes its file.
+func (q *fileQueue) delete() error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	err := removeFile(q.filename)
+	if err != nil {
+		return err
+	}
+	q.records = nil
+	q.err = nil
+
+	return nil
+}
+
+func removeFile(filename string) error {
+	err := os.Remove(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
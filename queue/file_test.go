@@ -0,0 +1,93 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"xmtp.net/xmtpbot/test"
+)
+
+func TestFileRestore(t *testing.T) {
+	test, dir, cleanup := newFileTest(t)
+	defer cleanup()
+
+	m := NewFileManager(dir, JSONMarshaler(testMarshaler))
+	test.AssertNil(m.Lookup("foo").Enqueue(newQueueable("foo", "bar")))
+	test.AssertNil(m.Lookup("foo").Enqueue(newQueueable("baz", "quux")))
+	test.AssertNil(m.Lookup("bar#waitlist").Enqueue(
+		newQueueable("dead", "beef")))
+	test.AssertNil(m.Lookup("foo").Move("baz", 1))
+
+	restored := NewFileManager(dir, JSONMarshaler(testMarshaler))
+	assertOrder(test, restored.Lookup("foo"), "baz", "foo")
+	assertOrder(test, restored.Lookup("bar#waitlist"), "dead")
+
+	_, err := m.Lookup("foo").Dequeue(1)
+	test.AssertNil(err)
+	test.AssertNil(m.Delete("bar#waitlist"))
+
+	restored = NewFileManager(dir, JSONMarshaler(testMarshaler))
+	assertOrder(test, restored.Lookup("foo"), "foo")
+	assertOrder(test, restored.Lookup("bar#waitlist"))
+}
+
+func TestFileWriteFailure(t *testing.T) {
+	test, dir, cleanup := newFileTest(t)
+	defer cleanup()
+
+	m := NewFileManager(dir, JSONMarshaler(testMarshaler))
+	q := m.Lookup("foo")
+	test.AssertNil(q.Enqueue(newQueueable("foo", "bar")))
+
+	// a directory in the file's place keeps it from being replaced
+	filename := filepath.Join(dir, "foo.json")
+	test.AssertNil(os.Remove(filename))
+	test.AssertNil(os.MkdirAll(filepath.Join(filename, "blocker"), dirPerms))
+
+	test.Assert(q.Enqueue(newQueueable("baz", "quux")) != nil)
+	_, err := q.Dequeue(1)
+	test.Assert(err != nil)
+	assertOrder(test, q, "foo")
+}
+
+func TestFileCorrupt(t *testing.T) {
+	test, dir, cleanup := newFileTest(t)
+	defer cleanup()
+
+	filename := filepath.Join(dir, "foo.json")
+	test.AssertNil(ioutil.WriteFile(filename, []byte("[{"), filePerms))
+
+	q := NewFileManager(dir, JSONMarshaler(testMarshaler)).Lookup("foo")
+	test.Assert(q.Enqueue(newQueueable("foo", "bar")) != nil)
+	test.AssertEqual(q.Size(), -1)
+
+	bytes, err := ioutil.ReadFile(filename)
+	test.AssertNil(err)
+	test.AssertEqual(string(bytes), "[{")
+}
+
+func newFileTest(t *testing.T) (*test.Test, string, func()) {
+	test := test.New(t)
+	dir, err := ioutil.TempDir("", "xmtpbot-queue")
+	test.AssertNil(err)
+
+	return test, dir, func() {
+		os.RemoveAll(dir)
+	}
+}
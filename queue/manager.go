@@ -15,6 +15,8 @@
 package queue
 
 import (
+	"fmt"
+	"strings"
 	"sync"
 
 	redis "gopkg.in/redis.v4"
@@ -25,14 +27,20 @@ type manager struct {
 	mtx    sync.Mutex
 }
 
+var _ Lister = (*manager)(nil)
+
 type redisManager struct {
 	name      string
 	client    *redis.Client
 	queues    map[string]Queue
-	marshaler marshalerFn
+	marshaler Marshaler
+	mtx       sync.Mutex
 }
 
-func NewRedisManager(name string, client *redis.Client, marshaler marshalerFn) Manager {
+var _ Lister = (*redisManager)(nil)
+var _ LegacyMigrator = (*redisManager)(nil)
+
+func NewRedisManager(name string, client *redis.Client, marshaler Marshaler) Manager {
 	return &redisManager{
 		name:      name,
 		client:    client,
@@ -41,21 +49,107 @@ func NewRedisManager(name string, client *redis.Client, marshaler marshalerFn) M
 	}
 }
 
+func (m *redisManager) Delete(key string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	_, err := m.client.Del(m.redisKey(key)).Result()
+	if err != nil {
+		return err
+	}
+	delete(m.queues, key)
+
+	return nil
+}
+
+func (m *redisManager) Keys() ([]string, error) {
+	prefix := m.redisKey("")
+	redis_keys, err := m.client.Keys(prefix + "*").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(redis_keys))
+	for _, redis_key := range redis_keys {
+		keys = append(keys, strings.TrimPrefix(redis_key, prefix))
+	}
+
+	return keys, nil
+}
+
 func (m *redisManager) Lookup(key string) Queue {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
 	q, ok := m.queues[key]
 	if !ok {
-		q = NewRedis(m.name, m.client, m.marshaler)
+		q = NewRedis(m.redisKey(key), m.client, m.marshaler)
 		m.queues[key] = q
 	}
 	return q
 }
 
+// MigrateLegacy renames the list once shared by every key, which was stored
+// under the manager's bare name, to the key's list.
+func (m *redisManager) MigrateLegacy(key string) (bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	exists, err := m.client.Exists(m.name).Result()
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, nil
+	}
+
+	renamed, err := m.client.RenameNX(m.name, m.redisKey(key)).Result()
+	if err != nil {
+		return false, err
+	}
+	if !renamed {
+		return false, Error.New("not migrating legacy queue %s, as %s "+
+			"holds entries", m.name, m.redisKey(key))
+	}
+
+	return true, nil
+}
+
+func (m *redisManager) redisKey(key string) string {
+	return fmt.Sprintf("%s.%s", m.name, key)
+}
+
 func NewManager() Manager {
 	return &manager{
 		queues: make(map[string]Queue),
 	}
 }
 
+func (m *manager) Delete(key string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	q, ok := m.queues[key]
+	if !ok {
+		return nil
+	}
+	delete(m.queues, key)
+
+	return q.Clear()
+}
+
+func (m *manager) Keys() ([]string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	keys := make([]string, 0, len(m.queues))
+	for key := range m.queues {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
 func (m *manager) Lookup(key string) Queue {
 	m.mtx.Lock()
 	defer m.mtx.Unlock()
@@ -0,0 +1,151 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"encoding/json"
+
+	"github.com/spacemonkeygo/errors"
+)
+
+// The version of records that don't carry one, ie those written before
+// records were versioned.
+const UnversionedRecord = 1
+
+var (
+	MarshalError = Error.NewClass("unable to marshal queue entry",
+		errors.NoCaptureStack())
+)
+
+// A Marshaler converts Queueables to and from the records stored by
+// persistent Queues.
+type Marshaler interface {
+	Marshal(queueable Queueable) ([]byte, error)
+	Unmarshal(data []byte) (Queueable, error)
+}
+
+type MarshalFunc func(queueable Queueable) ([]byte, error)
+type UnmarshalFunc func(data []byte) (Queueable, error)
+
+// Migrator is implemented by Queues able to rewrite their stored records in
+// the current format.
+type Migrator interface {
+	// Rewrite any records not in the current format, returning the number
+	// rewritten
+	Migrate() (int, error)
+}
+
+type jsonMarshaler struct {
+	unmarshal UnmarshalFunc
+}
+
+// JSONMarshaler returns a Marshaler which stores Queueables as their JSON
+// encoding, and reads them with unmarshal.
+func JSONMarshaler(unmarshal UnmarshalFunc) Marshaler {
+	return &jsonMarshaler{unmarshal: unmarshal}
+}
+
+func (m *jsonMarshaler) Marshal(queueable Queueable) ([]byte, error) {
+	return json.Marshal(queueable)
+}
+
+func (m *jsonMarshaler) Unmarshal(data []byte) (Queueable, error) {
+	return m.unmarshal(data)
+}
+
+// MarshalerRegistry is a Marshaler which writes records in the latest
+// registered version, and reads records of any registered version.
+//
+// Records are JSON objects carrying their version in a "v" field. Records
+// without one are UnversionedRecords.
+type MarshalerRegistry struct {
+	current      int
+	marshal      MarshalFunc
+	unmarshalers map[int]UnmarshalFunc
+}
+
+var _ Marshaler = (*MarshalerRegistry)(nil)
+
+func NewMarshalerRegistry() *MarshalerRegistry {
+	return &MarshalerRegistry{
+		unmarshalers: make(map[int]UnmarshalFunc),
+	}
+}
+
+// Register the functions reading and writing records of the given version.
+// The marshal function may be nil for versions that are only read.
+func (r *MarshalerRegistry) Register(version int, marshal MarshalFunc,
+	unmarshal UnmarshalFunc) {
+
+	r.unmarshalers[version] = unmarshal
+	if marshal != nil && version >= r.current {
+		r.current = version
+		r.marshal = marshal
+	}
+}
+
+// Current returns the version of the records written by the registry.
+func (r *MarshalerRegistry) Current() int {
+	return r.current
+}
+
+func (r *MarshalerRegistry) Marshal(queueable Queueable) ([]byte, error) {
+	if r.marshal == nil {
+		return nil, MarshalError.New("no record version registered")
+	}
+
+	return r.marshal(queueable)
+}
+
+func (r *MarshalerRegistry) Unmarshal(data []byte) (Queueable, error) {
+	version, err := RecordVersion(data)
+	if err != nil {
+		return nil, err
+	}
+
+	unmarshal, ok := r.unmarshalers[version]
+	if !ok {
+		return nil, MarshalError.New("unknown record version %d", version)
+	}
+
+	return unmarshal(data)
+}
+
+// RecordVersion returns the version of the record.
+func RecordVersion(data []byte) (int, error) {
+	var header struct {
+		Version int `json:"v"`
+	}
+	err := json.Unmarshal(data, &header)
+	if err != nil {
+		return 0, MarshalError.Wrap(err)
+	}
+	if header.Version == 0 {
+		return UnversionedRecord, nil
+	}
+
+	return header.Version, nil
+}
+
+// migrationNeeded reports whether the record should be rewritten by m.
+func migrationNeeded(m Marshaler, data []byte) bool {
+	r, ok := m.(*MarshalerRegistry)
+	if !ok {
+		return false
+	}
+	version, err := RecordVersion(data)
+
+	return err == nil && version != r.Current()
+}
@@ -0,0 +1,78 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"encoding/json"
+	"testing"
+
+	"xmtp.net/xmtpbot/test"
+)
+
+func TestMarshalerRegistry(t *testing.T) {
+	test := test.New(t)
+	r := NewMarshalerRegistry()
+	_, err := r.Marshal(newQueueable("foo", "bar"))
+	test.AssertErrorContains(err, MarshalError)
+
+	r.Register(UnversionedRecord, nil, testMarshaler)
+	r.Register(2, marshalTestRecord, unmarshalTestRecord)
+	test.AssertEqual(r.Current(), 2)
+
+	data, err := r.Marshal(newQueueable("foo", "bar"))
+	test.AssertNil(err)
+	test.AssertEqual(string(data), `{"v":2,"k":"foo","x":"bar"}`)
+	version, err := RecordVersion(data)
+	test.AssertNil(err)
+	test.AssertEqual(version, 2)
+
+	queueable, err := r.Unmarshal(data)
+	test.AssertNil(err)
+	test.AssertEqual(queueable.(*testQueueable).Value, "bar")
+
+	queueable, err = r.Unmarshal([]byte(`{"id":"baz","value":"qux"}`))
+	test.AssertNil(err)
+	test.AssertEqual(queueable.Key(), "baz")
+	test.AssertEqual(queueable.(*testQueueable).Value, "qux")
+	test.Assert(migrationNeeded(r, []byte(`{"id":"baz","value":"qux"}`)))
+	test.Assert(!migrationNeeded(r, data))
+
+	_, err = r.Unmarshal([]byte(`{"v":3}`))
+	test.AssertErrorContains(err, MarshalError)
+	_, err = r.Unmarshal([]byte(`not json`))
+	test.AssertErrorContains(err, MarshalError)
+}
+
+type testRecord struct {
+	Version int    `json:"v"`
+	Key     string `json:"k"`
+	Value   string `json:"x"`
+}
+
+func marshalTestRecord(queueable Queueable) ([]byte, error) {
+	tq := queueable.(*testQueueable)
+
+	return json.Marshal(&testRecord{Version: 2, Key: tq.Id, Value: tq.Value})
+}
+
+func unmarshalTestRecord(data []byte) (Queueable, error) {
+	record := &testRecord{}
+	err := json.Unmarshal(data, record)
+	if err != nil {
+		return nil, err
+	}
+
+	return newQueueable(record.Key, record.Value), nil
+}
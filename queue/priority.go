@@ -0,0 +1,167 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"flag"
+	"sync"
+	"time"
+)
+
+var (
+	SkipCredit = flag.Duration("queue.skip_credit", 15*time.Minute,
+		"how much waiting time each skip is worth in priority queues")
+)
+
+// Prioritized Queueables can be ordered by a priority queue.
+type Prioritized interface {
+	Queueable
+
+	// The time the Queueable entered the Queue
+	EnqueuedAt() time.Time
+
+	// The number of times the Queueable has been passed over
+	Skips() int
+}
+
+type PriorityQueue interface {
+	Queue
+
+	// Apply fn to the Queueable with a matching key, as Update does, then
+	// move it to the position its (possibly changed) score warrants
+	Requeue(key string, fn func(queueable Queueable) error) error
+
+	// Return the priority score of the Queueable at the given time
+	Score(queueable Queueable, now time.Time) time.Duration
+}
+
+// priorityQueue orders Queueables by a score that rises with the time they've
+// waited, and with the number of times they've been skipped. As the time
+// waited rises equally for every Queueable, the order is stable over time,
+// and so is kept by inserting each Queueable at the position its score
+// warrants.
+type priorityQueue struct {
+	Queue
+	credit time.Duration
+	mtx    sync.Mutex
+}
+
+var _ PriorityQueue = (*priorityQueue)(nil)
+
+// NewPriority returns a Queue which orders the Queueables of q by priority,
+// where each skip is worth credit waiting time. Queueables that aren't
+// Prioritized are treated as having just arrived, without skips.
+func NewPriority(q Queue, credit time.Duration) PriorityQueue {
+	return &priorityQueue{
+		Queue:  q,
+		credit: credit,
+	}
+}
+
+func (q *priorityQueue) Enqueue(queueable Queueable) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	queueables, err := q.Queue.List()
+	if err != nil {
+		return err
+	}
+
+	return q.Queue.Insert(q.position(queueables, queueable), queueable)
+}
+
+// Requeue never takes the Queueable out of the Queue, so that it's never
+// lost, should another change to the Queue come between the update and the
+// move.
+func (q *priorityQueue) Requeue(key string,
+	fn func(queueable Queueable) error) error {
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	var updated Queueable
+	err := q.Queue.Update(key, func(queueable Queueable) error {
+		updated = queueable
+		return fn(queueable)
+	})
+	if err != nil {
+		return err
+	}
+
+	queueables, err := q.Queue.List()
+	if err != nil {
+		return err
+	}
+	others := make([]Queueable, 0, len(queueables))
+	for _, queueable := range queueables {
+		if queueable.Key() != key {
+			others = append(others, queueable)
+		}
+	}
+
+	return q.Queue.Move(key, q.position(others, updated))
+}
+
+func (q *priorityQueue) Score(queueable Queueable, now time.Time) time.Duration {
+	return now.Sub(q.effectiveArrival(queueable, now))
+}
+
+// position returns the (1-indexed) position at which queueable belongs.
+func (q *priorityQueue) position(queueables []Queueable,
+	queueable Queueable) int {
+
+	now := time.Now()
+	for idx, candidate := range queueables {
+		if q.before(queueable, candidate, now) {
+			return idx + 1
+		}
+	}
+
+	return len(queueables) + 1
+}
+
+// before reports whether left belongs ahead of right, ordering by score and
+// then by arrival.
+func (q *priorityQueue) before(left, right Queueable, now time.Time) bool {
+	left_effective := q.effectiveArrival(left, now)
+	right_effective := q.effectiveArrival(right, now)
+	if !left_effective.Equal(right_effective) {
+		return left_effective.Before(right_effective)
+	}
+
+	return arrival(left, now).Before(arrival(right, now))
+}
+
+// effectiveArrival is the arrival time of the Queueable, moved earlier by the
+// credit earned for each skip.
+func (q *priorityQueue) effectiveArrival(queueable Queueable,
+	now time.Time) time.Time {
+
+	skips := 0
+	if prioritized, ok := queueable.(Prioritized); ok {
+		skips = prioritized.Skips()
+	}
+
+	return arrival(queueable, now).Add(-time.Duration(skips) * q.credit)
+}
+
+func arrival(queueable Queueable, now time.Time) time.Time {
+	prioritized, ok := queueable.(Prioritized)
+	if !ok || prioritized.EnqueuedAt().IsZero() {
+		return now
+	}
+
+	return prioritized.EnqueuedAt()
+}
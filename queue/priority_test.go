@@ -0,0 +1,128 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"xmtp.net/xmtpbot/test"
+)
+
+func TestPriorityEnqueue(t *testing.T) {
+	test := test.New(t)
+	q := NewPriority(New(), 10*time.Minute)
+	assertPriorityOrdering(test, q)
+}
+
+func TestPriorityRequeue(t *testing.T) {
+	test := test.New(t)
+	q := NewPriority(New(), 10*time.Minute)
+	now := time.Now()
+
+	test.AssertNil(q.Enqueue(newPrioritized("foo", now.Add(-time.Hour), 0)))
+	test.AssertNil(q.Enqueue(newPrioritized("bar", now.Add(-30*time.Minute), 0)))
+	test.AssertNil(q.Enqueue(newPrioritized("baz", now, 0)))
+	assertOrder(test, q, "foo", "bar", "baz")
+
+	test.AssertNil(q.Requeue("baz", setSkips(7)))
+	assertOrder(test, q, "baz", "foo", "bar")
+	test.AssertNil(q.Requeue("baz", setSkips(0)))
+	assertOrder(test, q, "foo", "bar", "baz")
+
+	test.AssertErrorContains(q.Requeue("nope", setSkips(1)),
+		NotFoundError)
+	// entries aren't lost when fn fails
+	test.AssertErrorContains(q.Requeue("foo", func(Queueable) error {
+		return Error.New("failed")
+	}), Error)
+	assertOrder(test, q, "foo", "bar", "baz")
+}
+
+func TestPriorityRequeueRedis(t *testing.T) {
+	test := newRedisTest(t)
+	defer test.Close()
+	q := NewPriority(test.queue, 10*time.Minute)
+	now := time.Now()
+
+	test.AssertNil(q.Enqueue(newPrioritized("foo", now.Add(-time.Hour), 0)))
+	test.AssertNil(q.Enqueue(newPrioritized("bar", now, 0)))
+	test.AssertNil(q.Requeue("bar", setSkips(7)))
+	assertOrder(test.Test, q, "bar", "foo")
+	queueables, err := q.List()
+	test.AssertNil(err)
+	test.AssertEqual(queueables[0].(*testPrioritized).SkipCount, 7)
+}
+
+func setSkips(skips int) func(queueable Queueable) error {
+	return func(queueable Queueable) error {
+		queueable.(*testPrioritized).SkipCount = skips
+		return nil
+	}
+}
+
+func TestPriorityScore(t *testing.T) {
+	test := test.New(t)
+	q := NewPriority(New(), 10*time.Minute)
+	now := time.Now()
+
+	test.AssertEqual(q.Score(newPrioritized("foo", now.Add(-time.Hour), 2),
+		now), 80*time.Minute)
+	test.AssertEqual(q.Score(newQueueable("bar", "baz"), now),
+		time.Duration(0))
+}
+
+// assertPriorityOrdering enqueues entries out of order, and checks that
+// they're ordered by score, then arrival.
+func assertPriorityOrdering(test *test.Test, q Queue) {
+	now := time.Now()
+
+	test.AssertNil(q.Enqueue(newPrioritized("late", now, 0)))
+	test.AssertNil(q.Enqueue(newPrioritized("early", now.Add(-time.Hour), 0)))
+	// 30 minutes late, but credited with 40 minutes for skips
+	test.AssertNil(q.Enqueue(newPrioritized("skipped",
+		now.Add(-30*time.Minute), 4)))
+	// ties "skipped" on score, but arrived later
+	test.AssertNil(q.Enqueue(newPrioritized("tied",
+		now.Add(-20*time.Minute), 5)))
+	test.AssertNil(q.Enqueue(newQueueable("plain", "foo")))
+	assertOrder(test, q, "skipped", "tied", "early", "late", "plain")
+
+	test.AssertEqual(q.Position("early"), 3)
+	test.AssertErrorContains(q.Enqueue(newPrioritized("late", now, 9)),
+		AlreadyQueuedError)
+}
+
+type testPrioritized struct {
+	testQueueable
+	At        time.Time `json:"at"`
+	SkipCount int       `json:"skips"`
+}
+
+func (p *testPrioritized) EnqueuedAt() time.Time {
+	return p.At
+}
+
+func (p *testPrioritized) Skips() int {
+	return p.SkipCount
+}
+
+func newPrioritized(id string, at time.Time, skips int) *testPrioritized {
+	return &testPrioritized{
+		testQueueable: testQueueable{Id: id},
+		At:            at,
+		SkipCount:     skips,
+	}
+}
@@ -25,6 +25,7 @@ var (
 )
 
 type queue struct {
+	broadcaster
 	queueables []Queueable
 	mtx        sync.Mutex
 }
@@ -38,12 +39,13 @@ func New() *queue {
 func (q *queue) Clear() error {
 	q.mtx.Lock()
 	q.queueables = []Queueable{}
+	q.publish(Event{Type: Cleared})
 	q.mtx.Unlock()
 	return nil
 }
 
 func (q *queue) Dequeue(num int) ([]Queueable, error) {
-	if num == 0 {
+	if num <= 0 {
 		return nil, nil
 	}
 
@@ -53,6 +55,7 @@ func (q *queue) Dequeue(num int) ([]Queueable, error) {
 	actual_num := min(len(q.queueables), num)
 	dequeued := q.queueables[:actual_num]
 	q.queueables = q.queueables[actual_num:]
+	q.publish(takenEvents(dequeued)...)
 
 	return dequeued, nil
 }
@@ -65,6 +68,28 @@ func (q *queue) Enqueue(queueable Queueable) error {
 		return AlreadyQueuedError.New("")
 	}
 	q.queueables = append(q.queueables, queueable)
+	q.publish(Event{
+		Type:     Enqueued,
+		Key:      queueable.Key(),
+		Position: len(q.queueables),
+	})
+
+	return nil
+}
+
+func (q *queue) Insert(pos int, queueable Queueable) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.contains(queueable.Key()) {
+		return AlreadyQueuedError.New("")
+	}
+	q.queueables = insertAt(q.queueables, pos, queueable)
+	q.publish(Event{
+		Type:     Enqueued,
+		Key:      queueable.Key(),
+		Position: indexOf(q.queueables, queueable.Key()) + 1,
+	})
 
 	return nil
 }
@@ -75,6 +100,24 @@ func (q *queue) List() ([]Queueable, error) {
 	return q.queueables, nil
 }
 
+func (q *queue) Move(key string, pos int) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	moved, err := moveTo(q.queueables, key, pos)
+	if err != nil {
+		return err
+	}
+	q.queueables = moved
+	q.publish(Event{
+		Type:     Moved,
+		Key:      key,
+		Position: indexOf(moved, key) + 1,
+	})
+
+	return nil
+}
+
 func (q *queue) Position(key string) int {
 	q.mtx.Lock()
 	defer q.mtx.Unlock()
@@ -96,6 +139,12 @@ func (q *queue) Remove(key string) (queueable Queueable, err error) {
 		return nil, NotFoundError.New("")
 	}
 
+	q.publish(Event{
+		Type:     Removed,
+		Key:      key,
+		Position: indexOf(q.queueables, key) + 1,
+	})
+
 	var removed Queueable
 	without := []Queueable{}
 	for _, candidate := range q.queueables {
@@ -117,6 +166,41 @@ func (q *queue) Size() int {
 	return len(q.queueables)
 }
 
+func (q *queue) Swap(key1, key2 string) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	swapped, err := swap(q.queueables, key1, key2)
+	if err != nil {
+		return err
+	}
+	q.queueables = swapped
+	q.publish(swappedEvents(swapped, key1, key2)...)
+
+	return nil
+}
+
+func (q *queue) Update(key string, fn func(queueable Queueable) error) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	idx := indexOf(q.queueables, key)
+	if idx == -1 {
+		return NotFoundError.New("")
+	}
+	err := fn(q.queueables[idx])
+	if err != nil {
+		return err
+	}
+	q.publish(Event{
+		Type:     Updated,
+		Key:      key,
+		Position: idx + 1,
+	})
+
+	return nil
+}
+
 // The caller is responsible for obtaining q.mtx if desired before calling
 func (q *queue) contains(key string) bool {
 	for _, candidate := range q.queueables {
@@ -128,6 +212,68 @@ func (q *queue) contains(key string) bool {
 	return false
 }
 
+// insertAt returns queueables with queueable inserted at the (1-indexed)
+// position, clamped to the bounds of queueables.
+func insertAt(queueables []Queueable, pos int, queueable Queueable) []Queueable {
+	idx := max(0, min(len(queueables), pos-1))
+	inserted := make([]Queueable, 0, len(queueables)+1)
+	inserted = append(inserted, queueables[:idx]...)
+	inserted = append(inserted, queueable)
+	inserted = append(inserted, queueables[idx:]...)
+
+	return inserted
+}
+
+func indexOf(queueables []Queueable, key string) int {
+	for idx, candidate := range queueables {
+		if key == candidate.Key() {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+func moveTo(queueables []Queueable, key string, pos int) ([]Queueable,
+	error) {
+
+	idx := indexOf(queueables, key)
+	if idx == -1 {
+		return nil, NotFoundError.New("")
+	}
+	queueable := queueables[idx]
+
+	without := make([]Queueable, 0, len(queueables))
+	without = append(without, queueables[:idx]...)
+	without = append(without, queueables[idx+1:]...)
+
+	return insertAt(without, pos, queueable), nil
+}
+
+func swap(queueables []Queueable, key1, key2 string) ([]Queueable, error) {
+	idx1 := indexOf(queueables, key1)
+	if idx1 == -1 {
+		return nil, NotFoundError.New("%s", key1)
+	}
+	idx2 := indexOf(queueables, key2)
+	if idx2 == -1 {
+		return nil, NotFoundError.New("%s", key2)
+	}
+
+	swapped := make([]Queueable, len(queueables))
+	copy(swapped, queueables)
+	swapped[idx1], swapped[idx2] = swapped[idx2], swapped[idx1]
+
+	return swapped, nil
+}
+
+func max(left, right int) int {
+	if left > right {
+		return left
+	}
+	return right
+}
+
 func min(left, right int) int {
 	if left < right {
 		return left
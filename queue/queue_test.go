@@ -134,10 +134,83 @@ func TestRemove(t *testing.T) {
 	test.AssertErrorContains(err, NotFoundError)
 }
 
+func TestInsert(t *testing.T) {
+	test := test.New(t)
+	q := New()
+
+	test.AssertNil(q.Insert(1, newQueueable("foo", "bar")))
+	test.AssertNil(q.Insert(1, newQueueable("baz", "quux")))
+	test.AssertNil(q.Insert(99, newQueueable("dead", "beef")))
+	test.AssertNil(q.Insert(2, newQueueable("quux", "foo")))
+	assertOrder(test, q, "baz", "quux", "foo", "dead")
+
+	test.AssertErrorContains(q.Insert(1, newQueueable("foo", "bar")),
+		AlreadyQueuedError)
+	test.AssertEqual(q.Size(), 4)
+}
+
+func TestMove(t *testing.T) {
+	test := test.New(t)
+	q := New()
+	q.Enqueue(newQueueable("foo", "bar"))
+	q.Enqueue(newQueueable("baz", "quux"))
+	q.Enqueue(newQueueable("dead", "beef"))
+
+	test.AssertNil(q.Move("dead", 1))
+	assertOrder(test, q, "dead", "foo", "baz")
+	test.AssertNil(q.Move("dead", 99))
+	assertOrder(test, q, "foo", "baz", "dead")
+	test.AssertNil(q.Move("foo", 2))
+	assertOrder(test, q, "baz", "foo", "dead")
+
+	test.AssertErrorContains(q.Move("nope", 1), NotFoundError)
+}
+
+func TestSwap(t *testing.T) {
+	test := test.New(t)
+	q := New()
+	q.Enqueue(newQueueable("foo", "bar"))
+	q.Enqueue(newQueueable("baz", "quux"))
+	q.Enqueue(newQueueable("dead", "beef"))
+
+	test.AssertNil(q.Swap("foo", "dead"))
+	assertOrder(test, q, "dead", "baz", "foo")
+
+	test.AssertErrorContains(q.Swap("foo", "nope"), NotFoundError)
+	assertOrder(test, q, "dead", "baz", "foo")
+}
+
+func TestManagerDelete(t *testing.T) {
+	test := test.New(t)
+	m := NewManager()
+
+	test.AssertNil(m.Delete("foo"))
+
+	q := m.Lookup("foo")
+	test.AssertNil(q.Enqueue(newQueueable("foo", "bar")))
+	test.Assert(m.Lookup("foo") == q)
+	test.Assert(m.Lookup("bar") != q)
+
+	test.AssertNil(m.Delete("foo"))
+	test.AssertEqual(q.Size(), 0)
+	test.AssertEqual(m.Lookup("foo").Size(), 0)
+}
+
 func assertContains(t *test.Test, q *queue, id string) {
 	t.Assert(q.contains(id))
 }
 
+func assertOrder(t *test.Test, q Queue, keys ...string) {
+	items, err := q.List()
+	t.AssertNil(err)
+	t.AssertEqual(len(items), len(keys))
+	for idx, item := range items {
+		if idx < len(keys) {
+			t.AssertEqual(item.Key(), keys[idx])
+		}
+	}
+}
+
 type testQueueable struct {
 	Id    string `json:"id"`
 	Value string `json:"value"`
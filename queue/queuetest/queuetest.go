@@ -0,0 +1,594 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package queuetest is a conformance suite for implementations of
+// queue.Queue and queue.Manager. Every implementation should pass it.
+package queuetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"xmtp.net/xmtpbot/queue"
+	"xmtp.net/xmtpbot/test"
+)
+
+const (
+	// The number of goroutines used by the concurrency tests
+	concurrency = 20
+
+	// How long to wait for a subscription to deliver an event
+	eventTimeout = 5 * time.Second
+)
+
+// Entry is the Queueable with which the suite fills queues.
+type Entry struct {
+	Id    string `json:"id"`
+	Value string `json:"value"`
+}
+
+func NewEntry(id, value string) *Entry {
+	return &Entry{
+		Id:    id,
+		Value: value,
+	}
+}
+
+func (e *Entry) Key() string {
+	return e.Id
+}
+
+// Marshaler reads and writes Entries, for use by persistent queues.
+var Marshaler = queue.JSONMarshaler(func(data []byte) (queue.Queueable,
+	error) {
+
+	e := &Entry{}
+	err := json.Unmarshal(data, e)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+})
+
+// RunQueueTests runs the suite against the Queues returned by newQueue,
+// which must return an empty Queue, independent of any other, on every call.
+func RunQueueTests(t *testing.T, newQueue func(t *testing.T) queue.Queue) {
+	tests := []struct {
+		name string
+		fn   func(test *test.Test, q queue.Queue)
+	}{
+		{"Ordering", testOrdering},
+		{"Insert", testInsert},
+		{"Duplicates", testDuplicates},
+		{"Position", testPosition},
+		{"Remove", testRemove},
+		{"Dequeue", testDequeue},
+		{"DequeueBounds", testDequeueBounds},
+		{"Move", testMove},
+		{"Swap", testSwap},
+		{"Update", testUpdate},
+		{"Clear", testClear},
+		{"ConcurrentEnqueue", testConcurrentEnqueue},
+		{"ConcurrentDuplicates", testConcurrentDuplicates},
+		{"ConcurrentDequeue", testConcurrentDequeue},
+		{"ConcurrentRemove", testConcurrentRemove},
+		{"Subscribe", testSubscribe},
+		{"SubscriptionClose", testSubscriptionClose},
+	}
+
+	for _, tc := range tests {
+		fn := tc.fn
+		t.Run(tc.name, func(t *testing.T) {
+			fn(test.New(t), newQueue(t))
+		})
+	}
+}
+
+// RunManagerTests runs the suite against the Managers returned by
+// newManager, which must return a Manager of empty Queues on every call.
+func RunManagerTests(t *testing.T,
+	newManager func(t *testing.T) queue.Manager) {
+
+	tests := []struct {
+		name string
+		fn   func(test *test.Test, m queue.Manager)
+	}{
+		{"Lookup", testManagerLookup},
+		{"Delete", testManagerDelete},
+		{"ConcurrentLookup", testManagerConcurrentLookup},
+		{"Keys", testManagerKeys},
+	}
+
+	for _, tc := range tests {
+		fn := tc.fn
+		t.Run(tc.name, func(t *testing.T) {
+			fn(test.New(t), newManager(t))
+		})
+	}
+}
+
+func testOrdering(test *test.Test, q queue.Queue) {
+	assertOrder(test, q)
+	test.AssertEqual(q.Size(), 0)
+
+	for _, id := range []string{"foo", "bar", "baz"} {
+		test.AssertNil(q.Enqueue(NewEntry(id, "value-"+id)))
+	}
+	assertOrder(test, q, "foo", "bar", "baz")
+	test.AssertEqual(q.Size(), 3)
+
+	queueables, err := q.List()
+	test.AssertNil(err)
+	for _, queueable := range queueables {
+		e := queueable.(*Entry)
+		test.AssertEqual(e.Value, "value-"+e.Id)
+	}
+}
+
+func testInsert(test *test.Test, q queue.Queue) {
+	test.AssertNil(q.Insert(1, NewEntry("foo", "")))
+	test.AssertNil(q.Insert(1, NewEntry("bar", "")))
+	test.AssertNil(q.Insert(2, NewEntry("baz", "")))
+	test.AssertNil(q.Insert(99, NewEntry("qux", "")))
+	test.AssertNil(q.Insert(0, NewEntry("quux", "")))
+	assertOrder(test, q, "quux", "bar", "baz", "foo", "qux")
+}
+
+func testDuplicates(test *test.Test, q queue.Queue) {
+	test.AssertNil(q.Enqueue(NewEntry("foo", "first")))
+	test.AssertNil(q.Enqueue(NewEntry("bar", "")))
+
+	test.AssertErrorContains(q.Enqueue(NewEntry("foo", "second")),
+		queue.AlreadyQueuedError)
+	test.AssertErrorContains(q.Insert(1, NewEntry("foo", "second")),
+		queue.AlreadyQueuedError)
+	test.AssertErrorContains(q.Insert(99, NewEntry("bar", "")),
+		queue.AlreadyQueuedError)
+	assertOrder(test, q, "foo", "bar")
+
+	queueables, err := q.List()
+	test.AssertNil(err)
+	test.AssertEqual(queueables[0].(*Entry).Value, "first")
+}
+
+func testPosition(test *test.Test, q queue.Queue) {
+	test.AssertEqual(q.Position("foo"), -1)
+
+	test.AssertNil(q.Enqueue(NewEntry("foo", "")))
+	test.AssertNil(q.Enqueue(NewEntry("bar", "")))
+	test.AssertEqual(q.Position("foo"), 1)
+	test.AssertEqual(q.Position("bar"), 2)
+	test.AssertEqual(q.Position("baz"), -1)
+
+	_, err := q.Dequeue(1)
+	test.AssertNil(err)
+	test.AssertEqual(q.Position("foo"), -1)
+	test.AssertEqual(q.Position("bar"), 1)
+}
+
+func testRemove(test *test.Test, q queue.Queue) {
+	for _, id := range []string{"foo", "bar", "baz"} {
+		test.AssertNil(q.Enqueue(NewEntry(id, "value-"+id)))
+	}
+
+	removed, err := q.Remove("bar")
+	test.AssertNil(err)
+	if removed != nil {
+		test.AssertEqual(removed.Key(), "bar")
+		test.AssertEqual(removed.(*Entry).Value, "value-bar")
+	}
+	assertOrder(test, q, "foo", "baz")
+
+	_, err = q.Remove("bar")
+	test.AssertErrorContains(err, queue.NotFoundError)
+	_, err = q.Remove("nope")
+	test.AssertErrorContains(err, queue.NotFoundError)
+	assertOrder(test, q, "foo", "baz")
+
+	// a removed key may be queued again
+	test.AssertNil(q.Enqueue(NewEntry("bar", "")))
+	assertOrder(test, q, "foo", "baz", "bar")
+}
+
+func testDequeue(test *test.Test, q queue.Queue) {
+	for _, id := range []string{"foo", "bar", "baz"} {
+		test.AssertNil(q.Enqueue(NewEntry(id, "value-"+id)))
+	}
+
+	dequeued, err := q.Dequeue(2)
+	test.AssertNil(err)
+	assertKeys(test, dequeued, "foo", "bar")
+	if len(dequeued) > 0 {
+		test.AssertEqual(dequeued[0].(*Entry).Value, "value-foo")
+	}
+	assertOrder(test, q, "baz")
+
+	// dequeued keys may be queued again, behind those still waiting
+	test.AssertNil(q.Enqueue(NewEntry("foo", "")))
+	assertOrder(test, q, "baz", "foo")
+}
+
+func testDequeueBounds(test *test.Test, q queue.Queue) {
+	dequeued, err := q.Dequeue(1)
+	test.AssertNil(err)
+	assertKeys(test, dequeued)
+
+	for _, id := range []string{"foo", "bar"} {
+		test.AssertNil(q.Enqueue(NewEntry(id, "")))
+	}
+
+	dequeued, err = q.Dequeue(0)
+	test.AssertNil(err)
+	assertKeys(test, dequeued)
+	dequeued, err = q.Dequeue(-1)
+	test.AssertNil(err)
+	assertKeys(test, dequeued)
+	assertOrder(test, q, "foo", "bar")
+
+	dequeued, err = q.Dequeue(99)
+	test.AssertNil(err)
+	assertKeys(test, dequeued, "foo", "bar")
+	test.AssertEqual(q.Size(), 0)
+}
+
+func testMove(test *test.Test, q queue.Queue) {
+	for _, id := range []string{"foo", "bar", "baz"} {
+		test.AssertNil(q.Enqueue(NewEntry(id, "")))
+	}
+
+	test.AssertNil(q.Move("baz", 1))
+	assertOrder(test, q, "baz", "foo", "bar")
+	test.AssertNil(q.Move("baz", 99))
+	assertOrder(test, q, "foo", "bar", "baz")
+	test.AssertNil(q.Move("foo", 2))
+	assertOrder(test, q, "bar", "foo", "baz")
+
+	test.AssertErrorContains(q.Move("nope", 1), queue.NotFoundError)
+	assertOrder(test, q, "bar", "foo", "baz")
+}
+
+func testSwap(test *test.Test, q queue.Queue) {
+	for _, id := range []string{"foo", "bar", "baz"} {
+		test.AssertNil(q.Enqueue(NewEntry(id, "")))
+	}
+
+	test.AssertNil(q.Swap("foo", "baz"))
+	assertOrder(test, q, "baz", "bar", "foo")
+
+	test.AssertErrorContains(q.Swap("foo", "nope"), queue.NotFoundError)
+	test.AssertErrorContains(q.Swap("nope", "foo"), queue.NotFoundError)
+	assertOrder(test, q, "baz", "bar", "foo")
+}
+
+func testUpdate(test *test.Test, q queue.Queue) {
+	for _, id := range []string{"foo", "bar", "baz"} {
+		test.AssertNil(q.Enqueue(NewEntry(id, "")))
+	}
+
+	test.AssertNil(q.Update("bar", func(queueable queue.Queueable) error {
+		queueable.(*Entry).Value = "updated"
+		return nil
+	}))
+	assertOrder(test, q, "foo", "bar", "baz")
+	queueables, err := q.List()
+	test.AssertNil(err)
+	test.AssertEqual(queueables[1].(*Entry).Value, "updated")
+
+	failed := fmt.Errorf("failed")
+	test.AssertEqual(q.Update("bar", func(queueable queue.Queueable) error {
+		return failed
+	}), failed)
+	test.AssertErrorContains(q.Update("nope",
+		func(queueable queue.Queueable) error {
+			return nil
+		}), queue.NotFoundError)
+	assertOrder(test, q, "foo", "bar", "baz")
+}
+
+func testClear(test *test.Test, q queue.Queue) {
+	test.AssertNil(q.Clear())
+
+	for _, id := range []string{"foo", "bar"} {
+		test.AssertNil(q.Enqueue(NewEntry(id, "")))
+	}
+	test.AssertNil(q.Clear())
+	assertOrder(test, q)
+	test.AssertEqual(q.Position("foo"), -1)
+
+	test.AssertNil(q.Enqueue(NewEntry("foo", "")))
+	assertOrder(test, q, "foo")
+}
+
+func testConcurrentEnqueue(test *test.Test, q queue.Queue) {
+	errs := parallel(func(idx int) error {
+		return q.Enqueue(NewEntry(fmt.Sprintf("entry-%02d", idx), ""))
+	})
+	for _, err := range errs {
+		test.AssertNil(err)
+	}
+
+	test.AssertEqual(q.Size(), concurrency)
+	for idx := 0; idx < concurrency; idx++ {
+		test.Assert(q.Position(fmt.Sprintf("entry-%02d", idx)) > 0)
+	}
+}
+
+func testConcurrentDuplicates(test *test.Test, q queue.Queue) {
+	errs := parallel(func(idx int) error {
+		return q.Enqueue(NewEntry("foo", fmt.Sprintf("%d", idx)))
+	})
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		test.AssertErrorContains(err, queue.AlreadyQueuedError)
+	}
+	test.AssertEqual(succeeded, 1)
+	assertOrder(test, q, "foo")
+}
+
+func testConcurrentDequeue(test *test.Test, q queue.Queue) {
+	for idx := 0; idx < concurrency; idx++ {
+		test.AssertNil(q.Enqueue(NewEntry(fmt.Sprintf("entry-%02d", idx),
+			"")))
+	}
+
+	var mtx sync.Mutex
+	taken := map[string]int{}
+	errs := parallel(func(idx int) error {
+		dequeued, err := q.Dequeue(1)
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, queueable := range dequeued {
+			taken[queueable.Key()]++
+		}
+		return err
+	})
+	for _, err := range errs {
+		test.AssertNil(err)
+	}
+
+	// each entry is taken exactly once
+	test.AssertEqual(len(taken), concurrency)
+	for key, count := range taken {
+		test.Assert(count == 1, key)
+	}
+	test.AssertEqual(q.Size(), 0)
+}
+
+func testConcurrentRemove(test *test.Test, q queue.Queue) {
+	for idx := 0; idx < concurrency; idx++ {
+		test.AssertNil(q.Enqueue(NewEntry(fmt.Sprintf("entry-%02d", idx),
+			"")))
+	}
+
+	errs := parallel(func(idx int) error {
+		if idx%2 == 0 {
+			_, err := q.Remove(fmt.Sprintf("entry-%02d", idx))
+			return err
+		}
+		return q.Enqueue(NewEntry(fmt.Sprintf("extra-%02d", idx), ""))
+	})
+	for _, err := range errs {
+		test.AssertNil(err)
+	}
+
+	test.AssertEqual(q.Size(), concurrency)
+	for idx := 0; idx < concurrency; idx++ {
+		removed := q.Position(fmt.Sprintf("entry-%02d", idx)) == -1
+		test.AssertEqual(removed, idx%2 == 0)
+	}
+}
+
+func testSubscribe(test *test.Test, q queue.Queue) {
+	sub, err := q.Subscribe()
+	test.AssertNil(err)
+	if sub == nil {
+		return
+	}
+	defer sub.Close()
+
+	test.AssertNil(q.Enqueue(NewEntry("foo", "")))
+	test.AssertNil(q.Enqueue(NewEntry("bar", "")))
+	test.AssertNil(q.Insert(1, NewEntry("baz", "")))
+	test.AssertNil(q.Move("bar", 1))
+	test.AssertNil(q.Swap("bar", "foo"))
+	_, err = q.Remove("baz")
+	test.AssertNil(err)
+	// failed changes publish nothing
+	test.AssertErrorContains(q.Enqueue(NewEntry("bar", "")),
+		queue.AlreadyQueuedError)
+	_, err = q.Remove("nope")
+	test.AssertErrorContains(err, queue.NotFoundError)
+	_, err = q.Dequeue(1)
+	test.AssertNil(err)
+	test.AssertNil(q.Clear())
+
+	expected := []string{
+		"enqueued foo 1",
+		"enqueued bar 2",
+		"enqueued baz 1",
+		"moved bar 1",
+		"moved bar 3",
+		"moved foo 1",
+		"removed baz 2",
+		"taken foo 1",
+		"cleared  0",
+	}
+	test.AssertEqual(strings.Join(receiveEvents(test, sub, len(expected)),
+		", "), strings.Join(expected, ", "))
+}
+
+func testSubscriptionClose(test *test.Test, q queue.Queue) {
+	sub, err := q.Subscribe()
+	test.AssertNil(err)
+	if sub == nil {
+		return
+	}
+	other, err := q.Subscribe()
+	test.AssertNil(err)
+	if other == nil {
+		return
+	}
+	defer other.Close()
+
+	test.AssertNil(sub.Close())
+	test.AssertNil(sub.Close())
+	test.AssertNil(q.Enqueue(NewEntry("foo", "")))
+
+	// the closed subscription's channel is closed, and the other's still
+	// delivers
+	for {
+		select {
+		case _, ok := <-sub.Events():
+			if ok {
+				continue
+			}
+		case <-time.After(eventTimeout):
+			test.Fatal("subscription wasn't closed")
+		}
+		break
+	}
+	test.AssertEqual(strings.Join(receiveEvents(test, other, 1), ", "),
+		"enqueued foo 1")
+}
+
+func testManagerLookup(test *test.Test, m queue.Manager) {
+	q := m.Lookup("foo")
+	test.AssertEqual(q.Size(), 0)
+	test.AssertNil(q.Enqueue(NewEntry("bar", "")))
+
+	assertOrder(test, m.Lookup("foo"), "bar")
+	assertOrder(test, m.Lookup("baz"))
+	test.AssertNil(m.Lookup("baz").Enqueue(NewEntry("bar", "")))
+	assertOrder(test, m.Lookup("foo"), "bar")
+}
+
+func testManagerDelete(test *test.Test, m queue.Manager) {
+	test.AssertNil(m.Delete("nope"))
+
+	test.AssertNil(m.Lookup("foo").Enqueue(NewEntry("bar", "")))
+	test.AssertNil(m.Lookup("baz").Enqueue(NewEntry("bar", "")))
+	test.AssertNil(m.Delete("foo"))
+
+	assertOrder(test, m.Lookup("foo"))
+	assertOrder(test, m.Lookup("baz"), "bar")
+	test.AssertNil(m.Lookup("foo").Enqueue(NewEntry("bar", "")))
+	assertOrder(test, m.Lookup("foo"), "bar")
+}
+
+func testManagerConcurrentLookup(test *test.Test, m queue.Manager) {
+	errs := parallel(func(idx int) error {
+		return m.Lookup("foo").Enqueue(NewEntry(
+			fmt.Sprintf("entry-%02d", idx), ""))
+	})
+	for _, err := range errs {
+		test.AssertNil(err)
+	}
+
+	test.AssertEqual(m.Lookup("foo").Size(), concurrency)
+}
+
+// receiveEvents returns the next n events delivered to the subscription,
+// each described as "type key position".
+// testManagerKeys checks the keys listed by Managers implementing
+// queue.Lister, which may, but needn't, include those of empty Queues.
+func testManagerKeys(test *test.Test, m queue.Manager) {
+	lister, ok := m.(queue.Lister)
+	if !ok {
+		test.Skipf("%T isn't a queue.Lister", m)
+	}
+
+	test.AssertNil(m.Lookup("foo").Enqueue(NewEntry("bar", "")))
+	test.AssertNil(m.Lookup("123#waitlist").Enqueue(NewEntry("bar", "")))
+	test.AssertNil(m.Lookup("baz").Enqueue(NewEntry("bar", "")))
+	test.AssertNil(m.Delete("baz"))
+
+	keys, err := lister.Keys()
+	test.AssertNil(err)
+	listed := make(map[string]bool)
+	for _, key := range keys {
+		listed[key] = true
+	}
+	test.Assert(listed["foo"])
+	test.Assert(listed["123#waitlist"])
+	test.Assert(!listed["baz"])
+}
+
+func receiveEvents(test *test.Test, sub queue.Subscription, n int) []string {
+	received := []string{}
+	for len(received) < n {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				test.Logf("subscription closed after %d events", len(received))
+				test.Fail()
+				return received
+			}
+			received = append(received, fmt.Sprintf("%s %s %d", event.Type,
+				event.Key, event.Position))
+		case <-time.After(eventTimeout):
+			test.Logf("timed out after %d events", len(received))
+			test.Fail()
+			return received
+		}
+	}
+
+	return received
+}
+
+// parallel calls fn from concurrency goroutines at once, returning the
+// error each returned.
+func parallel(fn func(idx int) error) []error {
+	errs := make([]error, concurrency)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for idx := 0; idx < concurrency; idx++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			<-start
+			errs[idx] = fn(idx)
+		}(idx)
+	}
+	close(start)
+	wg.Wait()
+
+	return errs
+}
+
+func assertOrder(test *test.Test, q queue.Queue, keys ...string) {
+	queueables, err := q.List()
+	test.AssertNil(err)
+	assertKeys(test, queueables, keys...)
+	test.AssertEqual(q.Size(), len(keys))
+}
+
+func assertKeys(test *test.Test, queueables []queue.Queueable,
+	keys ...string) {
+
+	actual := []string{}
+	for _, queueable := range queueables {
+		actual = append(actual, queueable.Key())
+	}
+	test.AssertEqual(strings.Join(actual, ","), strings.Join(keys, ","))
+}
@@ -16,21 +16,25 @@ package queue
 
 import (
 	"encoding/json"
+	"sync"
 
 	redis "gopkg.in/redis.v4"
 )
 
-type marshalerFn func([]byte) (Queueable, error)
+// The number of times a transaction is attempted before giving up, when the
+// list keeps being changed underneath it.
+const maxTransactionAttempts = 100
 
 type redisQueue struct {
 	client    *redis.Client
-	marshaler marshalerFn
+	marshaler Marshaler
 	name      string
 }
 
 var _ Queue = (*redisQueue)(nil)
+var _ Migrator = (*redisQueue)(nil)
 
-func NewRedis(name string, client *redis.Client, marshaler marshalerFn) Queue {
+func NewRedis(name string, client *redis.Client, marshaler Marshaler) Queue {
 	return &redisQueue{
 		name:      name,
 		client:    client,
@@ -39,38 +43,41 @@ func NewRedis(name string, client *redis.Client, marshaler marshalerFn) Queue {
 }
 
 func (q *redisQueue) Clear() error {
-	_, err := q.client.LTrim(q.name, 1, 0).Result()
-	if err != nil {
-		return err
-	}
+	return q.transaction(func(tx *redis.Tx) error {
+		_, err := tx.MultiExec(func() error {
+			tx.LTrim(q.name, 1, 0)
+			return q.publish(tx, Event{Type: Cleared})
+		})
 
-	return nil
+		return err
+	})
 }
 
 func (q *redisQueue) Dequeue(n int) (queueables []Queueable, err error) {
-	err = q.client.Watch(func(tx *redis.Tx) error {
-		strs, err := q.client.LRange(q.name, 0, int64(n-1)).Result()
+	if n <= 0 {
+		return nil, nil
+	}
+
+	err = q.transaction(func(tx *redis.Tx) error {
+		strs, err := tx.LRange(q.name, 0, int64(n-1)).Result()
 		if err != nil {
 			return err
 		}
-
-		for _, str := range strs {
-			queueable, err := q.marshaler([]byte(str))
-			if err != nil {
-				return err
-			}
-			queueables = append(queueables, queueable)
-			_, err = tx.MultiExec(func() error {
-				_, err = q.client.LRem(q.name, 0, str).Result()
-				return err
-			})
-			if err != nil {
-				return err
-			}
+		queueables, err = q.unmarshal(strs)
+		if err != nil {
+			return err
+		}
+		if len(strs) == 0 {
+			return nil
 		}
 
-		return nil
-	}, q.name)
+		_, err = tx.MultiExec(func() error {
+			tx.LTrim(q.name, int64(len(strs)), -1)
+			return q.publish(tx, takenEvents(queueables)...)
+		})
+
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -79,22 +86,60 @@ func (q *redisQueue) Dequeue(n int) (queueables []Queueable, err error) {
 }
 
 func (q *redisQueue) Enqueue(queueable Queueable) error {
-	bytes, err := json.Marshal(queueable)
+	bytes, err := q.marshaler.Marshal(queueable)
 	if err != nil {
 		return err
 	}
 
-	return q.client.Watch(func(tx *redis.Tx) error {
-		if pos := q.Position(queueable.Key()); pos >= 0 {
-			return AlreadyQueuedError.New("")
+	return q.transaction(func(tx *redis.Tx) error {
+		strs, err := tx.LRange(q.name, 0, -1).Result()
+		if err != nil {
+			return err
 		}
-
-		_, err = tx.RPush(q.name, bytes).Result()
+		queueables, err := q.unmarshal(strs)
 		if err != nil {
 			return err
 		}
+		if q.position(queueable.Key(), queueables) >= 0 {
+			return AlreadyQueuedError.New("")
+		}
+
+		_, err = tx.MultiExec(func() error {
+			tx.RPush(q.name, bytes)
+			return q.publish(tx, Event{
+				Type:     Enqueued,
+				Key:      queueable.Key(),
+				Position: len(strs) + 1,
+			})
+		})
 
-		return nil
+		return err
+	})
+}
+
+func (q *redisQueue) Insert(pos int, queueable Queueable) error {
+	bytes, err := q.marshaler.Marshal(queueable)
+	if err != nil {
+		return err
+	}
+
+	return q.update(func(entries []Queueable) ([]Queueable, []Event,
+		error) {
+
+		if q.position(queueable.Key(), entries) >= 0 {
+			return nil, nil, AlreadyQueuedError.New("")
+		}
+
+		inserted := insertAt(entries, pos, &storedEntry{
+			Queueable: queueable,
+			raw:       string(bytes),
+		})
+
+		return inserted, []Event{{
+			Type:     Enqueued,
+			Key:      queueable.Key(),
+			Position: q.position(queueable.Key(), inserted),
+		}}, nil
 	})
 }
 
@@ -104,16 +149,24 @@ func (q *redisQueue) List() ([]Queueable, error) {
 		return nil, err
 	}
 
-	var queueables []Queueable
-	for _, str := range strs {
-		queueable, err := q.marshaler([]byte(str))
+	return q.unmarshal(strs)
+}
+
+func (q *redisQueue) Move(key string, pos int) error {
+	return q.update(func(entries []Queueable) ([]Queueable, []Event,
+		error) {
+
+		moved, err := moveTo(entries, key, pos)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		queueables = append(queueables, queueable)
-	}
 
-	return queueables, nil
+		return moved, []Event{{
+			Type:     Moved,
+			Key:      key,
+			Position: q.position(key, moved),
+		}}, nil
+	})
 }
 
 func (q *redisQueue) Position(key string) int {
@@ -127,31 +180,36 @@ func (q *redisQueue) Position(key string) int {
 }
 
 func (q *redisQueue) Remove(key string) (queueable Queueable, err error) {
-	err = q.client.Watch(func(tx *redis.Tx) error {
-		queueables, err := q.List()
-		if err != nil {
-			logger.Errore(err)
-			return err
-		}
-
-		pos := q.position(key, queueables)
-		if pos == -1 {
-			return NotFoundError.New("")
-		}
-
-		queueable = queueables[pos-1] // pos is 1-indexed, ugh
-		queueable_bytes, err := json.Marshal(queueable)
+	err = q.transaction(func(tx *redis.Tx) error {
+		strs, err := tx.LRange(q.name, 0, -1).Result()
 		if err != nil {
 			return err
 		}
 
-		_, err = tx.LRem(q.name, 0, queueable_bytes).Result()
-		if err != nil {
+		// remove by the stored value, which may have been written in an
+		// earlier format than the queueable would be marshaled in now
+		for idx, str := range strs {
+			candidate, err := q.marshaler.Unmarshal([]byte(str))
+			if err != nil {
+				return err
+			}
+			if candidate.Key() != key {
+				continue
+			}
+			queueable = candidate
+			_, err = tx.MultiExec(func() error {
+				tx.LRem(q.name, 1, str)
+				return q.publish(tx, Event{
+					Type:     Removed,
+					Key:      key,
+					Position: idx + 1,
+				})
+			})
 			return err
 		}
 
-		return nil
-	}, q.name)
+		return NotFoundError.New("")
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -168,6 +226,116 @@ func (q *redisQueue) Size() int {
 	return int(num)
 }
 
+func (q *redisQueue) Swap(key1, key2 string) error {
+	return q.update(func(entries []Queueable) ([]Queueable, []Event,
+		error) {
+
+		swapped, err := swap(entries, key1, key2)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return swapped, swappedEvents(swapped, key1, key2), nil
+	})
+}
+
+func (q *redisQueue) Update(key string,
+	fn func(queueable Queueable) error) error {
+
+	return q.update(func(entries []Queueable) ([]Queueable, []Event,
+		error) {
+
+		return updateStored(q.marshaler, entries, key, fn)
+	})
+}
+
+func (q *redisQueue) Migrate() (migrated int, err error) {
+	err = q.update(func(entries []Queueable) ([]Queueable, []Event,
+		error) {
+
+		n, err := migrateStored(q.marshaler, entries)
+		migrated = n
+
+		return entries, nil, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return migrated, nil
+}
+
+// update atomically replaces the contents of the list with the entries
+// returned by fn, publishing the events it returns. Each entry passed to, and
+// returned by, fn is a *storedEntry.
+func (q *redisQueue) update(
+	fn func(entries []Queueable) ([]Queueable, []Event, error)) error {
+
+	return q.transaction(func(tx *redis.Tx) error {
+		strs, err := tx.LRange(q.name, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+
+		entries := make([]Queueable, 0, len(strs))
+		for _, str := range strs {
+			queueable, err := q.marshaler.Unmarshal([]byte(str))
+			if err != nil {
+				return err
+			}
+			entries = append(entries, &storedEntry{
+				Queueable: queueable,
+				raw:       str,
+			})
+		}
+
+		updated, events, err := fn(entries)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.MultiExec(func() error {
+			tx.Del(q.name)
+			if len(updated) > 0 {
+				values := make([]interface{}, 0, len(updated))
+				for _, entry := range updated {
+					values = append(values, entry.(*storedEntry).raw)
+				}
+				tx.RPush(q.name, values...)
+			}
+			return q.publish(tx, events...)
+		})
+
+		return err
+	})
+}
+
+// transaction runs fn while watching the list, running it again whenever the
+// list changes before fn's transaction is executed.
+func (q *redisQueue) transaction(fn func(tx *redis.Tx) error) (err error) {
+	for attempt := 0; attempt < maxTransactionAttempts; attempt++ {
+		err = q.client.Watch(fn, q.name)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (q *redisQueue) unmarshal(strs []string) ([]Queueable, error) {
+	var queueables []Queueable
+	for _, str := range strs {
+		queueable, err := q.marshaler.Unmarshal([]byte(str))
+		if err != nil {
+			return nil, err
+		}
+		queueables = append(queueables, queueable)
+	}
+
+	return queueables, nil
+}
+
 func (q *redisQueue) position(key string, queueables []Queueable) int {
 	for pos, queueable := range queueables {
 		if key == queueable.Key() {
@@ -177,3 +345,87 @@ func (q *redisQueue) position(key string, queueables []Queueable) int {
 
 	return -1
 }
+
+func (q *redisQueue) Subscribe() (Subscription, error) {
+	pubsub, err := q.client.Subscribe(q.channel())
+	if err != nil {
+		return nil, err
+	}
+	// wait for the subscription to be confirmed, so that no change made
+	// after Subscribe returns is missed
+	_, err = pubsub.Receive()
+	if err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	s := &redisSubscription{
+		pubsub: pubsub,
+		events: make(chan Event, subscriptionBuffer),
+	}
+	go s.run()
+
+	return s, nil
+}
+
+// channel returns the name of the pub/sub channel on which the changes to
+// the list are published, for every process sharing it to see.
+func (q *redisQueue) channel() string {
+	return q.name + ".events"
+}
+
+// publish queues the publication of the events in tx, so that they're
+// published along with the changes they describe.
+func (q *redisQueue) publish(tx *redis.Tx, events ...Event) error {
+	for _, event := range events {
+		bytes, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		tx.Publish(q.channel(), string(bytes))
+	}
+
+	return nil
+}
+
+type redisSubscription struct {
+	pubsub *redis.PubSub
+	events chan Event
+	once   sync.Once
+}
+
+func (s *redisSubscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *redisSubscription) Close() (err error) {
+	s.once.Do(func() {
+		err = s.pubsub.Close()
+	})
+
+	return err
+}
+
+func (s *redisSubscription) run() {
+	defer close(s.events)
+
+	for {
+		msg, err := s.pubsub.ReceiveMessage()
+		if err != nil {
+			return
+		}
+
+		var event Event
+		err = json.Unmarshal([]byte(msg.Payload), &event)
+		if err != nil {
+			logger.Errore(err)
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			logger.Warnf("subscription buffer full; dropped %s event",
+				event.Type)
+		}
+	}
+}
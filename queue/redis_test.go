@@ -17,8 +17,9 @@ package queue
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
-	redis "gopkg.in/redis.v4"
+	"xmtp.net/xmtpbot/queue/redistest"
 	"xmtp.net/xmtpbot/test"
 )
 
@@ -60,6 +61,19 @@ func TestRedisEnqueue(t *testing.T) {
 		AlreadyQueuedError)
 }
 
+func TestRedisInsert(t *testing.T) {
+	test := newRedisTest(t)
+	defer test.Close()
+
+	test.AssertNil(test.queue.Insert(1, newQueueable("foo", "bar")))
+	test.AssertNil(test.queue.Insert(1, newQueueable("baz", "quux")))
+	test.AssertNil(test.queue.Insert(99, newQueueable("dead", "beef")))
+	assertOrder(test.Test, test.queue, "baz", "foo", "dead")
+
+	test.AssertErrorContains(test.queue.Insert(1, newQueueable("foo", "bar")),
+		AlreadyQueuedError)
+}
+
 func TestRedisList(t *testing.T) {
 	test := newRedisTest(t)
 	defer test.Close()
@@ -73,6 +87,22 @@ func TestRedisList(t *testing.T) {
 	test.AssertEqual("baz", items[1].Key())
 }
 
+func TestRedisMove(t *testing.T) {
+	test := newRedisTest(t)
+	defer test.Close()
+
+	test.AssertNil(test.queue.Enqueue(newQueueable("foo", "bar")))
+	test.AssertNil(test.queue.Enqueue(newQueueable("baz", "quux")))
+	test.AssertNil(test.queue.Enqueue(newQueueable("dead", "beef")))
+
+	test.AssertNil(test.queue.Move("dead", 1))
+	assertOrder(test.Test, test.queue, "dead", "foo", "baz")
+	test.AssertNil(test.queue.Move("dead", 99))
+	assertOrder(test.Test, test.queue, "foo", "baz", "dead")
+
+	test.AssertErrorContains(test.queue.Move("nope", 1), NotFoundError)
+}
+
 func TestRedisPosition(t *testing.T) {
 	test := newRedisTest(t)
 	defer test.Close()
@@ -85,6 +115,14 @@ func TestRedisPosition(t *testing.T) {
 	test.AssertEqual(2, test.queue.Position("baz"))
 }
 
+func TestRedisPriority(t *testing.T) {
+	test := newRedisTest(t)
+	defer test.Close()
+
+	assertPriorityOrdering(test.Test,
+		NewPriority(test.queue, 10*time.Minute))
+}
+
 func TestRedisRemove(t *testing.T) {
 	test := newRedisTest(t)
 	defer test.Close()
@@ -103,6 +141,20 @@ func TestRedisRemove(t *testing.T) {
 	test.AssertErrorContains(err, NotFoundError)
 }
 
+func TestRedisSwap(t *testing.T) {
+	test := newRedisTest(t)
+	defer test.Close()
+
+	test.AssertNil(test.queue.Enqueue(newQueueable("foo", "bar")))
+	test.AssertNil(test.queue.Enqueue(newQueueable("baz", "quux")))
+	test.AssertNil(test.queue.Enqueue(newQueueable("dead", "beef")))
+
+	test.AssertNil(test.queue.Swap("foo", "dead"))
+	assertOrder(test.Test, test.queue, "dead", "baz", "foo")
+
+	test.AssertErrorContains(test.queue.Swap("foo", "nope"), NotFoundError)
+}
+
 func TestRedisSize(t *testing.T) {
 	test := newRedisTest(t)
 	defer test.Close()
@@ -122,26 +174,25 @@ func TestRedisSize(t *testing.T) {
 
 type redisTest struct {
 	*test.Test
-	queue Queue
+	queue  Queue
+	server *redistest.Server
 }
 
 func (rt *redisTest) Close() {
 	rt.AssertNil(rt.queue.Clear())
+	rt.AssertNil(rt.server.Close())
 }
 
 func newRedisTest(t *testing.T) *redisTest {
-	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := server.Client()
 	rt := &redisTest{
+		server: server,
 		queue: NewRedis("xmtpbot.testing", client,
-			func(data []byte) (Queueable, error) {
-				tq := &testQueueable{}
-				err := json.Unmarshal(data, tq)
-				if err != nil {
-					return nil, err
-				}
-
-				return tq, nil
-			}),
+			JSONMarshaler(redisTestMarshaler)),
 		Test: test.New(t),
 	}
 
@@ -149,3 +200,74 @@ func newRedisTest(t *testing.T) *redisTest {
 
 	return rt
 }
+
+// redisTestMarshaler unmarshals both testQueueables and testPrioritizeds,
+// preserving the priority of the latter.
+func redisTestMarshaler(data []byte) (Queueable, error) {
+	tp := &testPrioritized{}
+	err := json.Unmarshal(data, tp)
+	if err != nil {
+		return nil, err
+	}
+	if tp.At.IsZero() {
+		return &tp.testQueueable, nil
+	}
+
+	return tp, nil
+}
+
+func TestRedisSubscribeAcrossClients(t *testing.T) {
+	test := newRedisTest(t)
+	defer test.Close()
+
+	// another process's view of the same queue
+	client := test.server.Client()
+	defer client.Close()
+	other := NewRedis("xmtpbot.testing", client,
+		JSONMarshaler(redisTestMarshaler))
+
+	sub, err := other.Subscribe()
+	test.AssertNil(err)
+	defer sub.Close()
+
+	test.AssertNil(test.queue.Enqueue(newQueueable("foo", "bar")))
+	select {
+	case event := <-sub.Events():
+		test.AssertEqual(event, Event{Type: Enqueued, Key: "foo",
+			Position: 1})
+	case <-time.After(5 * time.Second):
+		test.Fatal("no event received")
+	}
+}
+
+func TestRedisManagerMigrateLegacy(t *testing.T) {
+	test := newRedisTest(t)
+	defer test.Close()
+
+	legacy := NewRedis("xmtpbot.testing", test.server.Client(),
+		JSONMarshaler(redisTestMarshaler))
+	test.AssertNil(legacy.Enqueue(newQueueable("foo", "bar")))
+	m := NewRedisManager("xmtpbot.testing", test.server.Client(),
+		JSONMarshaler(redisTestMarshaler))
+
+	migrator, ok := m.(LegacyMigrator)
+	test.Assert(ok)
+	migrated, err := migrator.MigrateLegacy("guild")
+	test.AssertNil(err)
+	test.Assert(migrated)
+	test.AssertEqual(0, legacy.Size())
+	test.AssertEqual(1, m.Lookup("guild").Size())
+
+	// once moved, there's nothing left to migrate
+	migrated, err = migrator.MigrateLegacy("other")
+	test.AssertNil(err)
+	test.Assert(!migrated)
+	test.AssertEqual(0, m.Lookup("other").Size())
+
+	// queues holding entries aren't overwritten
+	test.AssertNil(legacy.Enqueue(newQueueable("baz", "bar")))
+	_, err = migrator.MigrateLegacy("guild")
+	test.AssertErrorContains(err, Error)
+	test.AssertEqual(1, legacy.Size())
+	test.AssertEqual(1, m.Lookup("guild").Size())
+}
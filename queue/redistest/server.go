@@ -0,0 +1,605 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redistest provides an in-process stand-in for a Redis server,
+// implementing just enough of the protocol and commands for the Redis-backed
+// queues to be tested without an external server.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	redis "gopkg.in/redis.v4"
+)
+
+type status string
+
+type commandError string
+
+// multiReply is a sequence of replies to a single command.
+type multiReply []interface{}
+
+// Server is an in-memory Redis server, listening on a loopback address.
+type Server struct {
+	listener net.Listener
+	mtx      sync.Mutex
+	lists    map[string][]string
+	versions map[string]int64
+	channels map[string]map[*conn]bool
+	conns    map[net.Conn]bool
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// conn holds the transaction and subscription state of a client connection.
+type conn struct {
+	server        *Server
+	watched       map[string]int64
+	multi         bool
+	queued        [][]string
+	subscriptions map[string]bool
+
+	// messages are published to subscribers from other connections'
+	// goroutines, so writes are serialized
+	w_mtx sync.Mutex
+	w     *bufio.Writer
+}
+
+// NewServer starts a Server listening on a random loopback port.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		listener: listener,
+		lists:    make(map[string][]string),
+		versions: make(map[string]int64),
+		channels: make(map[string]map[*conn]bool),
+		conns:    make(map[net.Conn]bool),
+	}
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Addr returns the address at which the Server is listening.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Client returns a new client of the Server.
+func (s *Server) Client() *redis.Client {
+	return redis.NewClient(&redis.Options{Addr: s.Addr()})
+}
+
+// Close stops the Server, closing any client connections.
+func (s *Server) Close() error {
+	s.mtx.Lock()
+	s.closed = true
+	for nc := range s.conns {
+		nc.Close()
+	}
+	s.mtx.Unlock()
+
+	err := s.listener.Close()
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mtx.Lock()
+		if s.closed {
+			s.mtx.Unlock()
+			nc.Close()
+			return
+		}
+		s.conns[nc] = true
+		s.mtx.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(nc)
+	}
+}
+
+func (s *Server) handle(nc net.Conn) {
+	c := &conn{
+		server:        s,
+		subscriptions: make(map[string]bool),
+		w:             bufio.NewWriter(nc),
+	}
+	defer s.wg.Done()
+	defer func() {
+		s.mtx.Lock()
+		delete(s.conns, nc)
+		c.unsubscribe(nil)
+		s.mtx.Unlock()
+		nc.Close()
+	}()
+
+	r := bufio.NewReader(nc)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		name := strings.ToUpper(args[0])
+		reply := c.process(name, args[1:])
+		c.w_mtx.Lock()
+		writeReply(c.w, reply)
+		if r.Buffered() == 0 || name == "QUIT" {
+			err = c.w.Flush()
+		}
+		c.w_mtx.Unlock()
+		if err != nil || name == "QUIT" {
+			return
+		}
+	}
+}
+
+func (c *conn) process(name string, args []string) interface{} {
+	s := c.server
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	switch name {
+	case "MULTI":
+		if c.multi {
+			return commandError("ERR MULTI calls can not be nested")
+		}
+		c.multi = true
+		c.queued = nil
+		return status("OK")
+	case "EXEC":
+		if !c.multi {
+			return commandError("ERR EXEC without MULTI")
+		}
+		return c.exec()
+	case "DISCARD":
+		if !c.multi {
+			return commandError("ERR DISCARD without MULTI")
+		}
+		c.multi = false
+		c.queued = nil
+		c.watched = nil
+		return status("OK")
+	case "WATCH":
+		if c.multi {
+			return commandError("ERR WATCH inside MULTI is not allowed")
+		}
+		if c.watched == nil {
+			c.watched = make(map[string]int64)
+		}
+		for _, key := range args {
+			if _, ok := c.watched[key]; !ok {
+				c.watched[key] = s.versions[key]
+			}
+		}
+		return status("OK")
+	case "UNWATCH":
+		c.watched = nil
+		return status("OK")
+	case "SUBSCRIBE":
+		replies := multiReply{}
+		for _, channel := range args {
+			if s.channels[channel] == nil {
+				s.channels[channel] = make(map[*conn]bool)
+			}
+			s.channels[channel][c] = true
+			c.subscriptions[channel] = true
+			replies = append(replies, []interface{}{[]byte("subscribe"),
+				[]byte(channel), int64(len(c.subscriptions))})
+		}
+		// confirm the subscriptions before any message published to them
+		c.w_mtx.Lock()
+		writeReply(c.w, replies)
+		c.w.Flush()
+		c.w_mtx.Unlock()
+		return multiReply{}
+	case "UNSUBSCRIBE":
+		return c.unsubscribe(args)
+	}
+
+	if len(c.subscriptions) > 0 && name == "PING" {
+		payload := ""
+		if len(args) > 0 {
+			payload = args[0]
+		}
+		return []interface{}{[]byte("pong"), []byte(payload)}
+	}
+
+	if c.multi {
+		c.queued = append(c.queued, append([]string{name}, args...))
+		return status("QUEUED")
+	}
+
+	return s.execute(name, args)
+}
+
+// unsubscribe removes the connection's subscriptions to the channels, or to
+// every channel when none are given.
+//
+// The caller is responsible for obtaining c.server.mtx before calling
+func (c *conn) unsubscribe(channels []string) multiReply {
+	if len(channels) == 0 {
+		for channel := range c.subscriptions {
+			channels = append(channels, channel)
+		}
+	}
+
+	replies := multiReply{}
+	for _, channel := range channels {
+		delete(c.subscriptions, channel)
+		delete(c.server.channels[channel], c)
+		if len(c.server.channels[channel]) == 0 {
+			delete(c.server.channels, channel)
+		}
+		replies = append(replies, []interface{}{[]byte("unsubscribe"),
+			[]byte(channel), int64(len(c.subscriptions))})
+	}
+
+	return replies
+}
+
+// deliver sends a published message to the subscribed connection.
+func (c *conn) deliver(channel, message string) {
+	c.w_mtx.Lock()
+	defer c.w_mtx.Unlock()
+
+	writeReply(c.w, []interface{}{[]byte("message"), []byte(channel),
+		[]byte(message)})
+	c.w.Flush()
+}
+
+// exec runs the queued commands, unless a watched key has been modified.
+//
+// The caller is responsible for obtaining s.mtx before calling
+func (c *conn) exec() interface{} {
+	s := c.server
+	queued := c.queued
+	watched := c.watched
+	c.multi = false
+	c.queued = nil
+	c.watched = nil
+
+	for key, version := range watched {
+		if s.versions[key] != version {
+			return nil
+		}
+	}
+
+	replies := []interface{}{}
+	for _, cmd := range queued {
+		replies = append(replies, s.execute(strings.ToUpper(cmd[0]),
+			cmd[1:]))
+	}
+
+	return replies
+}
+
+// execute runs a single command.
+//
+// The caller is responsible for obtaining s.mtx before calling
+func (s *Server) execute(name string, args []string) interface{} {
+	switch name {
+	case "PING":
+		return status("PONG")
+	case "QUIT", "SELECT":
+		return status("OK")
+	case "FLUSHDB", "FLUSHALL":
+		for key := range s.lists {
+			s.touch(key)
+		}
+		s.lists = make(map[string][]string)
+		return status("OK")
+	}
+
+	arity := map[string]int{
+		"DEL":      1,
+		"EXISTS":   1,
+		"KEYS":     1,
+		"LLEN":     1,
+		"LRANGE":   3,
+		"LREM":     3,
+		"LTRIM":    3,
+		"PUBLISH":  2,
+		"RENAMENX": 2,
+		"RPUSH":    2,
+	}
+	min_args, ok := arity[name]
+	if !ok {
+		return commandError(fmt.Sprintf("ERR unknown command '%s'", name))
+	}
+	if len(args) < min_args {
+		return commandError(fmt.Sprintf("ERR wrong number of arguments "+
+			"for '%s' command", strings.ToLower(name)))
+	}
+
+	switch name {
+	case "DEL":
+		deleted := int64(0)
+		for _, key := range args {
+			if _, ok := s.lists[key]; ok {
+				delete(s.lists, key)
+				s.touch(key)
+				deleted++
+			}
+		}
+		return deleted
+	case "EXISTS":
+		exists := int64(0)
+		for _, key := range args {
+			if _, ok := s.lists[key]; ok {
+				exists++
+			}
+		}
+		return exists
+	case "KEYS":
+		keys := []interface{}{}
+		for key := range s.lists {
+			// Redis's glob-style patterns are close enough to path's
+			if ok, _ := path.Match(args[0], key); ok {
+				keys = append(keys, []byte(key))
+			}
+		}
+		return keys
+	case "LLEN":
+		return int64(len(s.lists[args[0]]))
+	case "LRANGE":
+		start, stop, err := parseRange(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		list := s.lists[args[0]]
+		from, to := listRange(len(list), start, stop)
+		values := []interface{}{}
+		for _, value := range list[from:to] {
+			values = append(values, []byte(value))
+		}
+		return values
+	case "LREM":
+		count, err := strconv.Atoi(args[1])
+		if err != nil {
+			return commandError("ERR value is not an integer or out of " +
+				"range")
+		}
+		return s.lrem(args[0], count, args[2])
+	case "LTRIM":
+		start, stop, err := parseRange(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		key := args[0]
+		list, ok := s.lists[key]
+		if !ok {
+			return status("OK")
+		}
+		from, to := listRange(len(list), start, stop)
+		s.setList(key, append([]string{}, list[from:to]...))
+		return status("OK")
+	case "PUBLISH":
+		subscribers := s.channels[args[0]]
+		for c := range subscribers {
+			c.deliver(args[0], args[1])
+		}
+		return int64(len(subscribers))
+	case "RENAMENX":
+		list, ok := s.lists[args[0]]
+		if !ok {
+			return commandError("ERR no such key")
+		}
+		if _, ok := s.lists[args[1]]; ok {
+			return int64(0)
+		}
+		delete(s.lists, args[0])
+		s.touch(args[0])
+		s.setList(args[1], list)
+		return int64(1)
+	case "RPUSH":
+		key := args[0]
+		s.setList(key, append(s.lists[key], args[1:]...))
+		return int64(len(s.lists[key]))
+	}
+
+	return commandError(fmt.Sprintf("ERR unknown command '%s'", name))
+}
+
+// The caller is responsible for obtaining s.mtx before calling
+func (s *Server) lrem(key string, count int, value string) int64 {
+	list := s.lists[key]
+	removed := int64(0)
+	kept := make([]string, 0, len(list))
+	if count >= 0 {
+		for _, candidate := range list {
+			if candidate == value &&
+				(count == 0 || removed < int64(count)) {
+				removed++
+				continue
+			}
+			kept = append(kept, candidate)
+		}
+	} else {
+		for idx := len(list) - 1; idx >= 0; idx-- {
+			if list[idx] == value && removed < int64(-count) {
+				removed++
+				continue
+			}
+			kept = append([]string{list[idx]}, kept...)
+		}
+	}
+	if removed > 0 {
+		s.setList(key, kept)
+	}
+
+	return removed
+}
+
+// setList replaces the list, deleting it when empty, as Redis does.
+//
+// The caller is responsible for obtaining s.mtx before calling
+func (s *Server) setList(key string, list []string) {
+	if len(list) == 0 {
+		delete(s.lists, key)
+	} else {
+		s.lists[key] = list
+	}
+	s.touch(key)
+}
+
+// touch records a change to the key, failing the transactions watching it.
+//
+// The caller is responsible for obtaining s.mtx before calling
+func (s *Server) touch(key string) {
+	s.versions[key]++
+}
+
+func parseRange(start_arg, stop_arg string) (start, stop int,
+	err interface{}) {
+
+	start, start_err := strconv.Atoi(start_arg)
+	stop, stop_err := strconv.Atoi(stop_arg)
+	if start_err != nil || stop_err != nil {
+		return 0, 0, commandError("ERR value is not an integer or out of " +
+			"range")
+	}
+
+	return start, stop, nil
+}
+
+// listRange converts the inclusive, possibly negative, Redis indexes into
+// slice bounds for a list of the given length.
+func listRange(length, start, stop int) (from, to int) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return 0, 0
+	}
+
+	return start, stop + 1
+}
+
+// readCommand reads a command sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		// inline command
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("expected bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch reply := reply.(type) {
+	case nil:
+		w.WriteString("*-1\r\n")
+	case status:
+		fmt.Fprintf(w, "+%s\r\n", reply)
+	case commandError:
+		fmt.Fprintf(w, "-%s\r\n", reply)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", reply)
+	case []byte:
+		if reply == nil {
+			w.WriteString("$-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "$%d\r\n", len(reply))
+		w.Write(reply)
+		w.WriteString("\r\n")
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(reply))
+		for _, item := range reply {
+			writeReply(w, item)
+		}
+	case multiReply:
+		for _, item := range reply {
+			writeReply(w, item)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unexpected reply %T\r\n", reply)
+	}
+}
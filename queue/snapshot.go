@@ -0,0 +1,312 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	dirPerms  = 0700
+	filePerms = 0600
+)
+
+var (
+	SnapshotFilename = flag.String("queue.snapshot_filename", "queues.json",
+		"filename in which to store snapshots of in-memory queues "+
+			"(relative to config_dir)")
+	SnapshotInterval = flag.Duration("queue.snapshot_interval", 0,
+		"delay between a change to an in-memory queue and writing its "+
+			"snapshot; 0 writes a snapshot upon every change")
+)
+
+// snapshotManager is a Manager of in-memory queues, which writes a snapshot
+// of every queue to a file whenever any of them change.
+type snapshotManager struct {
+	filename  string
+	marshaler Marshaler
+	interval  time.Duration
+	queues    map[string]*snapshotQueue
+	mtx       sync.Mutex
+	flush_mtx sync.Mutex
+	pending   bool
+}
+
+var _ Manager = (*snapshotManager)(nil)
+var _ Lister = (*snapshotManager)(nil)
+
+// NewSnapshotManager returns a Manager of in-memory queues that are restored
+// from, and written to, filename. When interval is greater than zero, changes
+// made within interval of each other are written in a single snapshot.
+func NewSnapshotManager(filename string, marshaler Marshaler,
+	interval time.Duration) *snapshotManager {
+
+	m := &snapshotManager{
+		filename:  filename,
+		marshaler: marshaler,
+		interval:  interval,
+		queues:    make(map[string]*snapshotQueue),
+	}
+	if err := m.load(); err != nil {
+		logger.Warnf("failed to load queue snapshot: %v", err)
+	}
+	logger.Infof("queue snapshots initialized at: %q", filename)
+
+	return m
+}
+
+func (m *snapshotManager) Delete(key string) error {
+	m.mtx.Lock()
+	_, ok := m.queues[key]
+	delete(m.queues, key)
+	m.mtx.Unlock()
+
+	if ok {
+		m.changed()
+	}
+
+	return nil
+}
+
+func (m *snapshotManager) Keys() ([]string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	keys := make([]string, 0, len(m.queues))
+	for key := range m.queues {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (m *snapshotManager) Lookup(key string) Queue {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return m.lookup(key)
+}
+
+// Flush writes a snapshot of every queue. Flushes are serialized from the
+// snapshot's capture through its write, so that a later snapshot is never
+// overwritten by an earlier one.
+func (m *snapshotManager) Flush() error {
+	m.flush_mtx.Lock()
+	defer m.flush_mtx.Unlock()
+
+	m.mtx.Lock()
+	m.pending = false
+	queues := make(map[string]*snapshotQueue, len(m.queues))
+	for key, q := range m.queues {
+		queues[key] = q
+	}
+	m.mtx.Unlock()
+
+	snapshot := make(map[string][]json.RawMessage, len(queues))
+	for key, q := range queues {
+		queueables, err := q.List()
+		if err != nil {
+			return err
+		}
+		entries := []json.RawMessage{}
+		for _, queueable := range queueables {
+			bytes, err := m.marshaler.Marshal(queueable)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, bytes)
+		}
+		snapshot[key] = entries
+	}
+
+	bytes, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(m.filename, bytes)
+}
+
+func (m *snapshotManager) changed() {
+	if m.interval <= 0 {
+		logger.Errore(m.Flush())
+		return
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.pending {
+		return
+	}
+	m.pending = true
+	time.AfterFunc(m.interval, func() {
+		logger.Errore(m.Flush())
+	})
+}
+
+func (m *snapshotManager) load() (err error) {
+	bytes, err := ioutil.ReadFile(m.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return os.MkdirAll(filepath.Dir(m.filename), dirPerms)
+		}
+		return err
+	}
+	if len(bytes) == 0 {
+		return nil
+	}
+
+	var snapshot map[string][]json.RawMessage
+	err = json.Unmarshal(bytes, &snapshot)
+	if err != nil {
+		return err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for key, entries := range snapshot {
+		q := m.lookup(key)
+		for _, entry := range entries {
+			queueable, err := m.marshaler.Unmarshal(entry)
+			if err != nil {
+				logger.Errore(err)
+				continue
+			}
+			err = q.queue.Enqueue(queueable)
+			if err != nil {
+				logger.Errore(err)
+				continue
+			}
+		}
+	}
+	logger.Debugf("loaded %d queues from snapshot", len(snapshot))
+
+	return nil
+}
+
+// The caller is responsible for obtaining m.mtx before calling
+func (m *snapshotManager) lookup(key string) *snapshotQueue {
+	q, ok := m.queues[key]
+	if !ok {
+		q = &snapshotQueue{
+			queue:   New(),
+			manager: m,
+		}
+		m.queues[key] = q
+	}
+
+	return q
+}
+
+// snapshotQueue is an in-memory queue that notifies its manager of changes.
+type snapshotQueue struct {
+	*queue
+	manager *snapshotManager
+}
+
+func (q *snapshotQueue) Clear() error {
+	return q.changed(q.queue.Clear())
+}
+
+func (q *snapshotQueue) Dequeue(n int) ([]Queueable, error) {
+	queueables, err := q.queue.Dequeue(n)
+	if len(queueables) > 0 {
+		q.manager.changed()
+	}
+
+	return queueables, err
+}
+
+func (q *snapshotQueue) Enqueue(queueable Queueable) error {
+	return q.changed(q.queue.Enqueue(queueable))
+}
+
+func (q *snapshotQueue) Insert(pos int, queueable Queueable) error {
+	return q.changed(q.queue.Insert(pos, queueable))
+}
+
+func (q *snapshotQueue) Move(key string, pos int) error {
+	return q.changed(q.queue.Move(key, pos))
+}
+
+func (q *snapshotQueue) Remove(key string) (Queueable, error) {
+	queueable, err := q.queue.Remove(key)
+
+	return queueable, q.changed(err)
+}
+
+func (q *snapshotQueue) Swap(key1, key2 string) error {
+	return q.changed(q.queue.Swap(key1, key2))
+}
+
+func (q *snapshotQueue) Update(key string,
+	fn func(queueable Queueable) error) error {
+
+	return q.changed(q.queue.Update(key, fn))
+}
+
+func (q *snapshotQueue) changed(err error) error {
+	if err == nil {
+		q.manager.changed()
+	}
+
+	return err
+}
+
+// writeFileAtomic writes data to a temporary file, then renames it to
+// filename, so that filename is never left partially written.
+func writeFileAtomic(filename string, data []byte) (err error) {
+	dir := filepath.Dir(filename)
+	err = os.MkdirAll(dir, dirPerms)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if close_err := tmp.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), filePerms)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
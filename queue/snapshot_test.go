@@ -0,0 +1,107 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"xmtp.net/xmtpbot/test"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	test, filename, cleanup := newSnapshotTest(t)
+	defer cleanup()
+
+	m := NewSnapshotManager(filename, JSONMarshaler(testMarshaler), 0)
+	test.AssertNil(m.Lookup("foo").Enqueue(newQueueable("foo", "bar")))
+	test.AssertNil(m.Lookup("foo").Enqueue(newQueueable("baz", "quux")))
+	test.AssertNil(m.Lookup("bar").Enqueue(newQueueable("dead", "beef")))
+	test.AssertNil(m.Lookup("foo").Move("baz", 1))
+
+	restored := NewSnapshotManager(filename, JSONMarshaler(testMarshaler), 0)
+	assertOrder(test, restored.Lookup("foo"), "baz", "foo")
+	assertOrder(test, restored.Lookup("bar"), "dead")
+
+	_, err := m.Lookup("foo").Dequeue(1)
+	test.AssertNil(err)
+	test.AssertNil(m.Delete("bar"))
+
+	restored = NewSnapshotManager(filename, JSONMarshaler(testMarshaler), 0)
+	assertOrder(test, restored.Lookup("foo"), "foo")
+	assertOrder(test, restored.Lookup("bar"))
+}
+
+func TestSnapshotInterval(t *testing.T) {
+	test, filename, cleanup := newSnapshotTest(t)
+	defer cleanup()
+
+	m := NewSnapshotManager(filename, JSONMarshaler(testMarshaler), time.Hour)
+	test.AssertNil(m.Lookup("foo").Enqueue(newQueueable("foo", "bar")))
+
+	restored := NewSnapshotManager(filename, JSONMarshaler(testMarshaler), 0)
+	test.AssertEqual(restored.Lookup("foo").Size(), 0)
+
+	test.AssertNil(m.Flush())
+	restored = NewSnapshotManager(filename, JSONMarshaler(testMarshaler), 0)
+	assertOrder(test, restored.Lookup("foo"), "foo")
+}
+
+func TestSnapshotConcurrentFlushes(t *testing.T) {
+	test, filename, cleanup := newSnapshotTest(t)
+	defer cleanup()
+
+	m := NewSnapshotManager(filename, JSONMarshaler(testMarshaler), 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			test.AssertNil(m.Lookup("foo").Enqueue(
+				newQueueable(fmt.Sprintf("foo%d", i), "bar")))
+		}(i)
+	}
+	wg.Wait()
+
+	// the last snapshot written holds every entry
+	restored := NewSnapshotManager(filename, JSONMarshaler(testMarshaler), 0)
+	test.AssertEqual(restored.Lookup("foo").Size(), 20)
+}
+
+func newSnapshotTest(t *testing.T) (*test.Test, string, func()) {
+	test := test.New(t)
+	dir, err := ioutil.TempDir("", "xmtpbot-queue")
+	test.AssertNil(err)
+
+	return test, filepath.Join(dir, "queues.json"), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func testMarshaler(data []byte) (Queueable, error) {
+	tq := &testQueueable{}
+	err := json.Unmarshal(data, tq)
+	if err != nil {
+		return nil, err
+	}
+
+	return tq, nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import "sync"
+
+type WaitlistQueue interface {
+	Queue
+
+	// Return the maximum number of active Queueables, 0 meaning unlimited
+	Capacity() int
+
+	// Move Queueables from the waitlist while there's room, returning them
+	Promote() ([]Queueable, error)
+
+	// Return the Queue of Queueables waiting for room
+	Waitlist() Queue
+}
+
+// waitlistQueue is a Queue of limited capacity. Queueables enqueued while it's
+// full (or while others are already waiting) are placed on a waitlist
+// instead, as are those inserted while it's full. Size, List, Position and
+// the like apply only to the active Queueables.
+//
+// Capacity is only enforced among the callers sharing a waitlistQueue, so
+// there should be one per active Queue.
+type waitlistQueue struct {
+	Queue
+	waitlist Queue
+	capacity int
+	mtx      sync.Mutex
+}
+
+var _ WaitlistQueue = (*waitlistQueue)(nil)
+
+// NewWaitlist returns a Queue holding at most capacity Queueables from active,
+// with any others waiting in waitlist. A capacity of 0 is unlimited.
+func NewWaitlist(active, waitlist Queue, capacity int) WaitlistQueue {
+	return &waitlistQueue{
+		Queue:    active,
+		waitlist: waitlist,
+		capacity: capacity,
+	}
+}
+
+func (q *waitlistQueue) Capacity() int {
+	return q.capacity
+}
+
+func (q *waitlistQueue) Clear() error {
+	err := q.Queue.Clear()
+	if err != nil {
+		return err
+	}
+
+	return q.waitlist.Clear()
+}
+
+func (q *waitlistQueue) Enqueue(queueable Queueable) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.waitlist.Position(queueable.Key()) > -1 {
+		return AlreadyQueuedError.New("%s", queueable.Key())
+	}
+	if q.Queue.Position(queueable.Key()) > -1 {
+		return AlreadyQueuedError.New("%s", queueable.Key())
+	}
+	if q.full() || q.waitlist.Size() > 0 {
+		return q.waitlist.Enqueue(queueable)
+	}
+
+	return q.Queue.Enqueue(queueable)
+}
+
+// Insert places the Queueable at the position among the active Queueables or,
+// when the Queue is full, at the position in the waitlist.
+func (q *waitlistQueue) Insert(pos int, queueable Queueable) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.waitlist.Position(queueable.Key()) > -1 {
+		return AlreadyQueuedError.New("%s", queueable.Key())
+	}
+	if q.Queue.Position(queueable.Key()) > -1 {
+		return AlreadyQueuedError.New("%s", queueable.Key())
+	}
+	if q.full() {
+		return q.waitlist.Insert(pos, queueable)
+	}
+
+	return q.Queue.Insert(pos, queueable)
+}
+
+func (q *waitlistQueue) Promote() (promoted []Queueable, err error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for !q.full() && q.waitlist.Size() > 0 {
+		queueables, err := q.waitlist.Dequeue(1)
+		if err != nil {
+			return promoted, err
+		}
+		for _, queueable := range queueables {
+			err = q.Queue.Enqueue(queueable)
+			if err != nil {
+				return promoted, err
+			}
+			promoted = append(promoted, queueable)
+		}
+	}
+
+	return promoted, nil
+}
+
+func (q *waitlistQueue) Remove(key string) (Queueable, error) {
+	queueable, err := q.Queue.Remove(key)
+	if err == nil || !NotFoundError.Contains(err) {
+		return queueable, err
+	}
+
+	return q.waitlist.Remove(key)
+}
+
+func (q *waitlistQueue) Update(key string,
+	fn func(queueable Queueable) error) error {
+
+	err := q.Queue.Update(key, fn)
+	if err == nil || !NotFoundError.Contains(err) {
+		return err
+	}
+
+	return q.waitlist.Update(key, fn)
+}
+
+func (q *waitlistQueue) Waitlist() Queue {
+	return q.waitlist
+}
+
+func (q *waitlistQueue) full() bool {
+	return q.capacity > 0 && q.Queue.Size() >= q.capacity
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"xmtp.net/xmtpbot/test"
+)
+
+func TestWaitlistEnqueue(t *testing.T) {
+	test := test.New(t)
+	q := NewWaitlist(New(), New(), 2)
+
+	test.AssertNil(q.Enqueue(newQueueable("foo", "")))
+	test.AssertNil(q.Enqueue(newQueueable("bar", "")))
+	test.AssertNil(q.Enqueue(newQueueable("baz", "")))
+	test.AssertErrorContains(q.Enqueue(newQueueable("baz", "")),
+		AlreadyQueuedError)
+	test.AssertErrorContains(q.Enqueue(newQueueable("foo", "")),
+		AlreadyQueuedError)
+
+	test.AssertEqual(q.Size(), 2)
+	test.AssertEqual(q.Waitlist().Size(), 1)
+	assertOrder(test, q, "foo", "bar")
+	assertOrder(test, q.Waitlist(), "baz")
+	test.AssertEqual(q.Position("baz"), -1)
+	test.AssertEqual(q.Waitlist().Position("baz"), 1)
+}
+
+func TestWaitlistInsert(t *testing.T) {
+	test := test.New(t)
+	q := NewWaitlist(New(), New(), 2)
+
+	test.AssertNil(q.Enqueue(newQueueable("foo", "")))
+	test.AssertNil(q.Insert(1, newQueueable("bar", "")))
+	test.AssertNil(q.Enqueue(newQueueable("baz", "")))
+	test.AssertNil(q.Insert(1, newQueueable("qux", "")))
+	test.AssertErrorContains(q.Insert(1, newQueueable("baz", "")),
+		AlreadyQueuedError)
+
+	assertOrder(test, q, "bar", "foo")
+	assertOrder(test, q.Waitlist(), "qux", "baz")
+}
+
+func TestWaitlistConcurrentEnqueues(t *testing.T) {
+	test := test.New(t)
+	q := NewWaitlist(New(), New(), 5)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			test.AssertNil(q.Enqueue(newQueueable(fmt.Sprint(i), "")))
+		}(i)
+	}
+	wg.Wait()
+
+	test.AssertEqual(q.Size(), 5)
+	test.AssertEqual(q.Waitlist().Size(), 15)
+}
+
+func TestWaitlistPromote(t *testing.T) {
+	test := test.New(t)
+	q := NewWaitlist(New(), New(), 2)
+
+	for _, id := range []string{"foo", "bar", "baz", "qux"} {
+		test.AssertNil(q.Enqueue(newQueueable(id, "")))
+	}
+	promoted, err := q.Promote()
+	test.AssertNil(err)
+	test.AssertEqual(len(promoted), 0)
+
+	_, err = q.Dequeue(1)
+	test.AssertNil(err)
+	_, err = q.Remove("qux")
+	test.AssertNil(err)
+	promoted, err = q.Promote()
+	test.AssertNil(err)
+	test.AssertEqual(len(promoted), 1)
+	test.AssertEqual(promoted[0].Key(), "baz")
+	assertOrder(test, q, "bar", "baz")
+	test.AssertEqual(q.Waitlist().Size(), 0)
+
+	test.AssertNil(q.Enqueue(newQueueable("quux", "")))
+	test.AssertNil(q.Clear())
+	test.AssertEqual(q.Size(), 0)
+	test.AssertEqual(q.Waitlist().Size(), 0)
+}
+
+func TestWaitlistUnlimited(t *testing.T) {
+	test := test.New(t)
+	q := NewWaitlist(New(), New(), 0)
+
+	for _, id := range []string{"foo", "bar", "baz"} {
+		test.AssertNil(q.Enqueue(newQueueable(id, "")))
+	}
+	test.AssertEqual(q.Size(), 3)
+	test.AssertEqual(q.Waitlist().Size(), 0)
+}
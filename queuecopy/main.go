@@ -0,0 +1,109 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command queuecopy copies the discord bots' queues from one storage backend
+// to another, e.g. to move zenbot from redis to files under its config_dir:
+//
+//	queuecopy -from redis -to file -config_dir ~/.zenbot \
+//		-redis_name discord.zenbot
+//
+// The bot using either backend should be stopped while its queues are copied.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	redis "gopkg.in/redis.v4"
+	"xmtp.net/xmtpbot/discord"
+	"xmtp.net/xmtpbot/queue"
+)
+
+var (
+	from = flag.String("from", "redis",
+		"backend to copy queues from: \"redis\", \"file\" or \"snapshot\"")
+	to = flag.String("to", "file",
+		"backend to copy queues to: \"redis\", \"file\" or \"snapshot\"")
+	configDir = flag.String("config_dir", os.ExpandEnv("$HOME/.zenbot"),
+		"directory in which the file and snapshot backends are stored")
+	redisAddr = flag.String("redis_addr", "localhost:6379",
+		"address of redis server")
+	redisDB   = flag.Int("redis_db", 2, "redis database number")
+	redisName = flag.String("redis_name", "discord.zenbot",
+		"prefix of the redis keys of the queues")
+)
+
+func main() {
+	flag.Parse()
+
+	err := copyQueues()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "queuecopy: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func copyQueues() error {
+	if strings.EqualFold(*from, *to) {
+		return fmt.Errorf("can't copy the %s backend to itself", *from)
+	}
+	src, err := newManager(*from)
+	if err != nil {
+		return err
+	}
+	dst, err := newManager(*to)
+	if err != nil {
+		return err
+	}
+
+	copied, err := queue.Copy(dst, src)
+	if err != nil {
+		return err
+	}
+	if flusher, ok := dst.(interface {
+		Flush() error
+	}); ok {
+		err = flusher.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("copied %d queue entries from %s to %s\n", copied, *from, *to)
+
+	return nil
+}
+
+func newManager(backend string) (queue.Manager, error) {
+	switch strings.ToLower(backend) {
+	case "file":
+		return queue.NewFileManager(path.Join(*configDir, *queue.FileDirname),
+			discord.AuthorMarshaler), nil
+	case "redis":
+		client := redis.NewClient(&redis.Options{
+			Addr: *redisAddr,
+			DB:   *redisDB,
+		})
+		return queue.NewRedisManager(*redisName, client,
+			discord.AuthorMarshaler), nil
+	case "snapshot":
+		return queue.NewSnapshotManager(
+			path.Join(*configDir, *queue.SnapshotFilename),
+			discord.AuthorMarshaler, 0), nil
+	}
+
+	return nil, fmt.Errorf("unknown queue backend %q", backend)
+}
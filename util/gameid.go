@@ -0,0 +1,138 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// GameIdType is a kind of game account id, such as a BattleTag, along with
+// how to recognize one.
+type GameIdType struct {
+	// Name identifies the type in commands and configuration, eg "riot".
+	Name string
+	// Description names an id of this type in messages, eg "Riot ID".
+	Description string
+	// Example is a valid id of this type, for use in messages.
+	Example string
+	// Spaces is set if ids of this type may contain spaces.
+	Spaces bool
+	// Valid reports whether id is an id of this type.
+	Valid func(id string) bool
+}
+
+var (
+	riotIdRe = regexp.MustCompile(
+		"^[\\pL\\pN][\\pL\\pN ]{2,15}#[\\pL\\pN]{3,5}$")
+	steamIdRe = regexp.MustCompile(
+		"^(7656119\\d{10}|STEAM_[0-5]:[01]:\\d{1,10})$")
+	psnIdRe    = regexp.MustCompile("^[A-Za-z][A-Za-z0-9_-]{2,15}$")
+	gamertagRe = regexp.MustCompile("^[A-Za-z][A-Za-z0-9 ]{0,14}(#\\d{1,4})?$")
+
+	BattleTagType = &GameIdType{
+		Name:        "battletag",
+		Description: "BattleTag",
+		Example:     "example#1234",
+		Valid:       ValidBattleTag,
+	}
+	RiotIdType = &GameIdType{
+		Name:        "riot",
+		Description: "Riot ID",
+		Example:     "Example#NA1",
+		Spaces:      true,
+		Valid:       riotIdRe.MatchString,
+	}
+	SteamIdType = &GameIdType{
+		Name:        "steam",
+		Description: "Steam ID",
+		Example:     "76561197960287930",
+		Valid:       steamIdRe.MatchString,
+	}
+	PSNIdType = &GameIdType{
+		Name:        "psn",
+		Description: "PSN online ID",
+		Example:     "example_psn",
+		Valid:       psnIdRe.MatchString,
+	}
+	GamertagType = &GameIdType{
+		Name:        "xbox",
+		Description: "Xbox gamertag",
+		Example:     "Example Tag",
+		Spaces:      true,
+		Valid: func(id string) bool {
+			// spaces may not lead, trail or repeat
+			return gamertagRe.MatchString(id) &&
+				!strings.HasSuffix(id, " ") &&
+				!strings.Contains(id, "  ")
+		},
+	}
+
+	game_id_types_mtx sync.Mutex
+	game_id_types     = make(map[string]*GameIdType)
+)
+
+func init() {
+	for _, t := range []*GameIdType{BattleTagType, RiotIdType, SteamIdType,
+		PSNIdType, GamertagType} {
+		RegisterGameIdType(t)
+	}
+}
+
+// RegisterGameIdType makes the type available to LookupGameIdType, replacing
+// any type of the same name.
+func RegisterGameIdType(t *GameIdType) {
+	game_id_types_mtx.Lock()
+	defer game_id_types_mtx.Unlock()
+
+	game_id_types[strings.ToLower(t.Name)] = t
+}
+
+// LookupGameIdType returns the registered type of the given name, or nil if
+// there's none.
+func LookupGameIdType(name string) *GameIdType {
+	game_id_types_mtx.Lock()
+	defer game_id_types_mtx.Unlock()
+
+	return game_id_types[strings.ToLower(name)]
+}
+
+// GameIdTypes returns the sorted names of the registered types.
+func GameIdTypes() []string {
+	game_id_types_mtx.Lock()
+	defer game_id_types_mtx.Unlock()
+
+	names := make([]string, 0, len(game_id_types))
+	for name := range game_id_types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Parse returns the first id of this type found in s, such as a nickname, or
+// "" if there's none. Ids containing spaces aren't found.
+func (t *GameIdType) Parse(s string) string {
+	for _, word := range strings.Split(s, " ") {
+		if t.Valid(word) {
+			return word
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 Eric Wollesen <ericw at xmtp dot net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"xmtp.net/xmtpbot/test"
+)
+
+func TestGameIdTypes(t *testing.T) {
+	test := test.New(t)
+	test.AssertEqual(strings.Join(GameIdTypes(), ","),
+		"battletag,psn,riot,steam,xbox")
+	test.Assert(LookupGameIdType("Riot") == RiotIdType)
+	test.Assert(LookupGameIdType("origin") == nil)
+
+	for _, t := range []*GameIdType{BattleTagType, RiotIdType, SteamIdType,
+		PSNIdType, GamertagType} {
+		test.Assert(t.Valid(t.Example), t.Name, "example")
+	}
+
+	test.Assert(RiotIdType.Valid("Some Name#EUW"))
+	test.Assert(!RiotIdType.Valid("Some Name"), "no tagline")
+	test.Assert(!RiotIdType.Valid("ab#EUW"), "name too short")
+	test.Assert(!RiotIdType.Valid("Some Name#EUWEST"), "tagline too long")
+
+	test.Assert(SteamIdType.Valid("STEAM_0:1:12345"))
+	test.Assert(!SteamIdType.Valid("1234"), "not a SteamID64")
+
+	test.Assert(PSNIdType.Valid("some-one_99"))
+	test.Assert(!PSNIdType.Valid("9lives"), "can't start with a digit")
+	test.Assert(!PSNIdType.Valid("so"), "too short")
+
+	test.Assert(GamertagType.Valid("Major Nelson"))
+	test.Assert(GamertagType.Valid("Example#1234"))
+	test.Assert(!GamertagType.Valid("Major  Nelson"), "repeated spaces")
+	test.Assert(!GamertagType.Valid("Major Nelson "), "trailing space")
+	test.Assert(!GamertagType.Valid("Waaaaaaaaaaaaaaay"), "too long")
+
+	test.AssertEqual(PSNIdType.Parse("[tank] some_one"), "some_one")
+	test.AssertEqual(BattleTagType.Parse("dps example#1234"), "example#1234")
+	test.AssertEqual(SteamIdType.Parse("no id here"), "")
+}
@@ -34,6 +34,14 @@ func init() {
 	spacelog_setup.MustSetup("util")
 }
 
+func Capitalize(input string) string {
+	if input == "" {
+		return input
+	}
+
+	return strings.ToUpper(input[:1]) + input[1:]
+}
+
 func EscapeMarkdown(input string) string {
 	input = strings.Replace(input, "_", "\\_", -1)
 	input = strings.Replace(input, "*", "\\*", -1)
@@ -52,14 +60,7 @@ func RandomState(bytes int) (state string, err error) {
 }
 
 func ParseBattleTag(s string) string {
-	words := strings.Split(s, " ")
-	for _, word := range words {
-		if ValidBattleTag(word) {
-			return word
-		}
-	}
-
-	return ""
+	return BattleTagType.Parse(s)
 }
 
 func ValidBattleTag(btag string) bool {
@@ -24,13 +24,17 @@ import (
 	"github.com/spacemonkeygo/flagfile"
 	"github.com/spacemonkeygo/spacelog"
 	spacelog_setup "github.com/spacemonkeygo/spacelog/setup"
+	"xmtp.net/xmtpbot/audit"
+	audit_setup "xmtp.net/xmtpbot/audit/setup"
 	"xmtp.net/xmtpbot/discord"
 	"xmtp.net/xmtpbot/http_server"
 	"xmtp.net/xmtpbot/http_status"
 	"xmtp.net/xmtpbot/mildred"
+	"xmtp.net/xmtpbot/queue"
 	"xmtp.net/xmtpbot/remind"
 	seen_setup "xmtp.net/xmtpbot/seen/setup"
 	"xmtp.net/xmtpbot/slack"
+	"xmtp.net/xmtpbot/store"
 	"xmtp.net/xmtpbot/twitch"
 	urls_setup "xmtp.net/xmtpbot/urls/setup"
 )
@@ -54,6 +58,9 @@ func main() {
 	shutdown := make(chan bool)
 	http_server := http_server.New()
 	http_status := http_status.New(http_server)
+	queues := queue.NewSnapshotManager(
+		path.Join(*configDir, *queue.SnapshotFilename),
+		discord.AuthorMarshaler, *queue.SnapshotInterval)
 	var wg sync.WaitGroup
 
 	discord_bot := discord.New(
@@ -64,7 +71,9 @@ func main() {
 		twitch.Setup(*configDir),
 		http_server,
 		http_status,
-		nil)
+		queues,
+		store.New(path.Join(*configDir, "discord.json")),
+		audit_setup.NewLog(path.Join(*configDir, *audit.StoreFilename)))
 	logger.Errore(discord_bot.Run(shutdown, &wg))
 
 	slack_bot := slack.New(
@@ -83,6 +92,7 @@ func main() {
 	logger.Infof("interrupt received")
 	close(shutdown)
 	wg.Wait()
+	logger.Errore(queues.Flush())
 }
 
 func loadFlags() {
@@ -19,18 +19,22 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"strings"
 	"sync"
 
 	"github.com/spacemonkeygo/flagfile"
 	"github.com/spacemonkeygo/spacelog"
 	spacelog_setup "github.com/spacemonkeygo/spacelog/setup"
 	redis "gopkg.in/redis.v4"
+	"xmtp.net/xmtpbot/audit"
+	audit_setup "xmtp.net/xmtpbot/audit/setup"
 	"xmtp.net/xmtpbot/discord"
 	"xmtp.net/xmtpbot/http_server"
 	"xmtp.net/xmtpbot/http_status"
 	"xmtp.net/xmtpbot/queue"
 	"xmtp.net/xmtpbot/remind"
 	seen_setup "xmtp.net/xmtpbot/seen/setup"
+	"xmtp.net/xmtpbot/store"
 	urls_setup "xmtp.net/xmtpbot/urls/setup"
 )
 
@@ -39,6 +43,9 @@ var (
 		"directory in which to store config and state")
 	redisAddr = flag.String("discord.redis_addr", "localhost:6379",
 		"address of redis server")
+	queueBackend = flag.String("discord.queue_backend", "redis",
+		"queue storage backend type: \"redis\", or \"file\" to store "+
+			"queues under config_dir without a redis server")
 	defaultFlagfile = path.Join(*configDir, "config")
 
 	logger = spacelog.GetLoggerNamed("zenbeta")
@@ -53,9 +60,7 @@ func main() {
 	shutdown := make(chan bool)
 	http_server := http_server.New()
 	http_status := http_status.New(http_server)
-	redis_client := redis.NewClient(&redis.Options{Addr: *redisAddr, DB: 2})
-	queues := queue.NewRedisManager("discord.zenbeta", redis_client,
-		discord.AuthorMarshaler)
+	queues := newQueueManager()
 	var wg sync.WaitGroup
 
 	discord_bot := discord.New(
@@ -66,7 +71,9 @@ func main() {
 		nil,
 		http_server,
 		http_status,
-		queues)
+		queues,
+		store.New(path.Join(*configDir, "discord.json")),
+		audit_setup.NewLog(path.Join(*configDir, *audit.StoreFilename)))
 	logger.Errore(discord_bot.Run(shutdown, &wg))
 	logger.Errore(http_status.Run(shutdown, &wg))
 
@@ -78,6 +85,21 @@ func main() {
 	wg.Wait()
 }
 
+func newQueueManager() queue.Manager {
+	switch strings.ToLower(*queueBackend) {
+	case "file":
+		return queue.NewFileManager(path.Join(*configDir, *queue.FileDirname),
+			discord.AuthorMarshaler)
+	case "redis":
+	default:
+		logger.Warnf("unknown queue backend %q; using redis", *queueBackend)
+	}
+
+	redis_client := redis.NewClient(&redis.Options{Addr: *redisAddr, DB: 2})
+	return queue.NewRedisManager("discord.zenbeta", redis_client,
+		discord.AuthorMarshaler)
+}
+
 func loadFlags() {
 	_, err := os.Stat(defaultFlagfile)
 	if err == nil {